@@ -0,0 +1,164 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"zadatak-filip-janjesic/internal/db"     // Importing the db package where DB connection and functions are defined
+	"zadatak-filip-janjesic/internal/keys"   // Importing the keyring used to sign and verify JWTs
+	"zadatak-filip-janjesic/internal/models" // Importing models for the signing key struct
+
+	"github.com/joho/godotenv" // Importing godotenv for loading .env variables
+	"gorm.io/gorm"             // Importing GORM for transactions
+)
+
+// usage describes the available subcommands.
+const usage = `usage: admin <command> [flags]
+
+commands:
+  generate-key          generate a new signing key; it signs all new tokens
+  list-keys             list the signing keys that are still known
+  retire-key            stop a key from signing and drop it after a grace period
+  unlock-user           lift a login lockout and forget failed attempts
+  set-role              assign a role to a user, e.g. to create the first administrator
+  rebuild-search-index  reindex all notes for full-text search`
+
+func main() {
+	// Load the environment variables from the .env file, if there is one
+	_ = godotenv.Load()
+
+	generateCmd := flag.NewFlagSet("generate-key", flag.ExitOnError)
+	generateAlg := generateCmd.String("alg", keys.AlgorithmEdDSA, "signing algorithm (EdDSA or RS256)")
+
+	listCmd := flag.NewFlagSet("list-keys", flag.ExitOnError)
+
+	retireCmd := flag.NewFlagSet("retire-key", flag.ExitOnError)
+	retireKID := retireCmd.String("kid", "", "ID of the key to retire")
+	retireGrace := retireCmd.Duration("grace", 15*time.Minute, "how long the key keeps verifying tokens; at least the access token lifetime")
+
+	unlockCmd := flag.NewFlagSet("unlock-user", flag.ExitOnError)
+	unlockUsername := unlockCmd.String("username", "", "username of the account to unlock")
+	unlockIP := unlockCmd.String("ip", "", "also forget the failed attempts from this client IP address")
+
+	setRoleCmd := flag.NewFlagSet("set-role", flag.ExitOnError)
+	setRoleUsername := setRoleCmd.String("username", "", "username of the account")
+	setRoleName := setRoleCmd.String("role", models.RoleAdmin, "name of the role to assign")
+
+	rebuildSearchCmd := flag.NewFlagSet("rebuild-search-index", flag.ExitOnError)
+
+	if len(os.Args) < 2 {
+		fmt.Println(usage)
+		os.Exit(1)
+	}
+
+	// Open the same database the API uses
+	database, err := db.InitGormDB()
+	if err != nil {
+		log.Fatalf("Error connecting to the database: %v", err)
+	}
+
+	switch os.Args[1] {
+	case "generate-key":
+		generateCmd.Parse(os.Args[2:])
+		key, err := keys.Generate(database, *generateAlg)
+		if err != nil {
+			log.Fatalf("Error generating key: %v", err)
+		}
+		fmt.Printf("Generated %s key %s; running servers start signing with it on their next keyring reload\n", key.Algorithm, key.KID)
+
+	case "list-keys":
+		listCmd.Parse(os.Args[2:])
+		var records []models.SigningKey
+		if err := database.Order("id DESC").Find(&records).Error; err != nil {
+			log.Fatalf("Error listing keys: %v", err)
+		}
+		signing := true // Keys are listed newest first, so the first active key signs
+		for _, record := range records {
+			status := "verifying"
+			if record.ExpiresAt != nil && record.ExpiresAt.Before(time.Now()) {
+				status = "expired"
+			} else if record.RetiredAt != nil {
+				status = "retired, verifies until " + record.ExpiresAt.Format(time.RFC3339)
+			} else if signing {
+				status = "signing"
+				signing = false
+			}
+			fmt.Printf("%s  %-6s  created %s  %s\n", record.KID, record.Algorithm, record.CreatedAt.Format(time.RFC3339), status)
+		}
+
+	case "retire-key":
+		retireCmd.Parse(os.Args[2:])
+		if *retireKID == "" {
+			log.Fatalf("retire-key requires -kid")
+		}
+		if err := keys.Retire(database, *retireKID, *retireGrace); err != nil {
+			log.Fatalf("Error retiring key: %v", err)
+		}
+		fmt.Printf("Retired key %s; it verifies tokens for another %s\n", *retireKID, *retireGrace)
+
+	case "unlock-user":
+		unlockCmd.Parse(os.Args[2:])
+		if *unlockUsername == "" && *unlockIP == "" {
+			log.Fatalf("unlock-user requires -username or -ip")
+		}
+		if *unlockUsername != "" {
+			var user models.User
+			if err := database.Where("username = ?", *unlockUsername).First(&user).Error; err != nil {
+				log.Fatalf("Error finding user %s: %v", *unlockUsername, err)
+			}
+			if err := models.UnlockUser(database, user); err != nil {
+				log.Fatalf("Error unlocking user: %v", err)
+			}
+			if err := models.RecordAudit(database, 0, "user.unlock", &user.ID, "cli", nil); err != nil {
+				log.Printf("Error recording audit entry: %v", err)
+			}
+			fmt.Printf("Unlocked user %s\n", user.Username)
+		}
+		if *unlockIP != "" {
+			if err := models.ClearLoginThrottle(database, models.IPThrottleKey(*unlockIP)); err != nil {
+				log.Fatalf("Error clearing failed attempts: %v", err)
+			}
+			fmt.Printf("Cleared failed login attempts from %s\n", *unlockIP)
+		}
+
+	case "set-role":
+		setRoleCmd.Parse(os.Args[2:])
+		if *setRoleUsername == "" {
+			log.Fatalf("set-role requires -username")
+		}
+		var role models.Role
+		if err := database.Where("name = ?", *setRoleName).First(&role).Error; err != nil {
+			log.Fatalf("Error finding role %s: %v", *setRoleName, err)
+		}
+		var user models.User
+		if err := database.Where("username = ?", *setRoleUsername).First(&user).Error; err != nil {
+			log.Fatalf("Error finding user %s: %v", *setRoleUsername, err)
+		}
+		previous := user.Role
+		err := database.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&user).Update("role", role.Name).Error; err != nil {
+				return err
+			}
+			// Changes made from the command line have no acting user; actor 0 marks them in the audit trail
+			return models.RecordAudit(tx, 0, "user.role_change", &user.ID, "cli", map[string]interface{}{"from": previous, "to": role.Name})
+		})
+		if err != nil {
+			log.Fatalf("Error assigning role: %v", err)
+		}
+		fmt.Printf("Assigned role %s to user %s\n", role.Name, user.Username)
+
+	case "rebuild-search-index":
+		rebuildSearchCmd.Parse(os.Args[2:])
+		if err := models.RebuildNoteSearchIndex(database); err != nil {
+			log.Fatalf("Error rebuilding the search index: %v", err)
+		}
+		fmt.Println("Rebuilt the note search index")
+
+	default:
+		fmt.Println(usage)
+		os.Exit(1)
+	}
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"errors"
 	"log"
 	"os"
 
 	"zadatak-filip-janjesic/internal/db"       // Importing the db package where DB connection and functions are defined
 	"zadatak-filip-janjesic/internal/handlers" // Importing handlers to manage the routes and logic for the API
+	"zadatak-filip-janjesic/internal/keys"     // Importing the keyring used to sign and verify JWTs
+	"zadatak-filip-janjesic/internal/mail"     // Importing the mailer used for account emails
+	"zadatak-filip-janjesic/internal/models"   // Importing models for the permission names
+	"zadatak-filip-janjesic/internal/oidc"     // Importing the OpenID Connect client for single sign-on
+	"zadatak-filip-janjesic/internal/password" // Importing password hashing and the password policy
 
 	"github.com/gofiber/fiber/v2" // Importing the Fiber framework for routing and web server functionality
 	"github.com/joho/godotenv"    // Importing godotenv for loading .env variables
@@ -34,13 +40,64 @@ func main() {
 		log.Fatalf("Error connecting to the database: %v", errDb) // Log and exit if there’s an error with the DB connection
 	}
 
+	// Load the JWT signing keys, generating the first key on a fresh database
+	keyring, err := keys.NewKeyring(database)
+	if err != nil {
+		log.Fatalf("Error loading signing keys: %v", err)
+	}
+	if !keyring.HasSigningKey() {
+		algorithm := os.Getenv("JWT_SIGNING_ALG")
+		if algorithm == "" {
+			algorithm = keys.AlgorithmEdDSA // Default to Ed25519 keys
+		}
+		if _, err := keys.Generate(database, algorithm); err != nil {
+			log.Fatalf("Error generating signing key: %v", err)
+		}
+		if err := keyring.Reload(); err != nil {
+			log.Fatalf("Error loading signing keys: %v", err)
+		}
+		log.Printf("Generated a new %s signing key", algorithm)
+	}
+	handlers.SetKeyring(keyring)
+
+	// Set up the mailer used for account emails (MAIL_DRIVER: outbox or smtp)
+	mailer, err := mail.NewFromEnv()
+	if err != nil {
+		log.Fatalf("Error setting up the mailer: %v", err)
+	}
+	handlers.SetMailer(mailer)
+
+	// Set up password hashing (PASSWORD_HASHER: argon2id or bcrypt) and the password policy
+	hasher, err := password.NewFromEnv()
+	if err != nil {
+		log.Fatalf("Error setting up password hashing: %v", err)
+	}
+	handlers.SetPasswordHasher(hasher)
+	policy, err := password.PolicyFromEnv()
+	if err != nil {
+		log.Fatalf("Error setting up the password policy: %v", err)
+	}
+	handlers.SetPasswordPolicy(policy)
+
+	// Set up single sign-on through an OpenID Connect provider, if OIDC_ISSUER is set
+	provider, err := oidc.NewFromEnv(handlers.OIDCCallbackURL())
+	switch {
+	case err == nil:
+		handlers.SetOIDCProvider(provider)
+	case errors.Is(err, oidc.ErrNotConfigured):
+		log.Println("Single sign-on is not configured")
+	default:
+		log.Fatalf("Error setting up single sign-on: %v", err)
+	}
+
+	// Start the periodic maintenance jobs (e.g. purging expired token revocations)
+	handlers.StartBackgroundJobs(database)
+
 	// Create a new instance of the Fiber app to set up the API routes
 	app := fiber.New()
 
 	// Middleware for validation: apply to POST and PUT routes
 	app.Use("/register", handlers.ValidateUser)
-	app.Use("/login", handlers.ValidateLogin)
-	app.Use("/notes", handlers.ValidateNote)
 
 	// Define the API routes by calling the defineRoutes function
 	defineRoutes(app)
@@ -57,18 +114,165 @@ func defineRoutes(app *fiber.App) {
 	// Set up the route for user registration (POST request to /register)
 	app.Post("/register", handlers.Register(database))
 
-	// Set up the route for user login (POST request to /login)
-	app.Post("/login", handlers.Login(database)) // Pass the `database` here
+	// Set up the route for user login (POST request to /login); the credentials are validated first
+	app.Post("/login", handlers.ValidateLogin, handlers.Login(database)) // Pass the `database` here
+
+	// Set up the routes for completing a login with a second factor, and for using a passkey as that factor
+	app.Post("/login/2fa", handlers.CompleteTwoFactorLogin(database))
+	app.Post("/login/2fa/passkey", handlers.BeginPasskeySecondFactor(database))
+
+	// Set up the routes for passwordless login: requesting a magic link by email, and following it
+	app.Post("/login/magic", handlers.RequestMagicLink(database))
+	app.Get("/login/magic/verify", handlers.VerifyMagicLink(database))
+	app.Post("/login/magic/verify", handlers.VerifyMagicLink(database))
+
+	// Set up the routes for passwordless login with a passkey
+	app.Post("/login/passkey/begin", handlers.BeginPasskeyLogin(database))
+	app.Post("/login/passkey/finish", handlers.FinishPasskeyLogin(database))
+
+	// Set up the route publishing the public keys used to verify our tokens (GET request to /.well-known/jwks.json)
+	app.Get("/.well-known/jwks.json", handlers.JWKS)
+
+	// Set up the route for exchanging a refresh token for a new token pair (POST request to /token/refresh)
+	app.Post("/token/refresh", handlers.RefreshTokenHandler(database))
+
+	// Set up the routes for requesting a password reset email and setting a new password with its token;
+	// the emailed link opens the page, which posts the form
+	app.Post("/password/forgot", handlers.ForgotPassword(database))
+	app.Get("/password/reset", handlers.ResetPasswordPage)
+	app.Post("/password/reset", handlers.ResetPassword(database))
+
+	// Set up the routes for confirming an email address and requesting a new verification email; the
+	// emailed link opens the page, which posts the token
+	app.Get("/email/verify", handlers.VerifyEmailPage)
+	app.Post("/email/verify", handlers.VerifyEmail(database))
+	app.Post("/email/resend", handlers.ResendVerificationEmail(database))
 
-	// Set up the route for retrieving user information (GET request to /me) - Protected with AuthMiddleware
-	// Pass the `database` to AuthMiddleware
-	app.Get("/me", handlers.AuthMiddleware(database), handlers.GetMe)
+	// Set up the routes for confirming a new email address with the token sent to it; the emailed link
+	// opens the page, which posts the token
+	app.Get("/email/change/confirm", handlers.ConfirmEmailChangePage)
+	app.Post("/email/change/confirm", handlers.ConfirmEmailChange(database))
 
-	// Set up routes for notes management
-	app.Get("/notes", handlers.NotesHandler(database))  // GET request to /notes retrieves the list of notes
-	app.Post("/notes", handlers.NotesHandler(database)) // POST request to /notes creates a new note
+	// Set up the routes for single sign-on through the OpenID Connect provider
+	app.Get("/auth/oidc/login", handlers.OIDCLogin(database))
+	app.Get("/auth/oidc/callback", handlers.OIDCCallback(database))
+
+	// Set up the OAuth2 token endpoint, where third-party apps redeem authorization codes and refresh tokens
+	app.Post("/oauth/token", handlers.OAuthToken(database))
+
+	// Set up the routes for unlocking an account with the token from the lockout email; the emailed link
+	// opens the page, which posts the token
+	app.Get("/account/unlock", handlers.UnlockAccountPage)
+	app.Post("/account/unlock", handlers.UnlockAccount(database))
+
+	// Every route under these prefixes requires a valid access token or session cookie. AuthMiddleware
+	// attaches the authenticated Principal to the request, so it must stay below all public routes.
+	// State-changing requests of browser sessions must also carry the session's CSRF token. The middleware
+	// is mounted on the prefixes only, so that unknown paths elsewhere get a 404 instead of a 401; a new
+	// protected route must sit under one of them. Prefixes match plainly, so /logout covers /logout-all.
+	auth := handlers.AuthMiddleware(database)
+	for _, prefix := range []string{"/me", "/logout", "/oauth/authorize", "/oauth/clients", "/admin", "/notes", "/tags", "/notebooks"} {
+		app.Use(prefix, auth, handlers.CSRFProtection)
+	}
+	protected := app.Group("/")
+
+	// Set up the route for retrieving user information (GET request to /me)
+	protected.Get("/me", handlers.RequireScope(handlers.ScopeProfileRead), handlers.GetMe)
+
+	// Routes that manage the account itself are only available to login sessions, never to personal access tokens
+	account := handlers.RequireScope(handlers.ScopeAccount)
+
+	// Set up the route for changing profile fields of the user (PATCH request to /me)
+	protected.Patch("/me", account, handlers.UpdateMe(database))
+
+	// Set up the routes for exporting all data of the user, and for deleting the account after a grace period
+	protected.Get("/me/export", account, handlers.ExportMe(database))
+	protected.Delete("/me", account, handlers.DeleteMe(database))
+	protected.Post("/me/deletion/cancel", account, handlers.CancelAccountDeletion(database))
+
+	// Set up the routes for revoking the current token (POST /logout) and every token of the user (POST /logout-all)
+	protected.Post("/logout", account, handlers.Logout(database))
+	protected.Post("/logout-all", account, handlers.LogoutAll(database))
+
+	// Set up the routes for changing the password and the email address; both require the current password
+	protected.Post("/me/password", account, handlers.ChangePassword(database))
+	protected.Post("/me/email", account, handlers.ChangeEmail(database))
+
+	// Set up the routes for enrolling in, confirming and disabling two-factor authentication
+	protected.Post("/me/2fa/enroll", account, handlers.EnrollTwoFactor(database))
+	protected.Post("/me/2fa/confirm", account, handlers.ConfirmTwoFactor(database))
+	protected.Post("/me/2fa/disable", account, handlers.DisableTwoFactor(database))
+
+	// Set up the routes for registering, listing and removing passkeys
+	protected.Post("/me/passkeys/register/begin", account, handlers.BeginPasskeyRegistration(database))
+	protected.Post("/me/passkeys/register/finish", account, handlers.FinishPasskeyRegistration(database))
+	protected.Get("/me/passkeys", account, handlers.ListPasskeys(database))
+	protected.Delete("/me/passkeys/:id", account, handlers.DeletePasskey(database))
+
+	// Set up the routes for listing the active sessions and revoking one of them
+	protected.Get("/me/sessions", account, handlers.ListSessions(database))
+	protected.Delete("/me/sessions/:id", account, handlers.RevokeSession(database))
+
+	// Set up the routes for listing, creating and revoking personal access tokens
+	protected.Get("/me/tokens", account, handlers.ListPersonalAccessTokens(database))
+	protected.Post("/me/tokens", account, handlers.CreatePersonalAccessToken(database))
+	protected.Delete("/me/tokens/:id", account, handlers.RevokePersonalAccessToken(database))
+
+	// Set up the OAuth2 authorization endpoint: the consent screen and the user's decision
+	protected.Get("/oauth/authorize", account, handlers.OAuthAuthorize(database))
+	protected.Post("/oauth/authorize", account, handlers.OAuthDecide(database))
+
+	// Set up the routes for registering third-party apps (OAuth clients) and for reviewing and revoking
+	// the apps the user has authorized
+	protected.Get("/oauth/clients", account, handlers.ListOAuthClients(database))
+	protected.Post("/oauth/clients", account, handlers.CreateOAuthClient(database))
+	protected.Delete("/oauth/clients/:id", account, handlers.DeleteOAuthClient(database))
+	protected.Get("/me/apps", account, handlers.ListAuthorizedApps(database))
+	protected.Delete("/me/apps/:id", account, handlers.RevokeAuthorizedApp(database))
+
+	// Set up the admin API; every route requires a permission of the user's role, and every change is audited
+	admin := protected.Group("/admin")
+	admin.Get("/users", handlers.RequirePermission(database, models.PermissionUsersRead), handlers.AdminListUsers(database))
+	admin.Get("/users/:id", handlers.RequirePermission(database, models.PermissionUsersRead), handlers.AdminGetUser(database))
+	admin.Post("/users/:id/disable", handlers.RequirePermission(database, models.PermissionUsersManage), handlers.AdminDisableUser(database))
+	admin.Post("/users/:id/enable", handlers.RequirePermission(database, models.PermissionUsersManage), handlers.AdminEnableUser(database))
+	admin.Post("/users/:id/force-password-reset", handlers.RequirePermission(database, models.PermissionUsersManage), handlers.AdminForcePasswordReset(database))
+	admin.Post("/users/:id/impersonate", handlers.RequirePermission(database, models.PermissionUsersImpersonate), handlers.AdminImpersonateUser(database))
+	admin.Put("/users/:id/role", handlers.RequirePermission(database, models.PermissionRolesManage), handlers.AdminSetUserRole(database))
+	admin.Get("/roles", handlers.RequirePermission(database, models.PermissionRolesManage), handlers.AdminListRoles(database))
+	admin.Post("/roles", handlers.RequirePermission(database, models.PermissionRolesManage), handlers.AdminCreateRole(database))
+	admin.Put("/roles/:id", handlers.RequirePermission(database, models.PermissionRolesManage), handlers.AdminUpdateRole(database))
+	admin.Delete("/roles/:id", handlers.RequirePermission(database, models.PermissionRolesManage), handlers.AdminDeleteRole(database))
+	admin.Get("/audit", handlers.RequirePermission(database, models.PermissionAuditRead), handlers.ListAuditLog(database))
+
+	// Set up routes for notes management; changes may require a verified email address, and
+	// the bodies of new and updated notes are validated first. Each route requires the matching scope.
+	protected.Use("/notes", handlers.RequireVerifiedEmail)
+	protected.Get("/notes", handlers.RequireScope(handlers.ScopeNotesRead), handlers.NotesHandler(database))                          // GET request to /notes retrieves the list of notes
+	protected.Post("/notes", handlers.RequireScope(handlers.ScopeNotesWrite), handlers.ValidateNote, handlers.NotesHandler(database)) // POST request to /notes creates a new note
+
+	// Set up the route for full-text search over notes (GET request to /notes/search?q=...)
+	protected.Get("/notes/search", handlers.RequireScope(handlers.ScopeNotesRead), handlers.SearchNotes(database))
+
+	// Set up routes for the trash: deleted notes stay there until restored, permanently deleted or purged
+	protected.Get("/notes/trash", handlers.RequireScope(handlers.ScopeNotesRead), handlers.ListTrash(database))
+	protected.Post("/notes/:id/restore", handlers.RequireScope(handlers.ScopeNotesWrite), handlers.RestoreNote(database))
 
 	// Set up routes for updating and deleting notes by ID
-	app.Put("/notes/:id", handlers.NotesHandler(database))    // PUT request to /notes/:id updates a specific note
-	app.Delete("/notes/:id", handlers.NotesHandler(database)) // DELETE request to /notes/:id deletes a specific note
+	protected.Put("/notes/:id", handlers.RequireScope(handlers.ScopeNotesWrite), handlers.ValidateNote, handlers.NotesHandler(database)) // PUT request to /notes/:id updates a specific note
+	protected.Delete("/notes/:id", handlers.RequireScope(handlers.ScopeNotesWrite), handlers.NotesHandler(database))                     // DELETE request to /notes/:id moves a note to the trash, or with ?permanent=true deletes it for good
+
+	// Set up routes for the tags on notes; renaming and merging change the tags on all notes at once
+	protected.Use("/tags", handlers.RequireVerifiedEmail)
+	protected.Get("/tags", handlers.RequireScope(handlers.ScopeNotesRead), handlers.ListTags(database))
+	protected.Patch("/tags/:id", handlers.RequireScope(handlers.ScopeNotesWrite), handlers.RenameTag(database))
+	protected.Post("/tags/:id/merge", handlers.RequireScope(handlers.ScopeNotesWrite), handlers.MergeTags(database))
+
+	// Set up routes for nested notebooks; deleting a notebook moves its notes to the trash
+	protected.Use("/notebooks", handlers.RequireVerifiedEmail)
+	protected.Get("/notebooks", handlers.RequireScope(handlers.ScopeNotesRead), handlers.ListNotebooks(database))
+	protected.Post("/notebooks", handlers.RequireScope(handlers.ScopeNotesWrite), handlers.CreateNotebook(database))
+	protected.Get("/notebooks/:id", handlers.RequireScope(handlers.ScopeNotesRead), handlers.GetNotebook(database))
+	protected.Patch("/notebooks/:id", handlers.RequireScope(handlers.ScopeNotesWrite), handlers.UpdateNotebook(database))
+	protected.Delete("/notebooks/:id", handlers.RequireScope(handlers.ScopeNotesWrite), handlers.DeleteNotebook(database))
 }
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"time"
@@ -13,17 +14,39 @@ import (
 
 // InitGormDB opens the SQLite database using GORM and performs any necessary migrations.
 func InitGormDB() (*gorm.DB, error) {
-	// Open the SQLite database using the GORM SQLite driver
-	db, err := gorm.Open(sqlite.Open("./notes.db"), &gorm.Config{})
+	return OpenGormDB("./notes.db")
+}
+
+// OpenGormDB opens the SQLite database at path and migrates it like InitGormDB; tests use it on a
+// temporary file.
+func OpenGormDB(path string) (*gorm.DB, error) {
+	// Open the SQLite database using the GORM SQLite driver. SQLite stores times as text and compares
+	// them as text, so GORM sets CreatedAt, UpdatedAt and DeletedAt in UTC, whatever the server's time zone
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{
+		NowFunc: func() time.Time { return time.Now().UTC() },
+	})
 	if err != nil {
 		return nil, fmt.Errorf("error connecting to the database: %w", err) // Return an error if the database connection fails
 	}
 
-	// Perform database migrations for the User and Note models
-	if err := db.AutoMigrate(&models.User{}, &models.Note{}); err != nil {
+	// Perform database migrations for the User, Note, token and signing key models
+	if err := db.AutoMigrate(&models.User{}, &models.Note{}, &models.Tag{}, &models.Notebook{}, &models.Cache{}, &models.RefreshToken{}, &models.RevokedToken{}, &models.SigningKey{}, &models.OneTimeToken{}, &models.RecoveryCode{}, &models.PersonalAccessToken{}, &models.LoginThrottle{}, &models.Role{}, &models.AuditLog{}, &models.Session{}, &models.ExternalIdentity{}, &models.OIDCLoginState{}, &models.OAuthClient{}, &models.OAuthGrant{}, &models.OAuthAuthorizationCode{}, &models.OAuthRefreshToken{}, &models.MagicLink{}, &models.Passkey{}, &models.WebAuthnChallenge{}); err != nil {
 		return nil, fmt.Errorf("error migrating database: %w", err)
 	}
 
+	// Create the full-text index of notes; the API works without it, except for search
+	if err := models.SetupNoteSearch(db); err != nil {
+		if !errors.Is(err, models.ErrNoteSearchUnavailable) {
+			return nil, fmt.Errorf("error creating the note search index: %w", err)
+		}
+		log.Printf("Warning: %v", err)
+	}
+
+	// Create the built-in user and admin roles
+	if err := models.EnsureBuiltInRoles(db); err != nil {
+		return nil, fmt.Errorf("error creating built-in roles: %w", err)
+	}
+
 	log.Println("Database successfully initialized.") // Log successful initialization
 	return db, nil                                    // Return the GORM database connection
 }
@@ -72,8 +95,8 @@ func UpdateNoteInDB(db *gorm.DB, note *models.Note) error {
 
 // SoftDeleteNoteInDB sets the `deleted_at` timestamp to mark a note as deleted using GORM.
 func SoftDeleteNoteInDB(db *gorm.DB, noteID int) error {
-	// Perform a soft delete by setting `deleted_at` to the current time
-	if err := db.Model(&models.Note{}).Where("id = ?", noteID).Update("deleted_at", time.Now()).Error; err != nil {
+	// Perform a soft delete; GORM sets `deleted_at` to the current time
+	if err := db.Delete(&models.Note{}, noteID).Error; err != nil {
 		return fmt.Errorf("error soft deleting note: %w", err)
 	}
 	return nil
@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"zadatak-filip-janjesic/internal/mail"   // Mailer used for the confirmation and notification emails
+	"zadatak-filip-janjesic/internal/models" // Import models for user and token structs
+
+	"github.com/go-playground/validator/v10" // Validator for input validation
+	"github.com/gofiber/fiber/v2"            // Fiber for HTTP handling
+	"gorm.io/gorm"                           // Database operations
+)
+
+// defaultEmailChangeTTL is how long an email change link stays valid unless EMAIL_CHANGE_TTL overrides it.
+const defaultEmailChangeTTL = 24 * time.Hour
+
+// errEmailTaken is returned when the new email address belongs to another account.
+var errEmailTaken = errors.New("email address already in use")
+
+// ChangePassword sets a new password for the authenticated user, who must confirm the current one.
+// Every other session of the user is logged out; the current one stays logged in.
+func ChangePassword(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		var request struct {
+			CurrentPassword string `json:"current_password" validate:"required"`
+			NewPassword     string `json:"new_password" validate:"required"`
+		}
+
+		// Parse and validate the JSON request body
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+		}
+		if err := validator.New().Struct(request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Validation failed: " + err.Error()})
+		}
+
+		user := principal.User
+		if wrong, err := verifyCurrentPassword(c, database, user, request.CurrentPassword); wrong || err != nil {
+			return err
+		}
+
+		// Check the new password against the password policy
+		if err := passwordPolicy.Check(request.NewPassword); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		if request.NewPassword == request.CurrentPassword {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "The new password must differ from the current one"})
+		}
+
+		hashedPassword, err := hashPassword(request.NewPassword)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error while hashing password"})
+		}
+
+		err = database.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+				"password":            hashedPassword,
+				"must_reset_password": false,
+			}).Error; err != nil {
+				return err
+			}
+			if err := models.RevokeOtherSessions(tx, user.ID, principal.SessionID); err != nil {
+				return err
+			}
+			return models.RecordAudit(tx, principal.ActorID(), "user.password_change", &user.ID, c.IP(), nil)
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to change password"})
+		}
+
+		// Let the owner know, in case it was not them
+		if err := sendSecurityNotification(user.Email, user.Username, "Your password was changed",
+			"The password of your account was just changed, and your other sessions were logged out."); err != nil {
+			log.Printf("Error sending password change notification to user %d: %v", user.ID, err)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Password changed; other sessions have been logged out"})
+	}
+}
+
+// ChangeEmail starts a change of the authenticated user's email address, who must confirm the current
+// password. The address is only switched once the link sent to the new address has been opened.
+func ChangeEmail(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		var request struct {
+			Email    string `json:"email" validate:"required,email"`
+			Password string `json:"password" validate:"required"`
+		}
+
+		// Parse and validate the JSON request body
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+		}
+		if err := validator.New().Struct(request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Validation failed: " + err.Error()})
+		}
+		request.Email = strings.TrimSpace(request.Email)
+
+		user := principal.User
+		if wrong, err := verifyCurrentPassword(c, database, user, request.Password); wrong || err != nil {
+			return err
+		}
+
+		if strings.EqualFold(request.Email, user.Email) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "This is already your email address"})
+		}
+		if err := ensureEmailAvailable(database, request.Email, user.ID); err != nil {
+			if errors.Is(err, errEmailTaken) {
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Email address already in use"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error checking email address"})
+		}
+
+		ttl := envDuration("EMAIL_CHANGE_TTL", defaultEmailChangeTTL)
+		var token string
+		err := database.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Update("pending_email", request.Email).Error; err != nil {
+				return err
+			}
+			var err error
+			if token, err = issueOneTimeToken(tx, user.ID, models.PurposeEmailChange, ttl); err != nil {
+				return err
+			}
+			return models.RecordAudit(tx, principal.ActorID(), "user.email_change_request", &user.ID, c.IP(), map[string]interface{}{
+				"to": request.Email,
+			})
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to change email address"})
+		}
+
+		// The confirmation goes to the new address; the current one is told about the request
+		link := appURL("/email/change/confirm", url.Values{"token": {token}})
+		if err := mailer.Send(mail.Message{
+			To:      request.Email,
+			Subject: "Confirm your new email address",
+			Body: fmt.Sprintf("Hello %s,\n\n"+
+				"Please confirm that you want to use this address for your account by opening the link below within %s:\n\n%s\n\n"+
+				"Or send this token to POST /email/change/confirm: %s\n\n"+
+				"If you did not ask for this, you can ignore this email.\n", user.Username, ttl, link, token),
+		}); err != nil {
+			log.Printf("Error sending email change confirmation to user %d: %v", user.ID, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to send confirmation email"})
+		}
+		if err := sendSecurityNotification(user.Email, user.Username, "Your email address is being changed",
+			fmt.Sprintf("Someone asked to change the email address of your account to %s. The change only happens\n"+
+				"once it is confirmed from the new address.", request.Email)); err != nil {
+			log.Printf("Error sending email change notification to user %d: %v", user.ID, err)
+		}
+
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "A confirmation link has been sent to the new address"})
+	}
+}
+
+// ConfirmEmailChangePage is opened by the link in the confirmation email sent to the new address. It posts
+// the token to ConfirmEmailChange.
+func ConfirmEmailChangePage(c *fiber.Ctx) error {
+	return showLinkPage(c, linkPageContent{
+		Title:  "Confirm your new email address",
+		Text:   "Confirm that your account should use this email address from now on.",
+		Button: "Confirm new address",
+	})
+}
+
+// ConfirmEmailChange switches the user's email address to the pending one with the token from the
+// confirmation email. The new address counts as verified, since the user proved they can receive mail there.
+func ConfirmEmailChange(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var request struct {
+			Token string `json:"token" form:"token" validate:"required"`
+		}
+
+		// Parse and validate the JSON request body, or the form of ConfirmEmailChangePage
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+		}
+		if err := validator.New().Struct(request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Validation failed: " + err.Error()})
+		}
+
+		var previous, email string
+		err := database.Transaction(func(tx *gorm.DB) error {
+			record, err := consumeOneTimeToken(tx, request.Token, models.PurposeEmailChange)
+			if err != nil {
+				return err
+			}
+
+			var user models.User
+			if err := tx.First(&user, record.UserID).Error; err != nil {
+				return err
+			}
+			if user.PendingEmail == "" {
+				return errInvalidOneTimeToken
+			}
+			// The address may have been taken since the change was requested
+			if err := ensureEmailAvailable(tx, user.PendingEmail, user.ID); err != nil {
+				return err
+			}
+
+			previous, email = user.Email, user.PendingEmail
+			if err := tx.Model(&user).Updates(map[string]interface{}{
+				"email":             email,
+				"pending_email":     "",
+				"email_verified_at": time.Now(),
+			}).Error; err != nil {
+				return err
+			}
+			return models.RecordAudit(tx, user.ID, "user.email_change", &user.ID, c.IP(), map[string]interface{}{
+				"from": previous,
+				"to":   email,
+			})
+		})
+		if err != nil {
+			switch {
+			case errors.Is(err, errInvalidOneTimeToken):
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired confirmation token"})
+			case errors.Is(err, errEmailTaken):
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Email address already in use"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to change email address"})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Email address changed", "email": email})
+	}
+}
+
+// verifyCurrentPassword checks the current password of the user before a sensitive change. Wrong
+// passwords back off like failed logins, but never lock the account. wrong is true when a response
+// has been written.
+func verifyCurrentPassword(c *fiber.Ctx, database *gorm.DB, user models.User, password string) (wrong bool, err error) {
+	throttleKeys := loginThrottleKeys(user.Username, c.IP())
+	wait, err := checkLoginAllowed(database, throttleKeys, nil)
+	if err != nil {
+		return true, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error checking password attempts"})
+	}
+	if wait > 0 {
+		return true, tooManyLoginAttempts(c, wait)
+	}
+
+	valid, err := checkPassword(database, user, password)
+	if err != nil {
+		log.Printf("Error checking password of user %d: %v", user.ID, err)
+		return true, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error checking password"})
+	}
+	if !valid {
+		if err := handleLoginFailure(database, throttleKeys, nil); err != nil {
+			return true, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error recording password attempt"})
+		}
+		return true, c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Current password is incorrect"})
+	}
+	return false, nil
+}
+
+// ensureEmailAvailable returns errEmailTaken if another user has the email address.
+func ensureEmailAvailable(database *gorm.DB, email string, userID uint) error {
+	var count int64
+	if err := database.Model(&models.User{}).Where("LOWER(email) = LOWER(?) AND id <> ?", email, userID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return errEmailTaken
+	}
+	return nil
+}
+
+// sendSecurityNotification tells a user about a change to their account.
+func sendSecurityNotification(to, username, subject, text string) error {
+	return mailer.Send(mail.Message{
+		To:      to,
+		Subject: subject,
+		Body: fmt.Sprintf("Hello %s,\n\n%s\n\n"+
+			"If this was not you, reset your password right away with POST /password/forgot.\n", username, text),
+	})
+}
@@ -0,0 +1,314 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"zadatak-filip-janjesic/internal/models" // Import models for user, role and token structs
+
+	"github.com/gofiber/fiber/v2" // Fiber for HTTP handling
+	"gorm.io/gorm"                // Database operations
+)
+
+// defaultImpersonationTTL is how long an impersonation token is valid unless IMPERSONATION_TTL overrides it.
+const defaultImpersonationTTL = 15 * time.Minute
+
+// adminUserResponse is the representation of a user in the admin API.
+type adminUserResponse struct {
+	ID                uint       `json:"id"`
+	Username          string     `json:"username"`
+	Email             string     `json:"email"`
+	FirstName         string     `json:"first_name"`
+	LastName          string     `json:"last_name"`
+	Role              string     `json:"role"`
+	CreatedAt         time.Time  `json:"created_at"`
+	EmailVerifiedAt   *time.Time `json:"email_verified_at,omitempty"`
+	TwoFactorEnabled  bool       `json:"two_factor_enabled"`
+	LockedUntil       *time.Time `json:"locked_until,omitempty"`
+	DisabledAt        *time.Time `json:"disabled_at,omitempty"`
+	MustResetPassword bool       `json:"must_reset_password"`
+	DeletionDueAt     *time.Time `json:"deletion_due_at,omitempty"`
+}
+
+// newAdminUserResponse converts a user into its admin API representation.
+func newAdminUserResponse(user models.User) adminUserResponse {
+	response := adminUserResponse{
+		ID:                user.ID,
+		Username:          user.Username,
+		Email:             user.Email,
+		FirstName:         user.FirstName,
+		LastName:          user.LastName,
+		Role:              user.Role,
+		CreatedAt:         user.CreatedAt,
+		EmailVerifiedAt:   user.EmailVerifiedAt,
+		TwoFactorEnabled:  user.TOTPEnabledAt != nil,
+		DisabledAt:        user.DisabledAt,
+		MustResetPassword: user.MustResetPassword,
+		DeletionDueAt:     user.DeletionDueAt,
+	}
+	if user.LockedUntil != nil && user.LockedUntil.After(time.Now()) {
+		response.LockedUntil = user.LockedUntil
+	}
+	return response
+}
+
+// AdminListUsers lists users, optionally searching by name or email (q), role and status
+// ("active", "disabled" or "locked"), with limit and offset for paging.
+func AdminListUsers(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		query := database.Model(&models.User{})
+		if search := strings.TrimSpace(c.Query("q")); search != "" {
+			pattern := "%" + strings.ToLower(search) + "%"
+			query = query.Where("LOWER(username) LIKE ? OR LOWER(email) LIKE ? OR LOWER(first_name) LIKE ? OR LOWER(last_name) LIKE ?",
+				pattern, pattern, pattern, pattern)
+		}
+		if role := c.Query("role"); role != "" {
+			query = query.Where("role = ?", role)
+		}
+		switch c.Query("status") {
+		case "":
+		case "active":
+			query = query.Where("disabled_at IS NULL")
+		case "disabled":
+			query = query.Where("disabled_at IS NOT NULL")
+		case "locked":
+			query = query.Where("locked_until > ?", time.Now())
+		default:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "status must be active, disabled or locked"})
+		}
+
+		var total int64
+		if err := query.Count(&total).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to list users"})
+		}
+
+		limit, offset := pageParams(c)
+		var users []models.User
+		if err := query.Order("id").Limit(limit).Offset(offset).Find(&users).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to list users"})
+		}
+
+		response := make([]adminUserResponse, 0, len(users))
+		for _, user := range users {
+			response = append(response, newAdminUserResponse(user))
+		}
+		return c.JSON(fiber.Map{"users": response, "total": total})
+	}
+}
+
+// AdminGetUser returns a single user.
+func AdminGetUser(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, err := adminTargetUser(database, c)
+		if err != nil {
+			return adminTargetError(c, err)
+		}
+		return c.JSON(newAdminUserResponse(user))
+	}
+}
+
+// AdminDisableUser disables an account and revokes its tokens. The optional reason is kept in the audit trail.
+func AdminDisableUser(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, err := adminTargetUser(database, c)
+		if err != nil {
+			return adminTargetError(c, err)
+		}
+		if isSelf(c, user) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "This action cannot be applied to your own account"})
+		}
+
+		var request struct {
+			Reason string `json:"reason"`
+		}
+		_ = c.BodyParser(&request) // The body is optional
+
+		err = database.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Update("disabled_at", time.Now()).Error; err != nil {
+				return err
+			}
+			if err := revokeAllUserTokens(tx, user.ID); err != nil {
+				return err
+			}
+			return recordAudit(tx, c, "user.disable", &user.ID, fiber.Map{"reason": request.Reason})
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to disable user"})
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// AdminEnableUser enables a disabled account again.
+func AdminEnableUser(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, err := adminTargetUser(database, c)
+		if err != nil {
+			return adminTargetError(c, err)
+		}
+
+		err = database.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Update("disabled_at", nil).Error; err != nil {
+				return err
+			}
+			return recordAudit(tx, c, "user.enable", &user.ID, nil)
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to enable user"})
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// AdminForcePasswordReset logs the user out everywhere, revokes their personal access tokens and refuses
+// logins until the password has been reset through the link that is emailed to them.
+func AdminForcePasswordReset(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, err := adminTargetUser(database, c)
+		if err != nil {
+			return adminTargetError(c, err)
+		}
+		if isSelf(c, user) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "This action cannot be applied to your own account"})
+		}
+
+		err = database.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Update("must_reset_password", true).Error; err != nil {
+				return err
+			}
+			if err := revokeAllUserTokens(tx, user.ID); err != nil {
+				return err
+			}
+			if err := tx.Model(&models.PersonalAccessToken{}).
+				Where("user_id = ? AND revoked_at IS NULL", user.ID).
+				Update("revoked_at", time.Now()).Error; err != nil {
+				return err
+			}
+			return recordAudit(tx, c, "user.force_password_reset", &user.ID, nil)
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to force a password reset"})
+		}
+
+		if err := sendPasswordResetEmail(database, user); err != nil {
+			log.Printf("Error sending password reset email to user %d: %v", user.ID, err)
+			return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+				"message": "Password reset forced, but the email could not be sent; the user can use /password/forgot",
+			})
+		}
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "Password reset forced; the user has been emailed a reset link"})
+	}
+}
+
+// AdminImpersonateUser issues a short-lived access token that acts as the user, for support. The token
+// names the administrator in its "act" claim, cannot be refreshed and cannot manage the account.
+func AdminImpersonateUser(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+		user, err := adminTargetUser(database, c)
+		if err != nil {
+			return adminTargetError(c, err)
+		}
+		if isSelf(c, user) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "This action cannot be applied to your own account"})
+		}
+		if user.DisabledAt != nil {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Cannot impersonate a disabled user"})
+		}
+
+		ttl := envDuration("IMPERSONATION_TTL", defaultImpersonationTTL)
+
+		// The impersonation is recorded before the token exists, so no token is ever issued unaudited
+		if err := recordAudit(database, c, "user.impersonate", &user.ID, fiber.Map{"expires_in": int64(ttl.Seconds())}); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to record audit entry"})
+		}
+
+		jti, err := newOpaqueToken()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error generating token"})
+		}
+		claims := newClaims(user, purposeAccess, jti, time.Now(), ttl)
+		claims.Actor = &Actor{Subject: strconv.FormatUint(uint64(principal.UserID()), 10), UserID: principal.UserID()}
+		token, err := keyring.Sign(claims)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error generating token"})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"token":      token,
+			"token_type": "Bearer",
+			"expires_in": int64(ttl.Seconds()),
+			"user_id":    user.ID,
+		})
+	}
+}
+
+// AdminSetUserRole assigns a role to the user.
+func AdminSetUserRole(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, err := adminTargetUser(database, c)
+		if err != nil {
+			return adminTargetError(c, err)
+		}
+		if isSelf(c, user) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "This action cannot be applied to your own account"})
+		}
+
+		var request struct {
+			Role string `json:"role"`
+		}
+		if err := c.BodyParser(&request); err != nil || request.Role == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "role required"})
+		}
+
+		var role models.Role
+		if err := database.Where("name = ?", request.Role).First(&role).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Unknown role: " + request.Role})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to load role"})
+		}
+
+		err = database.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Update("role", role.Name).Error; err != nil {
+				return err
+			}
+			return recordAudit(tx, c, "user.role_change", &user.ID, fiber.Map{"from": user.Role, "to": role.Name})
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to change role"})
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// adminTargetUser loads the user named by the :id route parameter.
+func adminTargetUser(database *gorm.DB, c *fiber.Ctx) (models.User, error) {
+	var user models.User
+	userID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return user, gorm.ErrRecordNotFound
+	}
+	err = database.First(&user, userID).Error
+	return user, err
+}
+
+// adminTargetError turns an error from adminTargetUser into a response.
+func adminTargetError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error retrieving user data"})
+}
+
+// isSelf reports whether the user is the administrator making the request.
+func isSelf(c *fiber.Ctx, user models.User) bool {
+	principal, ok := CurrentPrincipal(c)
+	return ok && principal.ActorID() == user.ID
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"zadatak-filip-janjesic/internal/models" // Import models for the role struct
+
+	"github.com/go-playground/validator/v10" // Validator for input validation
+	"github.com/gofiber/fiber/v2"            // Fiber for HTTP handling
+	"gorm.io/gorm"                           // Database operations
+)
+
+// roleResponse is the representation of a role in the admin API.
+type roleResponse struct {
+	ID          uint     `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+	BuiltIn     bool     `json:"built_in"`
+}
+
+// newRoleResponse converts a role into its admin API representation.
+func newRoleResponse(role models.Role) roleResponse {
+	return roleResponse{
+		ID:          role.ID,
+		Name:        role.Name,
+		Description: role.Description,
+		Permissions: append([]string{}, role.PermissionList()...),
+		BuiltIn:     role.BuiltIn,
+	}
+}
+
+// roleRequest is the body accepted when creating or changing a role.
+type roleRequest struct {
+	Name        string   `json:"name" validate:"omitempty,min=2,max=32,alphanum"`
+	Description string   `json:"description" validate:"max=200"`
+	Permissions []string `json:"permissions"`
+}
+
+// unknownPermission returns the first requested permission that does not exist, or "".
+func (r roleRequest) unknownPermission() string {
+	for _, permission := range r.Permissions {
+		if !models.IsPermission(permission) {
+			return permission
+		}
+	}
+	return ""
+}
+
+// AdminListRoles lists the roles and the permissions that can be granted.
+func AdminListRoles(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var roles []models.Role
+		if err := database.Order("id").Find(&roles).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to list roles"})
+		}
+
+		response := make([]roleResponse, 0, len(roles))
+		for _, role := range roles {
+			response = append(response, newRoleResponse(role))
+		}
+		return c.JSON(fiber.Map{"roles": response, "permissions": models.Permissions})
+	}
+}
+
+// AdminCreateRole creates a custom role with a set of permissions.
+func AdminCreateRole(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var request roleRequest
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+		}
+		if err := validator.New().Struct(request); err != nil || request.Name == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "A name of 2 to 32 letters and digits is required"})
+		}
+		if unknown := request.unknownPermission(); unknown != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Unknown permission: " + unknown, "permissions": models.Permissions})
+		}
+
+		role := models.Role{
+			Name:        strings.ToLower(request.Name),
+			Description: request.Description,
+			Permissions: strings.Join(request.Permissions, " "),
+		}
+		err := database.Transaction(func(tx *gorm.DB) error {
+			var count int64
+			if err := tx.Model(&models.Role{}).Where("name = ?", role.Name).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return errRoleExists
+			}
+			if err := tx.Create(&role).Error; err != nil {
+				return err
+			}
+			return recordAudit(tx, c, "role.create", nil, fiber.Map{"role": role.Name, "permissions": request.Permissions})
+		})
+		if err != nil {
+			if errors.Is(err, errRoleExists) {
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Role already exists"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to create role"})
+		}
+		return c.Status(fiber.StatusCreated).JSON(newRoleResponse(role))
+	}
+}
+
+// AdminUpdateRole changes the description and permissions of a custom role.
+// Built-in roles can only get a new description.
+func AdminUpdateRole(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		role, err := adminTargetRole(database, c)
+		if err != nil {
+			return adminRoleError(c, err)
+		}
+
+		var request roleRequest
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+		}
+		if err := validator.New().Struct(request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Validation failed: " + err.Error()})
+		}
+		if request.Name != "" && !strings.EqualFold(request.Name, role.Name) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Roles cannot be renamed"})
+		}
+		if unknown := request.unknownPermission(); unknown != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Unknown permission: " + unknown, "permissions": models.Permissions})
+		}
+		if role.BuiltIn && request.Permissions != nil {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "The permissions of built-in roles cannot be changed"})
+		}
+
+		updates := map[string]interface{}{"description": request.Description}
+		if request.Permissions != nil {
+			updates["permissions"] = strings.Join(request.Permissions, " ")
+		}
+		err = database.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&role).Updates(updates).Error; err != nil {
+				return err
+			}
+			return recordAudit(tx, c, "role.update", nil, fiber.Map{
+				"role":            role.Name,
+				"old_permissions": role.PermissionList(),
+				"permissions":     request.Permissions,
+			})
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to update role"})
+		}
+
+		if err := database.First(&role, role.ID).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to load role"})
+		}
+		return c.JSON(newRoleResponse(role))
+	}
+}
+
+// AdminDeleteRole deletes a custom role that no user has.
+func AdminDeleteRole(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		role, err := adminTargetRole(database, c)
+		if err != nil {
+			return adminRoleError(c, err)
+		}
+		if role.BuiltIn {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Built-in roles cannot be deleted"})
+		}
+
+		err = database.Transaction(func(tx *gorm.DB) error {
+			var users int64
+			if err := tx.Model(&models.User{}).Where("role = ?", role.Name).Count(&users).Error; err != nil {
+				return err
+			}
+			if users > 0 {
+				return errRoleInUse
+			}
+			if err := tx.Unscoped().Delete(&role).Error; err != nil {
+				return err
+			}
+			return recordAudit(tx, c, "role.delete", nil, fiber.Map{"role": role.Name})
+		})
+		if err != nil {
+			if errors.Is(err, errRoleInUse) {
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Role is still assigned to users"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to delete role"})
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// Errors returned from the role transactions.
+var (
+	errRoleExists = errors.New("role already exists")
+	errRoleInUse  = errors.New("role is still assigned")
+)
+
+// adminTargetRole loads the role named by the :id route parameter.
+func adminTargetRole(database *gorm.DB, c *fiber.Ctx) (models.Role, error) {
+	var role models.Role
+	roleID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return role, gorm.ErrRecordNotFound
+	}
+	err = database.First(&role, roleID).Error
+	return role, err
+}
+
+// adminRoleError turns an error from adminTargetRole into a response.
+func adminRoleError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Role not found"})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to load role"})
+}
@@ -1,41 +1,76 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"log"
-	"os"
+	"strings"
 	"time"
+
+	"zadatak-filip-janjesic/internal/keys"   // Keyring for signing and verifying tokens
 	"zadatak-filip-janjesic/internal/models" // Import your models
 
 	"github.com/go-playground/validator/v10" // Validator for input validation
 	"github.com/gofiber/fiber/v2"            // Fiber for HTTP handling
-	"github.com/golang-jwt/jwt/v4"           // JWT for token creation
 	"github.com/joho/godotenv"               // Load environment variables
-	"golang.org/x/crypto/bcrypt"             // Password hashing
 	"gorm.io/gorm"                           // Database operations
 )
 
-// Initialize JWT secret key from environment variables
-var secretKey []byte
+// Keyring used to sign and verify JWTs; set from main with SetKeyring
+var keyring *keys.Keyring
 
 // Initialize environment variables
 func init() {
 	if err := godotenv.Load(); err != nil {
 		fmt.Println("Error loading .env file") // Log error if .env fails to load
 	}
-	secretKey = []byte(os.Getenv("JWT_SECRET"))
+}
+
+// SetKeyring sets the keyring used to sign and verify JWTs.
+func SetKeyring(k *keys.Keyring) {
+	keyring = k
+}
+
+// registration holds the fields of a POST /register request; no other column of the user can be set
+// through it.
+type registration struct {
+	Username    string      `json:"username"`
+	Password    string      `json:"password"`
+	FirstName   string      `json:"first_name"`
+	LastName    string      `json:"last_name"`
+	Email       string      `json:"email"`
+	PhoneNumber string      `json:"phone_number"`
+	City        string      `json:"city"`
+	Country     string      `json:"country"`
+	DateOfBirth models.Date `json:"dateOfBirth"`
 }
 
 // Register handles user registration by validating and creating new user entries
 func Register(database *gorm.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		var user models.User // User struct to hold incoming data
+		var input registration // Fields accepted at registration
 
-		// Parse JSON request body into user struct; return error if parsing fails
-		if err := c.BodyParser(&user); err != nil {
+		// Parse JSON request body into the registration struct; return error if parsing fails
+		if err := c.BodyParser(&input); err != nil {
 			return c.Status(fiber.StatusBadRequest).SendString("Invalid input")
 		}
 
+		// Only the fields of the request are copied; the address is only verified through the emailed
+		// token, roles are only assigned by administrators, and the security and deletion columns keep
+		// their defaults
+		user := models.User{
+			Username:    input.Username,
+			Password:    input.Password,
+			FirstName:   input.FirstName,
+			LastName:    input.LastName,
+			Email:       input.Email,
+			PhoneNumber: input.PhoneNumber,
+			City:        input.City,
+			Country:     input.Country,
+			DateOfBirth: input.DateOfBirth,
+			Role:        models.RoleUser,
+		}
+
 		// Validate the user data
 		if err := models.ValidateUser(user); err != nil {
 			if validationErrors, ok := err.(validator.ValidationErrors); ok {
@@ -48,9 +83,6 @@ func Register(database *gorm.DB) fiber.Handler {
 			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
 		}
 
-		// Log the decoded user data for debugging purposes
-		log.Printf("Decoded user: %+v", user)
-
 		// Check for existing username in the database
 		var existingUser models.User
 		err := database.Where("username = ?", user.Username).First(&existingUser).Error
@@ -61,6 +93,11 @@ func Register(database *gorm.DB) fiber.Handler {
 			return c.Status(fiber.StatusInternalServerError).SendString("Database error")
 		}
 
+		// Check the password against the password policy
+		if err := passwordPolicy.Check(user.Password); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+
 		// Hash password before saving user data
 		hashedPassword, err := hashPassword(user.Password)
 		if err != nil {
@@ -73,102 +110,271 @@ func Register(database *gorm.DB) fiber.Handler {
 			return c.Status(fiber.StatusInternalServerError).SendString("Unable to register user")
 		}
 
-		// Return the created user data as JSON
-		return c.Status(fiber.StatusCreated).JSON(user)
+		// Send the email verification link; the account exists even if sending fails, and the
+		// user can ask for a new link through /email/resend
+		if err := sendVerificationEmail(database, user); err != nil {
+			log.Printf("Error sending verification email to user %d: %v", user.ID, err)
+		}
+
+		// Return the created user's profile as JSON, without the password hash
+		return c.Status(fiber.StatusCreated).JSON(newProfileResponse(user))
 	}
 }
 
-// Login handles user login requests by verifying credentials and generating a JWT if successful
+// Login handles user login requests by verifying credentials and generating a token pair if successful
 func Login(database *gorm.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		var loginData models.User
+		var loginData struct {
+			Username    string `json:"username"`
+			Password    string `json:"password"`
+			DeviceName  string `json:"device_name"`  // Optional name of the device, shown in the session list
+			SessionMode string `json:"session_mode"` // "token" (default) or "cookie" for a browser session
+		}
 
 		// Parse JSON request body into loginData struct
 		if err := c.BodyParser(&loginData); err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
 		}
+		if !isValidSessionMode(loginData.SessionMode) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "session_mode must be \"token\" or \"cookie\""})
+		}
+
+		// Refuse the attempt while the username or the client IP address is backing off
+		throttleKeys := loginThrottleKeys(loginData.Username, c.IP())
+		wait, err := checkLoginAllowed(database, throttleKeys, nil)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error checking login attempts"})
+		}
+		if wait > 0 {
+			return tooManyLoginAttempts(c, wait)
+		}
 
 		// Retrieve user data from database based on provided username
 		var user models.User
 		if err := database.Where("username = ?", loginData.Username).First(&user).Error; err != nil {
 			if err == gorm.ErrRecordNotFound {
+				// Unknown usernames are counted too, so they back off exactly like real ones
+				if err := handleLoginFailure(database, throttleKeys, nil); err != nil {
+					return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error recording login attempt"})
+				}
 				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid username or password"})
 			}
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error retrieving user data"})
 		}
 
-		// Compare hashed password from DB with provided password
-		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(loginData.Password)); err != nil {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid username or password"})
+		// Refuse locked accounts before checking the password
+		if user.LockedUntil != nil && user.LockedUntil.After(time.Now()) {
+			return tooManyLoginAttempts(c, time.Until(*user.LockedUntil))
 		}
 
-		// Generate JWT token upon successful login
-		token, err := generateToken(user)
+		// Compare hashed password from DB with provided password; outdated hashes are upgraded on the way
+		valid, err := checkPassword(database, user, loginData.Password)
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error generating token"})
+			log.Printf("Error checking password of user %d: %v", user.ID, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error checking password"})
+		}
+		if !valid {
+			if err := handleLoginFailure(database, throttleKeys, &user); err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error recording login attempt"})
+			}
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid username or password"})
+		}
+
+		if err := checkLoginAllowedState(user); err != nil {
+			return refuseLogin(c, err)
+		}
+
+		// Users with two-factor authentication get a challenge token instead, which /login/2fa
+		// exchanges for the real token pair together with a valid code
+		if user.TOTPEnabledAt != nil {
+			return respondWithMFAChallenge(c, user)
+		}
+
+		// The login succeeded, so earlier failures on the username no longer count. With two-factor
+		// authentication this only happens once the second factor has been checked as well.
+		if err := models.ClearLoginThrottle(database, models.UsernameThrottleKey(user.Username)); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error recording login attempt"})
 		}
 
-		// Send the generated token in the response
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{"token": token})
+		// Generate a short-lived access token and a refresh token upon successful login, or start a
+		// browser session when the client asked for cookies
+		return respondWithLogin(c, database, user, newSessionInfo(c, loginData.DeviceName), loginData.SessionMode)
+	}
+}
+
+// Reasons why checkLoginAllowedState refuses a login.
+var (
+	errAccountDisabled       = errors.New("account disabled")
+	errPasswordResetRequired = errors.New("password reset required")
+	errEmailNotVerified      = errors.New("email address not verified")
+)
+
+// checkLoginAllowedState checks that the account may log in at all, however the user proved who they are:
+// disabled accounts, accounts whose password has to be reset first and, when the policy requires a verified
+// address to log in, unverified users are refused. Every login path calls it before it hands out tokens,
+// a browser session or a two-factor challenge.
+func checkLoginAllowedState(user models.User) error {
+	switch {
+	case user.DisabledAt != nil:
+		return errAccountDisabled
+	case user.MustResetPassword:
+		return errPasswordResetRequired
+	case user.EmailVerifiedAt == nil && emailVerificationPolicy() == verificationPolicyLogin:
+		return errEmailNotVerified
+	}
+	return nil
+}
+
+// refuseLogin answers a login refused by checkLoginAllowedState.
+func refuseLogin(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, errAccountDisabled):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Account disabled"})
+	case errors.Is(err, errPasswordResetRequired):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Password reset required; use the link sent by email or POST /password/forgot"})
+	default:
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Email address not verified"})
 	}
 }
 
-// AuthMiddleware validates JWT tokens for protected routes
+// AuthMiddleware authenticates every protected route. It accepts JWT access tokens, personal access
+// tokens and session cookies, checks that they have not been revoked, and attaches a Principal to the
+// request context.
 func AuthMiddleware(database *gorm.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		// Retrieve token from Authorization header
-		tokenString := c.Get("Authorization")
+		// Retrieve token from Authorization header, without the "Bearer " prefix
+		tokenString := strings.TrimSpace(strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer "))
+
+		// Without a token, browsers authenticate with the cookie of a browser session
 		if tokenString == "" {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authorization token required"})
+			cookie := c.Cookies(sessionCookie)
+			if cookie == "" {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authorization token required"})
+			}
+			principal, err := authenticateSessionCookie(c, database, cookie)
+			if err != nil {
+				if errors.Is(err, errInvalidSessionCookie) {
+					clearSessionCookies(c)
+					return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Session expired or revoked"})
+				}
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error checking session"})
+			}
+			if principal.User.DisabledAt != nil {
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Account disabled"})
+			}
+			c.Locals(principalKey, principal)
+			return c.Next()
 		}
 
-		// Parse and validate JWT token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return secretKey, nil
-		})
+		// Personal access tokens are looked up in the database instead of being parsed
+		if isPersonalAccessToken(tokenString) {
+			principal, err := authenticatePersonalAccessToken(database, tokenString, c.IP())
+			if err != nil {
+				if errors.Is(err, errInvalidPersonalAccessToken) {
+					return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+				}
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error checking token"})
+			}
+			if principal.User.DisabledAt != nil {
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Account disabled"})
+			}
+			c.Locals(principalKey, principal)
+			return c.Next()
+		}
 
-		// Return error if parsing or validation fails
-		if err != nil || !token.Valid {
+		// Parse and validate JWT token
+		claims, err := parseAccessToken(tokenString)
+		if err != nil {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
 		}
 
-		// Extract user ID from the token claims
-		claims := token.Claims.(jwt.MapClaims)
-		userID := int(claims["user_id"].(float64))
+		// Reject tokens that were revoked through /logout
+		revoked, err := models.IsTokenRevoked(database, claims.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error checking token"})
+		}
+		if revoked {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Token has been revoked"})
+		}
 
 		// Check if the user exists in the database
 		var user models.User
-		if err := database.First(&user, userID).Error; err != nil {
+		if err := database.First(&user, claims.UserID).Error; err != nil {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "User not found"})
 		}
 
-		// Store user data in the context for use in handlers
-		c.Locals("user", user)
+		// Reject tokens issued before the user's last /logout-all (iat has second precision, so the
+		// second in which the tokens were revoked is rejected as well)
+		if user.TokensRevokedAt != nil && claims.IssuedAt.Unix() <= user.TokensRevokedAt.Unix() {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Token has been revoked"})
+		}
+
+		// Disabled accounts cannot use the API until an administrator enables them again
+		if user.DisabledAt != nil {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Account disabled"})
+		}
+
+		// Tokens of a login session stop working once the session is revoked
+		if claims.SessionID != 0 {
+			active, err := touchSession(database, claims.SessionID, c.IP())
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error checking session"})
+			}
+			if !active {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Session has been revoked"})
+			}
+		}
+
+		// Tokens of third-party apps stop working once the user revokes the app's authorization
+		if claims.GrantID != 0 {
+			active, err := touchOAuthGrant(database, claims.GrantID, user.ID)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error checking authorization"})
+			}
+			if !active {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "App authorization has been revoked"})
+			}
+		}
+
+		principal := &Principal{
+			User:      user,
+			TokenID:   claims.ID,
+			SessionID: claims.SessionID,
+			IssuedAt:  claims.IssuedAt.Time,
+			ExpiresAt: claims.ExpiresAt.Time,
+		}
+
+		// Tokens of third-party apps are limited to the scopes the user authorized
+		if claims.GrantID != 0 {
+			principal.OAuthGrantID = claims.GrantID
+			principal.Scopes = append([]string{}, strings.Fields(claims.Scope)...)
+		}
+
+		// Impersonation sessions are limited to what the user can do with notes and their profile
+		if claims.Actor != nil {
+			principal.ImpersonatorID = claims.Actor.UserID
+			principal.Scopes = append([]string{}, grantableScopes...)
+		}
+
+		// Store the authenticated principal in the context for use in handlers
+		c.Locals(principalKey, principal)
 
 		return c.Next()
 	}
 }
 
-// hashPassword hashes a password using bcrypt
-func hashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+// generateToken generates a JWT access token for the given user and session
+func generateToken(user models.User, sessionID uint) (string, error) {
+	// Every token gets a unique ID (jti) so that it can be revoked individually
+	jti, err := newOpaqueToken()
 	if err != nil {
-		return "", fmt.Errorf("error hashing password: %w", err)
-	}
-	return string(hashedPassword), nil
-}
-
-// generateToken generates a JWT token for the given user
-func generateToken(user models.User) (string, error) {
-	// Define JWT claims, including the user ID and expiration time
-	claims := jwt.MapClaims{
-		"user_id": user.ID,
-		"exp":     time.Now().Add(24 * time.Hour).Unix(),
+		return "", err
 	}
 
-	// Create the JWT token using the claims and secret key
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(secretKey)
+	// Sign the JWT token, carrying the user ID, session ID, token ID, issue time and expiration time
+	claims := newClaims(user, purposeAccess, jti, time.Now(), accessTokenTTL())
+	claims.SessionID = sessionID
+	tokenString, err := keyring.Sign(claims)
 	if err != nil {
 		return "", fmt.Errorf("error generating token: %w", err)
 	}
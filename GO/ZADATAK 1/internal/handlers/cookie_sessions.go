@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"zadatak-filip-janjesic/internal/models" // Import models for the session struct
+
+	"github.com/gofiber/fiber/v2" // Fiber for HTTP handling
+	"gorm.io/gorm"                // Database operations
+)
+
+// Names of the cookies of a browser session and of the header carrying the CSRF token.
+const (
+	sessionCookie = "session"      // HttpOnly cookie identifying the session
+	csrfCookie    = "csrf_token"   // Readable copy of the CSRF token for the front end
+	csrfHeader    = "X-CSRF-Token" // Header in which state-changing requests send the CSRF token back
+)
+
+// Session modes a client can choose at login.
+const (
+	sessionModeToken  = "token"  // Token pair in the response body (the default)
+	sessionModeCookie = "cookie" // Browser session in cookies
+)
+
+// defaultCookieSessionTTL is how long a browser session lasts without requests.
+const defaultCookieSessionTTL = 7 * 24 * time.Hour
+
+// errInvalidSessionCookie is returned for session cookies of unknown, revoked and expired sessions.
+var errInvalidSessionCookie = errors.New("invalid session cookie")
+
+// cookieSessionResponse is the response to a login that started a browser session.
+type cookieSessionResponse struct {
+	SessionID uint      `json:"session_id"`
+	CSRFToken string    `json:"csrf_token"` // Send in the X-CSRF-Token header of every POST, PUT, PATCH and DELETE request
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// cookieSessionTTL returns the idle timeout of browser sessions (SESSION_COOKIE_TTL, e.g. "168h").
+func cookieSessionTTL() time.Duration {
+	return envDuration("SESSION_COOKIE_TTL", defaultCookieSessionTTL)
+}
+
+// secureCookies reports whether the session cookies are marked Secure. SESSION_COOKIE_SECURE=false
+// allows browser sessions over plain HTTP during development.
+func secureCookies() bool {
+	return os.Getenv("SESSION_COOKIE_SECURE") != "false"
+}
+
+// isValidSessionMode reports whether a client asked for a known session mode.
+func isValidSessionMode(mode string) bool {
+	return mode == "" || mode == sessionModeToken || mode == sessionModeCookie
+}
+
+// respondWithLogin completes a successful login in the session mode the client asked for: a token pair
+// in the response body, or a browser session in cookies.
+func respondWithLogin(c *fiber.Ctx, database *gorm.DB, user models.User, device sessionInfo, mode string) error {
+	if mode == sessionModeCookie {
+		response, err := startCookieSession(c, database, user, device)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error starting session"})
+		}
+		return c.Status(fiber.StatusOK).JSON(response)
+	}
+
+	pair, err := issueTokenPair(database, user, device)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error generating token"})
+	}
+	return c.Status(fiber.StatusOK).JSON(pair)
+}
+
+// startCookieSession starts a browser session for the user and sets its cookies.
+func startCookieSession(c *fiber.Ctx, database *gorm.DB, user models.User, device sessionInfo) (cookieSessionResponse, error) {
+	familyID, err := newOpaqueToken()
+	if err != nil {
+		return cookieSessionResponse{}, err
+	}
+	cookie, err := newOpaqueToken()
+	if err != nil {
+		return cookieSessionResponse{}, err
+	}
+	csrfToken, err := newOpaqueToken()
+	if err != nil {
+		return cookieSessionResponse{}, err
+	}
+
+	now := time.Now()
+	session := models.Session{
+		UserID:     user.ID,
+		FamilyID:   familyID,
+		DeviceName: device.DeviceName,
+		UserAgent:  device.UserAgent,
+		IP:         device.IP,
+		LastSeenAt: now,
+		LastSeenIP: device.IP,
+		ExpiresAt:  now.Add(cookieSessionTTL()),
+		CookieHash: hashToken(cookie),
+		CSRFToken:  csrfToken,
+	}
+	if err := database.Create(&session).Error; err != nil {
+		return cookieSessionResponse{}, fmt.Errorf("error storing session: %w", err)
+	}
+
+	setSessionCookies(c, cookie, csrfToken, session.ExpiresAt)
+	return cookieSessionResponse{SessionID: session.ID, CSRFToken: csrfToken, ExpiresAt: session.ExpiresAt}, nil
+}
+
+// setSessionCookies sets the session cookie and the CSRF cookie of a browser session.
+func setSessionCookies(c *fiber.Ctx, cookie, csrfToken string, expiresAt time.Time) {
+	c.Cookie(&fiber.Cookie{
+		Name:     sessionCookie,
+		Value:    cookie,
+		Path:     "/",
+		Expires:  expiresAt,
+		Secure:   secureCookies(),
+		HTTPOnly: true,                        // Out of reach of scripts, unlike tokens in localStorage
+		SameSite: fiber.CookieSameSiteLaxMode, // Not sent with cross-site requests other than top-level navigation
+	})
+	c.Cookie(&fiber.Cookie{
+		Name:     csrfCookie,
+		Value:    csrfToken,
+		Path:     "/",
+		Expires:  expiresAt,
+		Secure:   secureCookies(),
+		HTTPOnly: false, // The front end reads it to send it back in the X-CSRF-Token header
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+}
+
+// clearSessionCookies removes the cookies of a browser session.
+func clearSessionCookies(c *fiber.Ctx) {
+	c.ClearCookie(sessionCookie, csrfCookie)
+}
+
+// authenticateSessionCookie looks up the browser session of a session cookie and returns its principal.
+// Every request extends the session, at most once per interval unless the IP address changed.
+func authenticateSessionCookie(c *fiber.Ctx, database *gorm.DB, cookie string) (*Principal, error) {
+	now := time.Now()
+	var session models.Session
+	if err := database.Where("cookie_hash = ? AND revoked_at IS NULL AND expires_at > ?", hashToken(cookie), now).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errInvalidSessionCookie
+		}
+		return nil, err
+	}
+
+	var user models.User
+	if err := database.First(&user, session.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errInvalidSessionCookie
+		}
+		return nil, err
+	}
+
+	if now.Sub(session.LastSeenAt) > lastUsedUpdateInterval || session.LastSeenIP != c.IP() {
+		session.ExpiresAt = now.Add(cookieSessionTTL())
+		if err := database.Model(&session).Updates(map[string]interface{}{
+			"last_seen_at": now,
+			"last_seen_ip": c.IP(),
+			"expires_at":   session.ExpiresAt,
+		}).Error; err != nil {
+			return nil, err
+		}
+		setSessionCookies(c, cookie, session.CSRFToken, session.ExpiresAt)
+	}
+
+	return &Principal{
+		User:      user,
+		SessionID: session.ID,
+		IssuedAt:  session.CreatedAt,
+		ExpiresAt: session.ExpiresAt,
+		csrfToken: session.CSRFToken,
+	}, nil
+}
+
+// CSRFProtection requires the CSRF token of the session in the X-CSRF-Token header (or the csrf_token
+// form field) of every state-changing request authenticated with a session cookie. Requests with an Authorization header are not affected,
+// since browsers never add that header on their own. It must run after AuthMiddleware.
+func CSRFProtection(c *fiber.Ctx) error {
+	principal, ok := CurrentPrincipal(c)
+	if !ok || !principal.IsBrowserSession() {
+		return c.Next()
+	}
+
+	switch c.Method() {
+	case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+		return c.Next()
+	}
+
+	// HTML forms, such as the OAuth consent screen, send the token as a form field instead
+	presented := c.Get(csrfHeader)
+	if presented == "" && strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEApplicationForm) {
+		presented = c.FormValue(csrfCookie)
+	}
+	if presented == "" || subtle.ConstantTimeCompare([]byte(presented), []byte(principal.csrfToken)) != 1 {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Missing or invalid CSRF token"})
+	}
+	return c.Next()
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"time"
+
+	"zadatak-filip-janjesic/internal/mail"   // Mailer used for the verification email
+	"zadatak-filip-janjesic/internal/models" // Import models for user and token structs
+
+	"github.com/go-playground/validator/v10" // Validator for input validation
+	"github.com/gofiber/fiber/v2"            // Fiber for HTTP handling
+	"gorm.io/gorm"                           // Database operations
+)
+
+// Defaults for verification emails, overridden by EMAIL_VERIFICATION_TTL, EMAIL_VERIFICATION_LIMIT and
+// EMAIL_VERIFICATION_WINDOW.
+const (
+	defaultEmailVerificationTTL    = 24 * time.Hour // How long a verification link stays valid
+	defaultEmailVerificationLimit  = 3              // Verification emails sent to one address per window
+	defaultEmailVerificationWindow = time.Hour      // Window of the per-address limit
+)
+
+// Email verification policies, selected with EMAIL_VERIFICATION_POLICY.
+const (
+	verificationPolicyNone  = "none"  // Unverified users can do everything (default)
+	verificationPolicyLogin = "login" // Unverified users cannot log in
+	verificationPolicyWrite = "write" // Unverified users can log in and read, but not create, update or delete notes
+)
+
+// emailVerificationPolicy returns the configured email verification policy.
+func emailVerificationPolicy() string {
+	switch policy := os.Getenv("EMAIL_VERIFICATION_POLICY"); policy {
+	case verificationPolicyLogin, verificationPolicyWrite:
+		return policy
+	case "", verificationPolicyNone:
+		return verificationPolicyNone
+	default:
+		log.Printf("Unknown EMAIL_VERIFICATION_POLICY %q, using %q", policy, verificationPolicyNone)
+		return verificationPolicyNone
+	}
+}
+
+// VerifyEmailPage is opened by the link in the verification email. It posts the token to VerifyEmail.
+func VerifyEmailPage(c *fiber.Ctx) error {
+	return showLinkPage(c, linkPageContent{
+		Title:  "Confirm your email address",
+		Text:   "Confirm that this email address belongs to you.",
+		Button: "Confirm email address",
+	})
+}
+
+// VerifyEmail confirms the user's email address with the token from the verification email.
+func VerifyEmail(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var request struct {
+			Token string `json:"token" form:"token" validate:"required"`
+		}
+
+		// Parse and validate the JSON request body, or the form of VerifyEmailPage
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+		}
+		if err := validator.New().Struct(request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Validation failed: " + err.Error()})
+		}
+
+		err := database.Transaction(func(tx *gorm.DB) error {
+			record, err := consumeOneTimeToken(tx, request.Token, models.PurposeEmailVerification)
+			if err != nil {
+				return err
+			}
+			return tx.Model(&models.User{}).Where("id = ?", record.UserID).Update("email_verified_at", time.Now()).Error
+		})
+		if err != nil {
+			if errors.Is(err, errInvalidOneTimeToken) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired verification token"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to verify email address"})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Email address verified"})
+	}
+}
+
+// ResendVerificationEmail sends a new verification email. Like /password/forgot, it answers the same way
+// for unknown and already verified addresses, and it does not require a token, because under the
+// "login" policy unverified users cannot obtain one.
+func ResendVerificationEmail(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var request struct {
+			Email string `json:"email" validate:"required,email"`
+		}
+
+		// Parse and validate the JSON request body
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+		}
+		if err := validator.New().Struct(request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Validation failed: " + err.Error()})
+		}
+
+		accepted := func() error {
+			return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+				"message": "If the address is registered and not yet verified, a verification link has been sent",
+			})
+		}
+
+		var user models.User
+		if err := database.Where("email = ?", request.Email).First(&user).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return accepted()
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error retrieving user data"})
+		}
+		if user.EmailVerifiedAt != nil {
+			return accepted()
+		}
+
+		// Limit the emails sent to one address, so the endpoint cannot be used to flood a mailbox; the
+		// email sent on registration counts as well
+		window := envDuration("EMAIL_VERIFICATION_WINDOW", defaultEmailVerificationWindow)
+		var recent int64
+		if err := database.Model(&models.OneTimeToken{}).
+			Where("user_id = ? AND purpose = ? AND created_at > ?", user.ID, models.PurposeEmailVerification, time.Now().UTC().Add(-window)).
+			Count(&recent).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to send verification email"})
+		}
+		if recent >= int64(envInt("EMAIL_VERIFICATION_LIMIT", defaultEmailVerificationLimit)) {
+			log.Printf("Verification email limit reached for user %d, request from %s dropped", user.ID, c.IP())
+			return accepted()
+		}
+
+		// A failure to send gets the same response; an error here would tell that the address is registered
+		if err := sendVerificationEmail(database, user); err != nil {
+			log.Printf("Error sending verification email to user %d: %v", user.ID, err)
+		}
+		return accepted()
+	}
+}
+
+// RequireVerifiedEmail blocks requests that change notes while the "write" policy is active and the
+// authenticated user has not verified their email address. It must run after AuthMiddleware.
+func RequireVerifiedEmail(c *fiber.Ctx) error {
+	if emailVerificationPolicy() != verificationPolicyWrite || c.Method() == fiber.MethodGet || c.Method() == fiber.MethodHead {
+		return c.Next()
+	}
+
+	principal, ok := CurrentPrincipal(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+	}
+	if principal.User.EmailVerifiedAt == nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Email address not verified"})
+	}
+	return c.Next()
+}
+
+// sendVerificationEmail issues a verification token for the user and emails the verification link.
+func sendVerificationEmail(database *gorm.DB, user models.User) error {
+	ttl := envDuration("EMAIL_VERIFICATION_TTL", defaultEmailVerificationTTL)
+	token, err := issueOneTimeToken(database, user.ID, models.PurposeEmailVerification, ttl)
+	if err != nil {
+		return err
+	}
+
+	link := appURL("/email/verify", url.Values{"token": {token}})
+	return mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"Please confirm that this address belongs to you by opening the link below within %s:\n\n%s\n\n"+
+			"Or send this token to POST /email/verify: %s\n\n"+
+			"If you did not create an account, you can ignore this email.\n", user.Username, ttl, link, token),
+	})
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"log"
+	"time"
+
+	"zadatak-filip-janjesic/internal/models" // Import models for the cleanup functions
+
+	"gorm.io/gorm" // GORM for ORM and database operations
+)
+
+// Default intervals between two runs of the background jobs.
+const (
+	defaultRevocationPurgeInterval = time.Hour
+	defaultKeyringReloadInterval   = time.Minute
+	defaultLoginThrottlePurge      = time.Hour
+	defaultAccountDeletionInterval = time.Hour
+	defaultMagicLinkPurgeInterval  = time.Hour
+)
+
+// StartBackgroundJobs starts the periodic maintenance jobs. Each job runs in its own goroutine
+// for the lifetime of the process.
+func StartBackgroundJobs(database *gorm.DB) {
+	// Purge revocation entries of tokens that have expired anyway, and sessions that ended (REVOCATION_PURGE_INTERVAL, e.g. "1h")
+	go runPeriodically("revocation purge", envDuration("REVOCATION_PURGE_INTERVAL", defaultRevocationPurgeInterval), func() error {
+		purged, err := models.PurgeExpiredRevokedTokens(database)
+		if err == nil && purged > 0 {
+			log.Printf("Purged %d expired revoked tokens", purged)
+		}
+		if err != nil {
+			return err
+		}
+
+		// Sessions that ended are no longer listed, and their tokens are rejected without them
+		purged, err = models.PurgeEndedSessions(database)
+		if err == nil && purged > 0 {
+			log.Printf("Purged %d ended sessions", purged)
+		}
+		if err != nil {
+			return err
+		}
+
+		// Single sign-on logins that were started but never completed
+		if _, err := models.PurgeExpiredOIDCLoginStates(database); err != nil {
+			return err
+		}
+
+		// Passkey ceremonies that were started but never finished
+		if _, err := models.PurgeExpiredWebAuthnChallenges(database); err != nil {
+			return err
+		}
+
+		// Authorization codes and refresh tokens of third-party apps that can no longer be used
+		purged, err = models.PurgeEndedOAuthTokens(database)
+		if err == nil && purged > 0 {
+			log.Printf("Purged %d ended OAuth codes and refresh tokens", purged)
+		}
+		return err
+	})
+
+	// Forget login failures that are older than the attempt window and no longer block anything
+	go runPeriodically("login throttle purge", defaultLoginThrottlePurge, func() error {
+		window := envDuration("LOGIN_ATTEMPT_WINDOW", defaultLoginAttemptWindow)
+		purged, err := models.PurgeStaleLoginThrottles(database, time.Now().Add(-window))
+		if err == nil && purged > 0 {
+			log.Printf("Purged %d stale login throttle entries", purged)
+		}
+		return err
+	})
+
+	// Forget magic links that expired and no longer count towards the per-address limit (MAGIC_LINK_PURGE_INTERVAL, e.g. "1h")
+	go runPeriodically("magic link purge", envDuration("MAGIC_LINK_PURGE_INTERVAL", defaultMagicLinkPurgeInterval), func() error {
+		window := envDuration("MAGIC_LINK_WINDOW", defaultMagicLinkWindow)
+		_, err := models.PurgeMagicLinks(database, time.Now().Add(-window))
+		return err
+	})
+
+	// Permanently delete accounts whose deletion grace period has ended (ACCOUNT_DELETION_INTERVAL, e.g. "1h")
+	go runPeriodically("account deletion", envDuration("ACCOUNT_DELETION_INTERVAL", defaultAccountDeletionInterval), func() error {
+		deleted, err := models.DeleteDueAccounts(database)
+		if deleted > 0 {
+			log.Printf("Deleted %d accounts scheduled for deletion", deleted)
+		}
+		return err
+	})
+
+	// Permanently delete notes that have been in the trash longer than NOTE_TRASH_RETENTION_DAYS (NOTE_TRASH_PURGE_INTERVAL, e.g. "1h")
+	go runPeriodically("note trash purge", envDuration("NOTE_TRASH_PURGE_INTERVAL", defaultNoteTrashPurgeInterval), func() error {
+		purged, err := models.PurgeTrashedNotes(database, time.Now().Add(-noteTrashRetention()))
+		if err == nil && purged > 0 {
+			log.Printf("Purged %d notes from the trash", purged)
+		}
+		if err != nil {
+			return err
+		}
+
+		// Cached pages of notes that expired and would be read from the notes table again anyway
+		_, err = models.PurgeExpiredNotesCache(database)
+		return err
+	})
+
+	// Pick up keys generated or retired with the admin command (KEYRING_RELOAD_INTERVAL, e.g. "1m")
+	go runPeriodically("keyring reload", envDuration("KEYRING_RELOAD_INTERVAL", defaultKeyringReloadInterval), keyring.Reload)
+}
+
+// runPeriodically runs the job immediately and then once per interval, logging any error it returns.
+func runPeriodically(name string, interval time.Duration, job func() error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := job(); err != nil {
+			log.Printf("Background job %s failed: %v", name, err)
+		}
+		<-ticker.C
+	}
+}
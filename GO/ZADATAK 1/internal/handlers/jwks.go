@@ -0,0 +1,12 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2" // Fiber framework for web server
+)
+
+// JWKS publishes the public verification keys so that other services can verify our tokens.
+func JWKS(c *fiber.Ctx) error {
+	// Let clients cache the key set briefly; a client that sees an unknown kid should fetch it again
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(keyring.JWKS())
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"html/template"
+	"strings"
+
+	"github.com/gofiber/fiber/v2" // Fiber for HTTP handling
+)
+
+// linkPage is the page opened by the links in account emails. Opening it changes nothing, because mail
+// scanners open links too; the user confirms with a form that posts the token to the same path.
+var linkPage = template.Must(template.New("link").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Text}}</p>
+<form method="post" action="{{.Action}}">
+<input type="hidden" name="token" value="{{.Token}}">
+{{if .Password}}<p><label>New password <input type="password" name="password" autocomplete="new-password" required></label></p>
+{{end}}<button type="submit">{{.Button}}</button>
+</form>
+</body>
+</html>
+`))
+
+// linkPageContent is what the page of one kind of emailed link shows.
+type linkPageContent struct {
+	Title    string
+	Text     string
+	Button   string
+	Password bool // Ask for a new password as well
+}
+
+// showLinkPage answers a GET request for an emailed link with the page that posts the token from the query
+// string to the same path.
+func showLinkPage(c *fiber.Ctx, content linkPageContent) error {
+	token := c.Query("token")
+	if token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "token required"})
+	}
+
+	var page strings.Builder
+	if err := linkPage.Execute(&page, fiber.Map{
+		"Title":    content.Title,
+		"Text":     content.Text,
+		"Button":   content.Button,
+		"Password": content.Password,
+		"Action":   c.Path(),
+		"Token":    token,
+	}); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to render page"})
+	}
+
+	// The token is in the URL, so it must not leak through the Referer header, caches or framing sites
+	c.Set(fiber.HeaderXFrameOptions, "DENY")
+	c.Set(fiber.HeaderContentSecurityPolicy, "frame-ancestors 'none'")
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set(fiber.HeaderReferrerPolicy, "no-referrer")
+	c.Type("html", "utf-8")
+	return c.SendString(page.String())
+}
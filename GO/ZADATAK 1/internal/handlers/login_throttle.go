@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/url"
+	"strconv"
+	"time"
+
+	"zadatak-filip-janjesic/internal/mail"   // Mailer used for the unlock email
+	"zadatak-filip-janjesic/internal/models" // Import models for the throttle and user structs
+
+	"github.com/go-playground/validator/v10" // Validator for input validation
+	"github.com/gofiber/fiber/v2"            // Fiber for HTTP handling
+	"gorm.io/gorm"                           // Database operations
+)
+
+// Defaults of the brute-force protection, used when the environment does not override them.
+const (
+	defaultLoginFreeAttempts     = 3                // LOGIN_FREE_ATTEMPTS: failures allowed before backoff starts
+	defaultLoginBackoffMax       = 15 * time.Minute // LOGIN_BACKOFF_MAX: upper bound of the backoff delay
+	defaultLoginAttemptWindow    = time.Hour        // LOGIN_ATTEMPT_WINDOW: failures older than this are forgotten
+	defaultLoginLockoutThreshold = 10               // LOGIN_LOCKOUT_THRESHOLD: failures on one username that lock the account
+	defaultLoginLockoutDuration  = time.Hour        // LOGIN_LOCKOUT_DURATION: how long a locked account stays locked
+)
+
+// loginBackoffBase is the delay after the first failure beyond the free attempts; it doubles with every further failure.
+const loginBackoffBase = time.Second
+
+// loginThrottleKeys returns the throttle keys for an attempt on a username from a client IP address.
+func loginThrottleKeys(username, ip string) []string {
+	return []string{models.UsernameThrottleKey(username), models.IPThrottleKey(ip)}
+}
+
+// loginRetryAfter returns how long the caller has to wait before the next attempt under any of the keys.
+func loginRetryAfter(database *gorm.DB, keys []string) (time.Duration, error) {
+	var throttles []models.LoginThrottle
+	if err := database.Where("throttle_key IN ? AND blocked_until > ?", keys, time.Now()).Find(&throttles).Error; err != nil {
+		return 0, err
+	}
+
+	var wait time.Duration
+	for _, throttle := range throttles {
+		if remaining := time.Until(*throttle.BlockedUntil); remaining > wait {
+			wait = remaining
+		}
+	}
+	return wait, nil
+}
+
+// recordLoginFailure counts a failed attempt under every key and blocks keys that used up their free attempts.
+// It returns the number of recent failures recorded under the first key, the username.
+func recordLoginFailure(database *gorm.DB, keys []string) (int, error) {
+	now := time.Now()
+	window := envDuration("LOGIN_ATTEMPT_WINDOW", defaultLoginAttemptWindow)
+	freeAttempts := envInt("LOGIN_FREE_ATTEMPTS", defaultLoginFreeAttempts)
+
+	var usernameFailures int
+	err := database.Transaction(func(tx *gorm.DB) error {
+		for i, key := range keys {
+			throttle := models.LoginThrottle{Key: key}
+			if err := tx.Where("throttle_key = ?", key).FirstOrInit(&throttle).Error; err != nil {
+				return err
+			}
+
+			// A failure after a quiet window starts counting from scratch
+			if now.Sub(throttle.LastFailureAt) > window {
+				throttle.Failures = 0
+			}
+			throttle.Failures++
+			throttle.LastFailureAt = now
+
+			if throttle.Failures > freeAttempts {
+				blockedUntil := now.Add(loginBackoff(throttle.Failures - freeAttempts))
+				throttle.BlockedUntil = &blockedUntil
+			}
+			if err := tx.Save(&throttle).Error; err != nil {
+				return err
+			}
+
+			if i == 0 {
+				usernameFailures = throttle.Failures
+			}
+		}
+		return nil
+	})
+	return usernameFailures, err
+}
+
+// loginBackoff returns the delay after the n-th failure beyond the free attempts: 1s, 2s, 4s, ... up to LOGIN_BACKOFF_MAX.
+func loginBackoff(n int) time.Duration {
+	maxDelay := envDuration("LOGIN_BACKOFF_MAX", defaultLoginBackoffMax)
+	delay := time.Duration(float64(loginBackoffBase) * math.Pow(2, float64(n-1)))
+	if delay <= 0 || delay > maxDelay {
+		return maxDelay
+	}
+	return delay
+}
+
+// handleLoginFailure records a failed password or second factor for the user and locks the account once
+// the lockout threshold is reached. The user is emailed a link to unlock the account early.
+func handleLoginFailure(database *gorm.DB, keys []string, user *models.User) error {
+	failures, err := recordLoginFailure(database, keys)
+	if err != nil || user == nil || failures < envInt("LOGIN_LOCKOUT_THRESHOLD", defaultLoginLockoutThreshold) {
+		return err
+	}
+
+	// Lock the account unless it already is; only the request that locks it sends the email
+	lockedUntil := time.Now().Add(envDuration("LOGIN_LOCKOUT_DURATION", defaultLoginLockoutDuration))
+	result := database.Model(&models.User{}).
+		Where("id = ? AND (locked_until IS NULL OR locked_until < ?)", user.ID, time.Now()).
+		Update("locked_until", lockedUntil)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Locked user %d until %s after %d failed login attempts", user.ID, lockedUntil.Format(time.RFC3339), failures)
+		if err := sendAccountUnlockEmail(database, *user, lockedUntil); err != nil {
+			log.Printf("Error sending unlock email to user %d: %v", user.ID, err)
+		}
+	}
+	return nil
+}
+
+// checkLoginAllowed returns how long the caller has to wait before a login attempt is accepted:
+// either because of the backoff on the username or IP address, or because the account is locked.
+func checkLoginAllowed(database *gorm.DB, keys []string, user *models.User) (time.Duration, error) {
+	wait, err := loginRetryAfter(database, keys)
+	if err != nil {
+		return 0, err
+	}
+	if user != nil && user.LockedUntil != nil {
+		if remaining := time.Until(*user.LockedUntil); remaining > wait {
+			wait = remaining
+		}
+	}
+	return wait, nil
+}
+
+// tooManyLoginAttempts refuses an attempt with 429 and a Retry-After header. Backoff and lockout get the
+// same response, so that it does not reveal whether the username exists.
+func tooManyLoginAttempts(c *fiber.Ctx, wait time.Duration) error {
+	seconds := int64(math.Ceil(wait.Seconds()))
+	c.Set(fiber.HeaderRetryAfter, strconv.FormatInt(seconds, 10))
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"error":       "Too many failed login attempts; try again later",
+		"retry_after": seconds,
+	})
+}
+
+// UnlockAccountPage is opened by the link in the unlock email. It posts the token to UnlockAccount.
+func UnlockAccountPage(c *fiber.Ctx) error {
+	return showLinkPage(c, linkPageContent{
+		Title:  "Unlock your account",
+		Text:   "Your account was locked after too many failed logins. Unlock it to log in again.",
+		Button: "Unlock account",
+	})
+}
+
+// UnlockAccount lifts a lockout with the token from the unlock email.
+func UnlockAccount(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var request struct {
+			Token string `json:"token" form:"token" validate:"required"`
+		}
+
+		// Parse and validate the JSON request body, or the form of UnlockAccountPage
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+		}
+		if err := validator.New().Struct(request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Validation failed: " + err.Error()})
+		}
+
+		err := database.Transaction(func(tx *gorm.DB) error {
+			record, err := consumeOneTimeToken(tx, request.Token, models.PurposeAccountUnlock)
+			if err != nil {
+				return err
+			}
+
+			var user models.User
+			if err := tx.First(&user, record.UserID).Error; err != nil {
+				return err
+			}
+			return models.UnlockUser(tx, user)
+		})
+		if err != nil {
+			if errors.Is(err, errInvalidOneTimeToken) || errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired unlock token"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to unlock account"})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Account unlocked; you can log in again"})
+	}
+}
+
+// sendAccountUnlockEmail issues an unlock token, valid as long as the lockout, and emails the unlock link.
+func sendAccountUnlockEmail(database *gorm.DB, user models.User, lockedUntil time.Time) error {
+	token, err := issueOneTimeToken(database, user.ID, models.PurposeAccountUnlock, time.Until(lockedUntil))
+	if err != nil {
+		return err
+	}
+
+	link := appURL("/account/unlock", url.Values{"token": {token}})
+	return mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Your account has been locked",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"Your account was locked until %s after too many failed login attempts.\n"+
+			"If these attempts were yours, open the link below to unlock it now:\n\n%s\n\n"+
+			"Or send this token to POST /account/unlock: %s\n\n"+
+			"If they were not yours, consider changing your password once the account is unlocked.\n",
+			user.Username, lockedUntil.Format(time.RFC1123), link, token),
+	})
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"time"
+
+	"zadatak-filip-janjesic/internal/models" // Import models for user and token structs
+
+	"github.com/gofiber/fiber/v2" // Fiber framework for web server
+	"gorm.io/gorm"                // GORM for ORM and database operations
+)
+
+// Logout revokes the access token used for the request and ends its session, together with its
+// refresh tokens or session cookie. A refresh token in the body has its whole family revoked as well.
+func Logout(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Retrieve the principal stored by AuthMiddleware
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "User data not found in context"})
+		}
+
+		// Revoke the current access token until it expires; browser sessions have none
+		if principal.TokenID != "" {
+			if err := models.RevokeToken(database, principal.TokenID, principal.UserID(), principal.ExpiresAt); err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to revoke token"})
+			}
+		}
+
+		// End the session of the access token or session cookie, which also revokes its refresh tokens
+		if principal.SessionID != 0 {
+			var session models.Session
+			if err := database.First(&session, principal.SessionID).Error; err == nil {
+				if err := models.RevokeRefreshTokenFamily(database, session.FamilyID); err != nil {
+					return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to revoke session"})
+				}
+			}
+		}
+
+		// Optionally revoke the refresh token family sent in the body, for clients without a session claim
+		var request struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		if err := c.BodyParser(&request); err == nil && request.RefreshToken != "" {
+			var stored models.RefreshToken
+			err := database.Where("token_hash = ? AND user_id = ?", hashToken(request.RefreshToken), principal.UserID()).First(&stored).Error
+			if err == nil {
+				if err := models.RevokeRefreshTokenFamily(database, stored.FamilyID); err != nil {
+					return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to revoke refresh token"})
+				}
+			}
+		}
+
+		if principal.IsBrowserSession() {
+			clearSessionCookies(c)
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// LogoutAll revokes every access and refresh token issued to the user before now.
+func LogoutAll(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "User data not found in context"})
+		}
+
+		if err := revokeAllUserTokens(database, principal.UserID()); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to revoke tokens"})
+		}
+
+		if principal.IsBrowserSession() {
+			clearSessionCookies(c)
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// revokeAllUserTokens invalidates all access tokens issued to the user so far and revokes their refresh tokens.
+func revokeAllUserTokens(database *gorm.DB, userID uint) error {
+	return database.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", userID).Update("tokens_revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return models.RevokeUserRefreshTokens(tx, userID)
+	})
+}
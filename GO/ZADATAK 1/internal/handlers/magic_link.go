@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"zadatak-filip-janjesic/internal/mail"   // Mailer used for the login link
+	"zadatak-filip-janjesic/internal/models" // Import models for user and magic link structs
+
+	"github.com/go-playground/validator/v10" // Validator for input validation
+	"github.com/gofiber/fiber/v2"            // Fiber for HTTP handling
+	"gorm.io/gorm"                           // Database operations
+)
+
+// Defaults for magic links, overridden by MAGIC_LINK_TTL, MAGIC_LINK_LIMIT and MAGIC_LINK_WINDOW.
+const (
+	defaultMagicLinkTTL    = 15 * time.Minute
+	defaultMagicLinkLimit  = 3         // Links sent to one address per window
+	defaultMagicLinkWindow = time.Hour // Window of the per-address limit
+)
+
+// Name and path of the cookie that binds a magic link to the browser that asked for it.
+const (
+	magicLinkCookie     = "magic_link"
+	magicLinkCookiePath = "/login/magic"
+)
+
+// RequestMagicLink emails a single-use login link to the user with the given address. The response
+// carries a device_token, also set as a cookie, without which the link does not work; the link can therefore
+// only be used on the device that asked for it. The response is the same whether or not the address is
+// registered, and requests beyond the per-address limit are silently dropped.
+func RequestMagicLink(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var request struct {
+			Email       string `json:"email" validate:"required,email"`
+			DeviceName  string `json:"device_name"`  // Optional name of the device, shown in the session list
+			SessionMode string `json:"session_mode"` // "token" (default) or "cookie" for a browser session
+		}
+
+		// Parse and validate the JSON request body
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+		}
+		if err := validator.New().Struct(request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Validation failed: " + err.Error()})
+		}
+		if !isValidSessionMode(request.SessionMode) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "session_mode must be \"token\" or \"cookie\""})
+		}
+
+		// Every request gets a device secret, so that the response does not reveal whether the address is registered
+		deviceToken, err := newOpaqueToken()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to send login link"})
+		}
+		ttl := envDuration("MAGIC_LINK_TTL", defaultMagicLinkTTL)
+		accepted := func() error {
+			c.Cookie(&fiber.Cookie{
+				Name:     magicLinkCookie,
+				Value:    deviceToken,
+				Path:     magicLinkCookiePath,
+				MaxAge:   int(ttl.Seconds()),
+				Secure:   strings.HasPrefix(appURL("", nil), "https://"),
+				HTTPOnly: true,
+				SameSite: fiber.CookieSameSiteLaxMode, // Sent along when the link is opened from an email client
+			})
+			return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+				"message":      "If the address is registered, a login link has been sent",
+				"device_token": deviceToken, // Send along with the link token if the link is not opened in this browser
+				"expires_in":   int64(ttl.Seconds()),
+			})
+		}
+
+		// Look up the user; an unknown address or a disabled account gets the same response
+		var user models.User
+		if err := database.Where("email = ?", request.Email).First(&user).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return accepted()
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error retrieving user data"})
+		}
+		if user.DisabledAt != nil {
+			return accepted()
+		}
+
+		// Limit the links sent to one address, so the endpoint cannot be used to flood a mailbox
+		window := envDuration("MAGIC_LINK_WINDOW", defaultMagicLinkWindow)
+		var recent int64
+		if err := database.Model(&models.MagicLink{}).
+			Where("user_id = ? AND created_at > ?", user.ID, time.Now().UTC().Add(-window)).
+			Count(&recent).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to send login link"})
+		}
+		if recent >= int64(envInt("MAGIC_LINK_LIMIT", defaultMagicLinkLimit)) {
+			log.Printf("Magic link limit reached for user %d, request from %s dropped", user.ID, c.IP())
+			return accepted()
+		}
+
+		token, err := newOpaqueToken()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to send login link"})
+		}
+		err = database.Transaction(func(tx *gorm.DB) error {
+			// Only the most recent link works
+			if err := tx.Model(&models.MagicLink{}).
+				Where("user_id = ? AND used_at IS NULL", user.ID).
+				Update("used_at", time.Now()).Error; err != nil {
+				return err
+			}
+			return tx.Create(&models.MagicLink{
+				UserID:      user.ID,
+				TokenHash:   hashToken(token),
+				DeviceHash:  hashToken(deviceToken),
+				DeviceName:  request.DeviceName,
+				SessionMode: request.SessionMode,
+				IP:          c.IP(),
+				ExpiresAt:   time.Now().Add(ttl),
+			}).Error
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to send login link"})
+		}
+
+		link := appURL("/login/magic/verify", url.Values{"token": {token}})
+		if err := mailer.Send(mail.Message{
+			To:      user.Email,
+			Subject: "Your login link",
+			Body: fmt.Sprintf("Hello %s,\n\n"+
+				"Open the link below within %s to log in. It works once, and only on the device where you asked for it:\n\n%s\n\n"+
+				"Or send this token to POST /login/magic/verify together with the device_token: %s\n\n"+
+				"If you did not ask for this, you can ignore this email.\n", user.Username, ttl, link, token),
+		}); err != nil {
+			log.Printf("Error sending magic link to user %d: %v", user.ID, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to send login link"})
+		}
+		return accepted()
+	}
+}
+
+// VerifyMagicLink completes a passwordless login with the token of a magic link. It issues the same token pair
+// or browser session as Login, or the two-factor challenge for users with two-factor authentication. The device
+// secret comes from the cookie set by RequestMagicLink, or from device_token for clients without cookies.
+func VerifyMagicLink(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var request struct {
+			Token       string `json:"token"`
+			DeviceToken string `json:"device_token"`
+		}
+		if c.Method() == fiber.MethodPost {
+			if err := c.BodyParser(&request); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+			}
+		}
+		if request.Token == "" {
+			request.Token = c.Query("token")
+		}
+		if request.DeviceToken == "" {
+			request.DeviceToken = c.Cookies(magicLinkCookie)
+		}
+		if request.Token == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "token required"})
+		}
+
+		var link models.MagicLink
+		if err := database.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hashToken(request.Token), time.Now()).
+			First(&link).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired login link"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error checking login link"})
+		}
+
+		// A link opened on another device is refused, but stays valid for the device that asked for it
+		if request.DeviceToken == "" || subtle.ConstantTimeCompare([]byte(hashToken(request.DeviceToken)), []byte(link.DeviceHash)) != 1 {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "This login link can only be used on the device that asked for it"})
+		}
+
+		// Mark the link as used in the same statement that checks it, so it works only once
+		result := database.Model(&models.MagicLink{}).Where("id = ? AND used_at IS NULL", link.ID).Update("used_at", time.Now())
+		if result.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error checking login link"})
+		}
+		if result.RowsAffected == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired login link"})
+		}
+		c.Cookie(&fiber.Cookie{Name: magicLinkCookie, Path: magicLinkCookiePath, Expires: time.Now().Add(-time.Hour)})
+
+		var user models.User
+		if err := database.First(&user, link.UserID).Error; err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "User not found"})
+		}
+
+		// Refuse locked accounts
+		if user.LockedUntil != nil && user.LockedUntil.After(time.Now()) {
+			return tooManyLoginAttempts(c, time.Until(*user.LockedUntil))
+		}
+
+		// Opening the link proves that the user receives mail at the address
+		if user.EmailVerifiedAt == nil {
+			now := time.Now()
+			if err := database.Model(&user).Update("email_verified_at", now).Error; err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error verifying email address"})
+			}
+			user.EmailVerifiedAt = &now
+		}
+
+		if err := checkLoginAllowedState(user); err != nil {
+			return refuseLogin(c, err)
+		}
+
+		// The link replaces the password only; the second factor is still required
+		if user.TOTPEnabledAt != nil {
+			return respondWithMFAChallenge(c, user)
+		}
+
+		if err := models.ClearLoginThrottle(database, models.UsernameThrottleKey(user.Username)); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error recording login attempt"})
+		}
+		return respondWithLogin(c, database, user, newSessionInfo(c, link.DeviceName), link.SessionMode)
+	}
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"net/url"
+	"os"
+	"strings"
+
+	"zadatak-filip-janjesic/internal/mail" // Mailer used for account emails
+)
+
+// Mailer used to send account emails; set from main with SetMailer
+var mailer mail.Mailer
+
+// SetMailer sets the mailer used to send account emails.
+func SetMailer(m mail.Mailer) {
+	mailer = m
+}
+
+// appURL builds a link to the application (APP_BASE_URL) with the given path and query parameters.
+func appURL(path string, query url.Values) string {
+	base := os.Getenv("APP_BASE_URL")
+	if base == "" {
+		base = "http://localhost:8080"
+	}
+
+	link := strings.TrimRight(base, "/") + path
+	if len(query) > 0 {
+		link += "?" + query.Encode()
+	}
+	return link
+}
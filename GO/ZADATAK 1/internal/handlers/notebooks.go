@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"zadatak-filip-janjesic/internal/models" // Import models for the notebook and note structs
+
+	"github.com/go-playground/validator/v10" // Validator for input validation
+	"github.com/gofiber/fiber/v2"            // Fiber for HTTP handling
+	"gorm.io/gorm"                           // Database operations
+)
+
+// notebookContents is a notebook with everything in it: its notes and, recursively, its sub-notebooks.
+type notebookContents struct {
+	models.Notebook
+	Notebooks []*notebookContents `json:"notebooks"` // Sub-notebooks, sorted by name
+	Notes     []models.Note       `json:"notes"`     // Non-deleted notes directly in the notebook, oldest first
+}
+
+// ListNotebooks returns all of the authenticated user's notebooks, sorted by name. Each carries its
+// parent_id, from which clients build the tree.
+func ListNotebooks(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := currentUserID(c)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		notebooks := []models.Notebook{}
+		if err := database.Where("user_id = ?", userID).Order("name COLLATE NOCASE").Order("id").Find(&notebooks).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to list notebooks"})
+		}
+		return c.JSON(notebooks)
+	}
+}
+
+// CreateNotebook creates a notebook for the authenticated user, at the top level or, with parent_id,
+// inside one of their notebooks.
+func CreateNotebook(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := currentUserID(c)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		var request struct {
+			Name     string `json:"name" validate:"required,max=100"`
+			ParentID *uint  `json:"parent_id"`
+		}
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+		}
+		request.Name = strings.TrimSpace(request.Name)
+		if err := validator.New().Struct(request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Validation failed: " + err.Error()})
+		}
+		if request.ParentID != nil {
+			if owned, err := userOwnsNotebook(database, userID, *request.ParentID); err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to create notebook"})
+			} else if !owned {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Parent notebook not found"})
+			}
+		}
+
+		notebook := models.Notebook{UserID: uint(userID), ParentID: request.ParentID, Name: request.Name}
+		if err := database.Create(&notebook).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to create notebook"})
+		}
+		return c.Status(fiber.StatusCreated).JSON(notebook)
+	}
+}
+
+// GetNotebook returns one of the authenticated user's notebooks with its contents: its notes and its
+// sub-notebooks with their contents, at any depth.
+func GetNotebook(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := currentUserID(c)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		notebook, err := findUserNotebook(database, userID, c.Params("id"))
+		if err != nil {
+			return notebookLookupError(c, err)
+		}
+		ids, err := models.NotebookSubtreeIDs(database, notebook.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error retrieving notebook"})
+		}
+
+		var notebooks []models.Notebook
+		var notes []models.Note
+		for _, query := range []*gorm.DB{
+			database.Where("id IN ? AND user_id = ?", ids, userID).Order("name COLLATE NOCASE").Order("id").Find(&notebooks),
+			database.Where("notebook_id IN ? AND user_id = ?", ids, userID).
+				Order("created_at").Order("id").Preload("Tags", orderTagsByName).Find(&notes),
+		} {
+			if query.Error != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error retrieving notebook"})
+			}
+		}
+
+		// Build the tree from the flat lists; notebooks come sorted by name, so the children do too
+		nodes := make(map[uint]*notebookContents, len(notebooks))
+		for _, nb := range notebooks {
+			nodes[nb.ID] = &notebookContents{Notebook: nb, Notebooks: []*notebookContents{}, Notes: []models.Note{}}
+		}
+		for _, nb := range notebooks {
+			if nb.ParentID != nil && nb.ID != notebook.ID {
+				if parent, ok := nodes[*nb.ParentID]; ok {
+					parent.Notebooks = append(parent.Notebooks, nodes[nb.ID])
+				}
+			}
+		}
+		for _, note := range notes {
+			node := nodes[*note.NotebookID]
+			node.Notes = append(node.Notes, note)
+		}
+		return c.JSON(nodes[notebook.ID])
+	}
+}
+
+// UpdateNotebook renames one of the authenticated user's notebooks, moves it, or both. parent_id moves the
+// notebook, with everything in it, into another of the user's notebooks, or to the top level if null;
+// leaving it out keeps the notebook where it is. A notebook cannot be moved into itself or its own
+// sub-notebooks.
+func UpdateNotebook(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := currentUserID(c)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		var request struct {
+			Name     *string `json:"name"`
+			ParentID *uint   `json:"parent_id"`
+		}
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+		}
+
+		notebook, err := findUserNotebook(database, userID, c.Params("id"))
+		if err != nil {
+			return notebookLookupError(c, err)
+		}
+
+		fields := []string{}
+		if request.Name != nil {
+			notebook.Name = strings.TrimSpace(*request.Name)
+			if err := validator.New().Var(notebook.Name, "required,max=100"); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "name must be 1 to 100 characters"})
+			}
+			fields = append(fields, "name")
+		}
+		if jsonFieldPresent(c, "parent_id") {
+			if request.ParentID != nil {
+				if owned, err := userOwnsNotebook(database, userID, *request.ParentID); err != nil {
+					return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to update notebook"})
+				} else if !owned {
+					return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Parent notebook not found"})
+				}
+
+				// Moving a notebook below itself would detach the subtree into a cycle
+				subtree, err := models.NotebookSubtreeIDs(database, notebook.ID)
+				if err != nil {
+					return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to update notebook"})
+				}
+				for _, id := range subtree {
+					if id == *request.ParentID {
+						return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "A notebook cannot be moved into itself or its sub-notebooks"})
+					}
+				}
+			}
+			notebook.ParentID = request.ParentID
+			fields = append(fields, "parent_id")
+		}
+		if len(fields) == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "name or parent_id required"})
+		}
+
+		if err := database.Model(&notebook).Select(fields).Updates(&notebook).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to update notebook"})
+		}
+		return c.JSON(notebook)
+	}
+}
+
+// DeleteNotebook deletes one of the authenticated user's notebooks and all its sub-notebooks. Their notes
+// are not lost: they are moved to the trash (soft-deleted) and taken out of the deleted notebooks.
+func DeleteNotebook(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := currentUserID(c)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		notebook, err := findUserNotebook(database, userID, c.Params("id"))
+		if err != nil {
+			return notebookLookupError(c, err)
+		}
+
+		err = database.Transaction(func(tx *gorm.DB) error {
+			ids, err := models.NotebookSubtreeIDs(tx, notebook.ID)
+			if err != nil {
+				return err
+			}
+			// Notes that are already in the trash keep the time they were deleted
+			if err := tx.Unscoped().Model(&models.Note{}).Where("notebook_id IN ? AND user_id = ?", ids, userID).
+				Updates(map[string]interface{}{"notebook_id": nil, "deleted_at": gorm.Expr("COALESCE(deleted_at, ?)", time.Now().UTC())}).Error; err != nil {
+				return err
+			}
+			return tx.Unscoped().Where("id IN ? AND user_id = ?", ids, userID).Delete(&models.Notebook{}).Error
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to delete notebook"})
+		}
+
+		// Clear cache for the user, whose notes went to the trash
+		models.DeleteUserNotesCache(database, userID)
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// userOwnsNotebook reports whether the notebook exists and belongs to the user.
+func userOwnsNotebook(database *gorm.DB, userID int, notebookID uint) (bool, error) {
+	var count int64
+	err := database.Model(&models.Notebook{}).Where("id = ? AND user_id = ?", notebookID, userID).Count(&count).Error
+	return count > 0, err
+}
+
+// findUserNotebook returns the notebook with the ID given as a path parameter, if it belongs to the user.
+func findUserNotebook(database *gorm.DB, userID int, id string) (models.Notebook, error) {
+	var notebook models.Notebook
+	notebookID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return notebook, gorm.ErrRecordNotFound
+	}
+	err = database.Where("id = ? AND user_id = ?", notebookID, userID).First(&notebook).Error
+	return notebook, err
+}
+
+// notebookLookupError answers a failed findUserNotebook.
+func notebookLookupError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Notebook not found"})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error retrieving notebook"})
+}
+
+// jsonFieldPresent reports whether the JSON request body has the field, even if it is null. It tells a
+// field left out apart from one set to null, which the body parser decodes the same way.
+func jsonFieldPresent(c *fiber.Ctx, name string) bool {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(c.Body(), &fields); err != nil {
+		return false
+	}
+	_, ok := fields[name]
+	return ok
+}
@@ -1,8 +1,8 @@
 package handlers
 
 import (
+	"errors"
 	"strconv"
-	"time"
 	"zadatak-filip-janjesic/internal/models"
 
 	"github.com/go-playground/validator/v10" // Import the validator package
@@ -28,40 +28,63 @@ func NotesHandler(database *gorm.DB) fiber.Handler {
 	}
 }
 
+// currentUserID returns the ID of the authenticated user in the form used by the notes table.
+func currentUserID(c *fiber.Ctx) (int, error) {
+	principal, ok := CurrentPrincipal(c)
+	if !ok {
+		return 0, errors.New("authentication required")
+	}
+	return int(principal.UserID()), nil
+}
+
 // sendJSONResponse sends a JSON response with the specified status code.
 func sendJSONResponse(c *fiber.Ctx, data interface{}, statusCode int) error {
 	c.Status(statusCode)
 	return c.JSON(data)
 }
 
-// getNotes retrieves all active (non-deleted) notes for the authenticated user.
+// getNotes retrieves one page of the active (non-deleted) notes of the authenticated user. The page is
+// chosen with limit and cursor, sorted with sort and order, and filtered by creation and update time. The
+// body is a plain array of notes, as it was before pagination, so only the Link header points to the next page.
 func getNotes(database *gorm.DB, c *fiber.Ctx) error {
-	// Extract user ID from JWT token
-	userID, err := getUserIDFromToken(c)
+	// Extract user ID from the authenticated principal
+	userID, err := currentUserID(c)
 	if err != nil {
 		return c.Status(fiber.StatusUnauthorized).SendString(err.Error())
 	}
 
-	// Try to load notes from cache first
-	notes, err := models.LoadNotes(database, userID) // Pass database as first argument
-	if err != nil || len(notes) == 0 {               // Cache miss, fetch from DB
-		notes, err = fetchUserNotes(database, userID)
+	query, err := parseNoteListQuery(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	// Try to load the page from cache first; every page is cached under its own key
+	pageKey := query.cacheKey()
+	notes, err := models.LoadNotes(database, userID, pageKey) // Pass database as first argument
+	if err != nil {                                           // Cache miss, fetch from DB
+		notes, err = fetchUserNotes(database, userID, query)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).SendString("Database error")
 		}
-		// Save notes to cache for future requests
-		models.SaveNotes(database, userID, notes) // Pass database as first argument
+		// Save the page to cache for future requests
+		models.SaveNotes(database, userID, pageKey, notes) // Pass database as first argument
+	}
+
+	// One note more than the page size was fetched if there is a next page
+	if len(notes) > query.Limit {
+		notes = notes[:query.Limit]
+		c.Set(fiber.HeaderLink, "<"+query.nextPageLink(notes[len(notes)-1])+">; rel=\"next\"")
 	}
 
 	// Return the notes as JSON
 	return sendJSONResponse(c, notes, fiber.StatusOK)
 }
 
-// fetchUserNotes retrieves active notes from the database for the given user.
-func fetchUserNotes(database *gorm.DB, userID int) ([]models.Note, error) {
-	var notes []models.Note
-	// Use GORM to fetch notes where deleted_at is NULL (not deleted)
-	if err := database.Where("user_id = ? AND deleted_at IS NULL", userID).Find(&notes).Error; err != nil {
+// fetchUserNotes retrieves a page of active notes from the database for the given user.
+func fetchUserNotes(database *gorm.DB, userID int, query noteListQuery) ([]models.Note, error) {
+	notes := []models.Note{}
+	// GORM leaves out the notes in the trash (deleted_at set)
+	if err := query.apply(database.Where("user_id = ?", userID)).Preload("Tags", orderTagsByName).Find(&notes).Error; err != nil {
 		return nil, err
 	}
 	return notes, nil
@@ -81,21 +104,38 @@ func createNote(database *gorm.DB, c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).SendString("Validation failed")
 	}
 
-	// Extract user ID from JWT token to associate the note with the user
-	userID, err := getUserIDFromToken(c)
+	// Extract user ID from the authenticated principal to associate the note with the user
+	userID, err := currentUserID(c)
 	if err != nil {
 		return c.Status(fiber.StatusUnauthorized).SendString(err.Error())
 	}
 	note.UserID = userID
 	// Timestamps are managed by GORM automatically.
 
-	// Insert note into the database using GORM
-	if err := database.Create(&note).Error; err != nil {
+	// A note can only be filed in one of the user's own notebooks
+	if note.NotebookID != nil {
+		if owned, err := userOwnsNotebook(database, userID, *note.NotebookID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString("Database error")
+		} else if !owned {
+			return c.Status(fiber.StatusBadRequest).SendString("Notebook not found")
+		}
+	}
+
+	// Insert note into the database using GORM; the tags are linked afterwards as the user's own tags
+	tagNames := models.TagNames(note.Tags)
+	note.Tags = nil
+	err = database.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&note).Error; err != nil {
+			return err
+		}
+		return setNoteTags(tx, &note, tagNames)
+	})
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString("Unable to create note")
 	}
 
 	// Clear cache for the user to ensure we fetch updated data
-	models.ClearNotesCache(database) // Pass database as first argument
+	models.DeleteUserNotesCache(database, userID) // Pass database as first argument
 
 	// Return the newly created note as JSON
 	return sendJSONResponse(c, note, fiber.StatusCreated)
@@ -124,8 +164,8 @@ func updateNote(database *gorm.DB, c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).SendString("Validation failed")
 	}
 
-	// Extract user ID from JWT token to ensure they are updating their own note
-	userID, err := getUserIDFromToken(c)
+	// Extract user ID from the authenticated principal to ensure they are updating their own note
+	userID, err := currentUserID(c)
 	if err != nil {
 		return c.Status(fiber.StatusUnauthorized).SendString(err.Error())
 	}
@@ -139,21 +179,47 @@ func updateNote(database *gorm.DB, c *fiber.Ctx) error {
 		return c.Status(fiber.StatusInternalServerError).SendString("Database error")
 	}
 
-	// Update the note fields using GORM
-	note.ID = uint(noteID) // Convert noteID (uint) to match the ID field type
-	note.UserID = userID
-	if err := database.Save(&note).Error; err != nil {
+	// Update only the editable fields, so that created_at is kept (and updated_at set) by GORM
+	existingNote.Title = note.Title
+	existingNote.Body = note.Body
+	fields := []interface{}{"title", "body"}
+
+	// notebook_id moves the note to another notebook, or out of all notebooks if null; leaving it out keeps it
+	if jsonFieldPresent(c, "notebook_id") {
+		if note.NotebookID != nil {
+			if owned, err := userOwnsNotebook(database, userID, *note.NotebookID); err != nil {
+				return c.Status(fiber.StatusInternalServerError).SendString("Database error")
+			} else if !owned {
+				return c.Status(fiber.StatusBadRequest).SendString("Notebook not found")
+			}
+		}
+		existingNote.NotebookID = note.NotebookID
+		fields = append(fields, "notebook_id")
+	}
+
+	err = database.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&existingNote).Select(fields[0], fields[1:]...).Updates(&existingNote).Error; err != nil {
+			return err
+		}
+		// Tags left out of the request are kept; an empty list removes them all
+		if note.Tags != nil {
+			return setNoteTags(tx, &existingNote, models.TagNames(note.Tags))
+		}
+		return tx.Model(&existingNote).Order("tags.name").Association("Tags").Find(&existingNote.Tags)
+	})
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString("Unable to update note")
 	}
 
 	// Clear cache for the user to ensure we fetch updated data
-	models.ClearNotesCache(database) // Pass database as first argument
+	models.DeleteUserNotesCache(database, userID) // Pass database as first argument
 
 	// Return the updated note as JSON
-	return sendJSONResponse(c, note, fiber.StatusOK)
+	return sendJSONResponse(c, existingNote, fiber.StatusOK)
 }
 
-// deleteNote marks a note as deleted by setting the deleted_at timestamp.
+// deleteNote moves a note to the trash by setting the deleted_at timestamp. With ?permanent=true the note
+// is deleted for good instead, also from the trash.
 func deleteNote(database *gorm.DB, c *fiber.Ctx) error {
 	// Get note ID from the URL
 	noteIDStr := c.Params("id")
@@ -164,31 +230,38 @@ func deleteNote(database *gorm.DB, c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).SendString("Invalid note ID")
 	}
 
-	// Extract user ID from JWT token to ensure they are deleting their own note
-	userID, err := getUserIDFromToken(c)
+	// Extract user ID from the authenticated principal to ensure they are deleting their own note
+	userID, err := currentUserID(c)
 	if err != nil {
 		return c.Status(fiber.StatusUnauthorized).SendString(err.Error())
 	}
 
-	// Check if the note exists and belongs to the user using GORM
+	// Check if the note exists and belongs to the user using GORM; only permanent deletion finds notes in the trash
+	permanent := c.QueryBool("permanent")
+	lookup := database
+	if permanent {
+		lookup = database.Unscoped()
+	}
 	var existingNote models.Note
-	if err := database.Where("id = ? AND user_id = ?", noteID, userID).First(&existingNote).Error; err != nil {
+	if err := lookup.Where("id = ? AND user_id = ?", noteID, userID).First(&existingNote).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return c.Status(fiber.StatusNotFound).SendString("Note not found")
 		}
 		return c.Status(fiber.StatusInternalServerError).SendString("Database error")
 	}
 
-	// Soft delete the note by setting the deleted_at timestamp
-	currentTime := time.Now()
-	existingNote.DeletedAt = &currentTime // Set the DeletedAt field to the current time
-
-	if err := database.Save(&existingNote).Error; err != nil {
+	if permanent {
+		err = models.DeleteNotePermanently(database, existingNote)
+	} else {
+		// Soft delete the note; GORM sets the deleted_at timestamp
+		err = database.Delete(&existingNote).Error
+	}
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString("Unable to delete note")
 	}
 
 	// Clear cache for the user to ensure we fetch updated data
-	models.ClearNotesCache(database) // Pass database as first argument
+	models.DeleteUserNotesCache(database, userID) // Pass database as first argument
 
 	// Return no content response as the note is deleted
 	return c.SendStatus(fiber.StatusNoContent)
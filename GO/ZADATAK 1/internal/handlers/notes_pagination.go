@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"zadatak-filip-janjesic/internal/models" // Import models for the note struct and date layout
+
+	"github.com/gofiber/fiber/v2" // Fiber for HTTP handling
+	"gorm.io/gorm"                // Database operations
+)
+
+// Page sizes of GET /notes.
+const (
+	defaultNotesPageSize = 50
+	maxNotesPageSize     = 100
+)
+
+// maxNoteTagFilters is the number of tag parameters GET /notes accepts.
+const maxNoteTagFilters = 20
+
+// noteSortColumns are the columns GET /notes can be sorted by.
+var noteSortColumns = []string{"created_at", "updated_at", "title"}
+
+// noteListQuery is a parsed GET /notes request: one page of a sorted, filtered listing.
+type noteListQuery struct {
+	Limit         int
+	Sort          string // One of noteSortColumns
+	Order         string // "asc" or "desc"
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	UpdatedAfter  *time.Time
+	UpdatedBefore *time.Time
+	Tags          []string    // Normalized tag names the notes must have, sorted
+	TagMode       string      // "all" (every tag in Tags) or "any" (at least one of them)
+	Cursor        *noteCursor // Position after which the page starts; nil for the first page
+}
+
+// noteCursor is the position of the last note of a page. It is handed out as an opaque base64url
+// token and is only valid for the listing it was issued for.
+type noteCursor struct {
+	Value   string `json:"v"` // Sort column of the last note: RFC 3339 time or title
+	ID      uint   `json:"i"` // ID of the last note, breaking ties between equal values
+	Listing string `json:"l"` // Hash of the sort order and filters of the listing
+}
+
+// parseNoteListQuery reads limit, cursor, sort, order, the created_/updated_ after/before filters and the
+// repeatable tag filter with its tag_mode.
+func parseNoteListQuery(c *fiber.Ctx) (noteListQuery, error) {
+	query := noteListQuery{Limit: defaultNotesPageSize, Sort: "created_at", Order: "asc", TagMode: "all"}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxNotesPageSize {
+			return query, errors.New("limit must be a number from 1 to " + strconv.Itoa(maxNotesPageSize))
+		}
+		query.Limit = limit
+	}
+	if value := c.Query("sort"); value != "" {
+		if !containsAll(noteSortColumns, []string{value}) {
+			return query, errors.New("sort must be one of " + strings.Join(noteSortColumns, ", "))
+		}
+		query.Sort = value
+	}
+	if value := c.Query("order"); value != "" {
+		if value != "asc" && value != "desc" {
+			return query, errors.New("order must be asc or desc")
+		}
+		query.Order = value
+	}
+
+	for _, filter := range []struct {
+		name   string
+		target **time.Time
+	}{
+		{"created_after", &query.CreatedAfter},
+		{"created_before", &query.CreatedBefore},
+		{"updated_after", &query.UpdatedAfter},
+		{"updated_before", &query.UpdatedBefore},
+	} {
+		if value := c.Query(filter.name); value != "" {
+			parsed, err := parseNoteTimeFilter(value)
+			if err != nil {
+				return query, errors.New(filter.name + " must be a date (YYYY-MM-DD) or an RFC 3339 time")
+			}
+			*filter.target = &parsed
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, value := range c.Context().QueryArgs().PeekMulti("tag") {
+		name := models.NormalizeTagName(string(value))
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		query.Tags = append(query.Tags, name)
+	}
+	if len(query.Tags) > maxNoteTagFilters {
+		return query, errors.New("at most " + strconv.Itoa(maxNoteTagFilters) + " tags can be filtered by")
+	}
+	sort.Strings(query.Tags)
+	if value := c.Query("tag_mode"); value != "" {
+		if value != "all" && value != "any" {
+			return query, errors.New("tag_mode must be all or any")
+		}
+		query.TagMode = value
+	}
+
+	if value := c.Query("cursor"); value != "" {
+		cursor, err := decodeNoteCursor(value)
+		if err != nil || cursor.Listing != query.listingHash() {
+			return query, errors.New("invalid cursor; it only works with the sort order and filters it was returned for")
+		}
+		if query.Sort != "title" {
+			if _, err := time.Parse(time.RFC3339Nano, cursor.Value); err != nil {
+				return query, errors.New("invalid cursor")
+			}
+		}
+		query.Cursor = &cursor
+	}
+	return query, nil
+}
+
+// parseNoteTimeFilter accepts an RFC 3339 time, or a date meaning midnight local time. The time is
+// returned in UTC, like the stored timestamps: SQLite compares them as text, so they must have the same offset.
+func parseNoteTimeFilter(value string) (time.Time, error) {
+	if parsed, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return parsed.UTC(), nil
+	}
+	parsed, err := time.ParseInLocation(models.DateLayout, value, time.Local)
+	return parsed.UTC(), err
+}
+
+// values returns the query parameters of the listing, without the cursor, in a canonical form.
+func (q noteListQuery) values() url.Values {
+	values := url.Values{
+		"limit": {strconv.Itoa(q.Limit)},
+		"sort":  {q.Sort},
+		"order": {q.Order},
+	}
+	for name, value := range map[string]*time.Time{
+		"created_after":  q.CreatedAfter,
+		"created_before": q.CreatedBefore,
+		"updated_after":  q.UpdatedAfter,
+		"updated_before": q.UpdatedBefore,
+	} {
+		if value != nil {
+			values.Set(name, value.Format(time.RFC3339Nano))
+		}
+	}
+	if len(q.Tags) > 0 {
+		values["tag"] = q.Tags
+		values.Set("tag_mode", q.TagMode)
+	}
+	return values
+}
+
+// listingHash identifies the sort order and filters of the listing, which cursors are bound to.
+func (q noteListQuery) listingHash() string {
+	values := q.values()
+	values.Del("limit") // The page size may change from page to page
+	return hashToken(values.Encode())[:16]
+}
+
+// cacheKey is the key of the page in the notes cache: the whole query, including the cursor.
+func (q noteListQuery) cacheKey() string {
+	values := q.values()
+	if q.Cursor != nil {
+		values.Set("cursor", encodeNoteCursor(*q.Cursor))
+	}
+	return values.Encode()
+}
+
+// apply adds the filters, the cursor position, the order and the limit to a query of the user's notes.
+// One note more than the page size is requested, to find out whether there is a next page.
+func (q noteListQuery) apply(tx *gorm.DB) *gorm.DB {
+	if q.CreatedAfter != nil {
+		tx = tx.Where("created_at >= ?", *q.CreatedAfter)
+	}
+	if q.CreatedBefore != nil {
+		tx = tx.Where("created_at < ?", *q.CreatedBefore)
+	}
+	if q.UpdatedAfter != nil {
+		tx = tx.Where("updated_at >= ?", *q.UpdatedAfter)
+	}
+	if q.UpdatedBefore != nil {
+		tx = tx.Where("updated_at < ?", *q.UpdatedBefore)
+	}
+	if len(q.Tags) > 0 {
+		tagged := tx.Session(&gorm.Session{NewDB: true}).Table("note_tags").
+			Select("note_tags.note_id").
+			Joins("JOIN tags ON tags.id = note_tags.tag_id").
+			Where("tags.name IN ?", q.Tags).
+			Group("note_tags.note_id")
+		if q.TagMode == "all" {
+			tagged = tagged.Having("COUNT(*) = ?", len(q.Tags))
+		}
+		tx = tx.Where("id IN (?)", tagged)
+	}
+
+	// The sort column is one of noteSortColumns, so it is safe to put into the SQL
+	comparison := ">"
+	if q.Order == "desc" {
+		comparison = "<"
+	}
+	if q.Cursor != nil {
+		var value interface{} = q.Cursor.Value
+		if q.Sort != "title" {
+			at, _ := time.Parse(time.RFC3339Nano, q.Cursor.Value) // Checked by parseNoteListQuery
+			value = at.UTC()
+		}
+		tx = tx.Where("("+q.Sort+" "+comparison+" ?) OR ("+q.Sort+" = ? AND id "+comparison+" ?)", value, value, q.Cursor.ID)
+	}
+	return tx.Order(q.Sort + " " + q.Order).Order("id " + q.Order).Limit(q.Limit + 1)
+}
+
+// nextCursor returns the cursor of the page after the given notes.
+func (q noteListQuery) nextCursor(last models.Note) noteCursor {
+	cursor := noteCursor{ID: last.ID, Listing: q.listingHash()}
+	switch q.Sort {
+	case "title":
+		cursor.Value = last.Title
+	case "updated_at":
+		cursor.Value = last.UpdatedAt.UTC().Format(time.RFC3339Nano)
+	default:
+		cursor.Value = last.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+	return cursor
+}
+
+// nextPageLink returns the absolute URL of the page after the given notes.
+func (q noteListQuery) nextPageLink(last models.Note) string {
+	values := q.values()
+	values.Set("cursor", encodeNoteCursor(q.nextCursor(last)))
+	return appURL("/notes", values)
+}
+
+// encodeNoteCursor turns a cursor into the opaque token handed to clients.
+func encodeNoteCursor(cursor noteCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeNoteCursor parses a cursor token.
+func decodeNoteCursor(value string) (noteCursor, error) {
+	var cursor noteCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(data, &cursor)
+	return cursor, err
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"zadatak-filip-janjesic/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// openNotesTestDB returns a new database with three notes of user 1, created an hour apart from
+// 10:00 UTC on 1 March 2026.
+func openNotesTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	database := newTestDB(t)
+
+	start := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	for i, title := range []string{"ten", "eleven", "twelve"} {
+		at := start.Add(time.Duration(i) * time.Hour)
+		note := models.Note{UserID: 1, Title: title, Body: title, CreatedAt: at, UpdatedAt: at}
+		if err := database.Create(&note).Error; err != nil {
+			t.Fatalf("creating note: %v", err)
+		}
+	}
+	return database
+}
+
+// listTitles runs the listing and returns the titles of the notes on the page.
+func listTitles(t *testing.T, database *gorm.DB, query noteListQuery) []string {
+	t.Helper()
+	var notes []models.Note
+	if err := query.apply(database.Where("user_id = ?", 1)).Find(&notes).Error; err != nil {
+		t.Fatalf("listing notes: %v", err)
+	}
+	titles := []string{}
+	for _, note := range notes {
+		titles = append(titles, note.Title)
+	}
+	return titles
+}
+
+func TestParseNoteTimeFilterReturnsUTC(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"2026-03-01T13:30:00+02:00", time.Date(2026, 3, 1, 11, 30, 0, 0, time.UTC)},
+		{"2026-03-01T06:30:00-05:00", time.Date(2026, 3, 1, 11, 30, 0, 0, time.UTC)},
+		{"2026-03-01T11:30:00Z", time.Date(2026, 3, 1, 11, 30, 0, 0, time.UTC)},
+		{"2026-03-01", time.Date(2026, 3, 1, 0, 0, 0, 0, time.Local).UTC()},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			got, err := parseNoteTimeFilter(test.value)
+			if err != nil {
+				t.Fatalf("parseNoteTimeFilter(%q): %v", test.value, err)
+			}
+			if !got.Equal(test.want) || got.Location() != time.UTC {
+				t.Errorf("parseNoteTimeFilter(%q) = %v, want %v in UTC", test.value, got, test.want)
+			}
+		})
+	}
+
+	if _, err := parseNoteTimeFilter("yesterday"); err == nil {
+		t.Error("parseNoteTimeFilter accepted an invalid time")
+	}
+}
+
+func TestNoteListFiltersWithOffsets(t *testing.T) {
+	database := openNotesTestDB(t)
+
+	tests := []struct {
+		name   string
+		after  string
+		before string
+		want   []string
+	}{
+		{"after, east of UTC", "2026-03-01T12:30:00+02:00", "", []string{"eleven", "twelve"}},
+		{"after, west of UTC", "2026-03-01T06:30:00-05:00", "", []string{"twelve"}},
+		{"before, east of UTC", "", "2026-03-01T12:30:00+02:00", []string{"ten"}},
+		{"before, west of UTC", "", "2026-03-01T07:00:00-05:00", []string{"ten", "eleven"}},
+		{"between, mixed offsets", "2026-03-01T10:30:00Z", "2026-03-01T14:30:00+02:00", []string{"eleven", "twelve"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query := noteListQuery{Limit: defaultNotesPageSize, Sort: "created_at", Order: "asc", TagMode: "all"}
+			for value, target := range map[string]**time.Time{test.after: &query.CreatedAfter, test.before: &query.CreatedBefore} {
+				if value == "" {
+					continue
+				}
+				parsed, err := parseNoteTimeFilter(value)
+				if err != nil {
+					t.Fatalf("parseNoteTimeFilter(%q): %v", value, err)
+				}
+				*target = &parsed
+			}
+
+			got := listTitles(t, database, query)
+			if len(got) != len(test.want) {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Fatalf("got %v, want %v", got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestNoteListCursorWithOffset(t *testing.T) {
+	database := openNotesTestDB(t)
+	zone := time.FixedZone("UTC+2", 2*60*60)
+
+	tests := []struct {
+		order string
+		last  string // Title of the last note of the previous page
+		want  []string
+	}{
+		{"asc", "ten", []string{"eleven", "twelve"}},
+		{"asc", "eleven", []string{"twelve"}},
+		{"desc", "twelve", []string{"eleven", "ten"}},
+		{"desc", "eleven", []string{"ten"}},
+	}
+	for _, test := range tests {
+		t.Run(test.order+" after "+test.last, func(t *testing.T) {
+			var last models.Note
+			if err := database.Where("title = ?", test.last).First(&last).Error; err != nil {
+				t.Fatalf("finding note: %v", err)
+			}
+			// A cursor built from a time in another zone must still point at the same note
+			last.CreatedAt = last.CreatedAt.In(zone)
+
+			query := noteListQuery{Limit: defaultNotesPageSize, Sort: "created_at", Order: test.order, TagMode: "all"}
+			cursor := query.nextCursor(last)
+			if !strings.HasSuffix(cursor.Value, "Z") {
+				t.Errorf("cursor value %q is not in UTC", cursor.Value)
+			}
+			// Cursors handed out before the times were normalised carry the offset of the server
+			cursor.Value = last.CreatedAt.Format(time.RFC3339Nano)
+			query.Cursor = &cursor
+
+			got := listTitles(t, database, query)
+			if len(got) != len(test.want) {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Fatalf("got %v, want %v", got, test.want)
+				}
+			}
+		})
+	}
+}
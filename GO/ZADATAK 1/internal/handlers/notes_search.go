@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"zadatak-filip-janjesic/internal/models" // Import models for the note search
+
+	"github.com/gofiber/fiber/v2" // Fiber for HTTP handling
+	"gorm.io/gorm"                // Database operations
+)
+
+// Page sizes and limits of GET /notes/search.
+const (
+	defaultSearchPageSize = 20
+	maxSearchPageSize     = 100
+	maxSearchQueryLength  = 500
+)
+
+// Markers put around the matches in title_highlight and snippet.
+const (
+	searchHighlightOpen  = "<mark>"
+	searchHighlightClose = "</mark>"
+)
+
+// SearchNotes runs a full-text search over the authenticated user's non-deleted notes and returns the best
+// matches first, with the matches marked. q uses the FTS5 query syntax: words, "phrases", prefix* terms,
+// AND, OR, NOT and parentheses. The Link header points to the next page of results.
+func SearchNotes(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := currentUserID(c)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		query := strings.TrimSpace(c.Query("q"))
+		if query == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "q required"})
+		}
+		if len(query) > maxSearchQueryLength {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "q must be at most " + strconv.Itoa(maxSearchQueryLength) + " characters"})
+		}
+		limit := defaultSearchPageSize
+		if value := c.Query("limit"); value != "" {
+			limit, err = strconv.Atoi(value)
+			if err != nil || limit < 1 || limit > maxSearchPageSize {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "limit must be a number from 1 to " + strconv.Itoa(maxSearchPageSize)})
+			}
+		}
+		offset := 0
+		if value := c.Query("offset"); value != "" {
+			offset, err = strconv.Atoi(value)
+			if err != nil || offset < 0 {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "offset must be a non-negative number"})
+			}
+		}
+
+		// One result more than the page size is requested, to find out whether there is a next page
+		results, err := models.SearchNotes(database, userID, query, searchHighlightOpen, searchHighlightClose, limit+1, offset)
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrInvalidSearchQuery):
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid search query; quote words with special characters, e.g. \"e-mail\""})
+			case errors.Is(err, models.ErrNoteSearchUnavailable):
+				return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "Search is not available on this server"})
+			default:
+				log.Printf("Error searching notes of user %d: %v", userID, err)
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to search notes"})
+			}
+		}
+
+		if len(results) > limit {
+			results = results[:limit]
+			next := url.Values{"q": {query}, "limit": {strconv.Itoa(limit)}, "offset": {strconv.Itoa(offset + limit)}}
+			c.Set(fiber.HeaderLink, "<"+appURL("/notes/search", next)+">; rel=\"next\"")
+		}
+		return c.JSON(results)
+	}
+}
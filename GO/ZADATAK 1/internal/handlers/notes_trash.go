@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+
+	"zadatak-filip-janjesic/internal/models" // Import models for the note struct and the trash
+
+	"github.com/gofiber/fiber/v2" // Fiber for HTTP handling
+	"gorm.io/gorm"                // Database operations
+)
+
+// Notes stay in the trash for NOTE_TRASH_RETENTION_DAYS days before they are permanently deleted.
+const (
+	defaultNoteTrashRetentionDays = 30
+	defaultNoteTrashPurgeInterval = time.Hour
+)
+
+// noteTrashRetention returns how long deleted notes stay in the trash.
+func noteTrashRetention() time.Duration {
+	return time.Duration(envInt("NOTE_TRASH_RETENTION_DAYS", defaultNoteTrashRetentionDays)) * 24 * time.Hour
+}
+
+// trashedNote is a note in the trash, with when it was deleted and when it will be permanently deleted.
+type trashedNote struct {
+	models.Note
+	DeletedAt time.Time `json:"deleted_at"` // When the note was moved to the trash
+	PurgeAt   time.Time `json:"purge_at"`   // When the purge job deletes the note for good
+}
+
+// ListTrash returns the authenticated user's deleted notes, most recently deleted first. limit (50 by default,
+// at most 100) and offset page through them, and the Link header points to the next page.
+func ListTrash(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := currentUserID(c)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		limit := defaultNotesPageSize
+		if value := c.Query("limit"); value != "" {
+			limit, err = strconv.Atoi(value)
+			if err != nil || limit < 1 || limit > maxNotesPageSize {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "limit must be a number from 1 to " + strconv.Itoa(maxNotesPageSize)})
+			}
+		}
+		offset := 0
+		if value := c.Query("offset"); value != "" {
+			offset, err = strconv.Atoi(value)
+			if err != nil || offset < 0 {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "offset must be a non-negative number"})
+			}
+		}
+
+		// One note more than the page size is requested, to find out whether there is a next page
+		var notes []models.Note
+		if err := database.Unscoped().Where("user_id = ? AND deleted_at IS NOT NULL", userID).
+			Order("deleted_at DESC").Order("id DESC").Limit(limit+1).Offset(offset).
+			Preload("Tags", orderTagsByName).Find(&notes).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to list the trash"})
+		}
+		if len(notes) > limit {
+			notes = notes[:limit]
+			next := url.Values{"limit": {strconv.Itoa(limit)}, "offset": {strconv.Itoa(offset + limit)}}
+			c.Set(fiber.HeaderLink, "<"+appURL("/notes/trash", next)+">; rel=\"next\"")
+		}
+
+		retention := noteTrashRetention()
+		trash := make([]trashedNote, 0, len(notes))
+		for _, note := range notes {
+			trash = append(trash, trashedNote{Note: note, DeletedAt: note.DeletedAt.Time, PurgeAt: note.DeletedAt.Time.Add(retention)})
+		}
+		return c.JSON(trash)
+	}
+}
+
+// RestoreNote takes one of the authenticated user's notes out of the trash.
+func RestoreNote(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := currentUserID(c)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+		noteID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid note ID"})
+		}
+
+		var note models.Note
+		if err := database.Unscoped().Where("id = ? AND user_id = ? AND deleted_at IS NOT NULL", noteID, userID).
+			Preload("Tags", orderTagsByName).First(&note).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Note not found in the trash"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error retrieving note"})
+		}
+
+		fields := map[string]interface{}{"deleted_at": nil}
+		// A note whose notebook no longer exists comes back unfiled
+		if note.NotebookID != nil {
+			if owned, err := userOwnsNotebook(database, userID, *note.NotebookID); err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to restore note"})
+			} else if !owned {
+				fields["notebook_id"] = nil
+				note.NotebookID = nil
+			}
+		}
+		if err := database.Unscoped().Model(&note).Updates(fields).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to restore note"})
+		}
+		note.DeletedAt = gorm.DeletedAt{}
+
+		// Clear cache for the user, whose restored note is listed again
+		models.DeleteUserNotesCache(database, userID)
+
+		return c.JSON(note)
+	}
+}
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"zadatak-filip-janjesic/internal/models" // Import models for the OAuth client and grant structs
+
+	"github.com/go-playground/validator/v10" // Validator for input validation
+	"github.com/gofiber/fiber/v2"            // Fiber for HTTP handling
+	"gorm.io/gorm"                           // Database operations
+)
+
+// oauthClientSecretPrefix starts every client secret, so that leaked secrets are easy to recognise.
+const oauthClientSecretPrefix = "ncs_"
+
+// oauthClientResponse is the JSON representation of a registered OAuth client.
+type oauthClientResponse struct {
+	ID           uint      `json:"id"`
+	ClientID     string    `json:"client_id"`
+	ClientSecret string    `json:"client_secret,omitempty"` // Only set in the response to the registration request
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	Public       bool      `json:"public"` // Public clients have no secret and authenticate with PKCE alone
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// oauthAppResponse is the JSON representation of an app the user has authorized.
+type oauthAppResponse struct {
+	ID           uint       `json:"id"` // ID of the authorization, used to revoke it
+	ClientID     string     `json:"client_id"`
+	Name         string     `json:"name"`
+	Scopes       []string   `json:"scopes"`
+	AuthorizedAt time.Time  `json:"authorized_at"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty"`
+}
+
+// newOAuthClientResponse converts a stored client into its JSON representation.
+func newOAuthClientResponse(client models.OAuthClient) oauthClientResponse {
+	return oauthClientResponse{
+		ID:           client.ID,
+		ClientID:     client.ClientID,
+		Name:         client.Name,
+		RedirectURIs: client.RedirectURIList(),
+		Scopes:       client.ScopeList(),
+		Public:       !client.IsConfidential(),
+		CreatedAt:    client.CreatedAt,
+	}
+}
+
+// isValidRedirectURI reports whether a redirect URI may be registered: an absolute https URL without
+// a fragment, or an http URL on the loopback interface for native apps and development.
+func isValidRedirectURI(value string) bool {
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.Host == "" || parsed.Fragment != "" || parsed.User != nil {
+		return false
+	}
+	switch parsed.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := parsed.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	default:
+		return false
+	}
+}
+
+// ListOAuthClients returns the OAuth clients registered by the authenticated user.
+func ListOAuthClients(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		var clients []models.OAuthClient
+		if err := database.Where("owner_id = ?", principal.UserID()).Order("created_at DESC").Find(&clients).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to list clients"})
+		}
+
+		response := make([]oauthClientResponse, 0, len(clients))
+		for _, client := range clients {
+			response = append(response, newOAuthClientResponse(client))
+		}
+		return c.JSON(response)
+	}
+}
+
+// CreateOAuthClient registers a third-party app. Confidential clients get a secret, which is returned only
+// in this response; public clients ("public": true) get none.
+func CreateOAuthClient(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		var request struct {
+			Name         string   `json:"name" validate:"required,max=100"`
+			RedirectURIs []string `json:"redirect_uris" validate:"required,min=1,max=10"`
+			Scopes       []string `json:"scopes" validate:"required,min=1"`
+			Public       bool     `json:"public"`
+		}
+
+		// Parse and validate the JSON request body
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+		}
+		if err := validator.New().Struct(request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Validation failed: " + err.Error()})
+		}
+		for _, redirectURI := range request.RedirectURIs {
+			if !isValidRedirectURI(redirectURI) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid redirect URI: " + redirectURI + "; use an https URL, or an http URL on localhost",
+				})
+			}
+		}
+		for _, scope := range request.Scopes {
+			if !isGrantableScope(scope) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error":            "Unknown scope: " + scope,
+					"available_scopes": grantableScopes,
+				})
+			}
+		}
+
+		clientID, err := newOpaqueToken()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error generating client ID"})
+		}
+		client := models.OAuthClient{
+			ClientID:     clientID,
+			Name:         request.Name,
+			RedirectURIs: strings.Join(request.RedirectURIs, " "),
+			Scopes:       strings.Join(request.Scopes, " "),
+			OwnerID:      principal.UserID(),
+		}
+
+		secret := ""
+		if !request.Public {
+			value, err := newOpaqueToken()
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error generating client secret"})
+			}
+			secret = oauthClientSecretPrefix + value
+			client.SecretHash = hashToken(secret)
+		}
+
+		if err := database.Create(&client).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to register client"})
+		}
+
+		response := newOAuthClientResponse(client)
+		response.ClientSecret = secret
+		return c.Status(fiber.StatusCreated).JSON(response)
+	}
+}
+
+// DeleteOAuthClient deletes one of the authenticated user's OAuth clients and revokes every authorization of it.
+func DeleteOAuthClient(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		id, err := strconv.ParseUint(c.Params("id"), 10, 32)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid client ID"})
+		}
+
+		var client models.OAuthClient
+		if err := database.Where("id = ? AND owner_id = ?", id, principal.UserID()).First(&client).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Client not found"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to delete client"})
+		}
+
+		err = database.Transaction(func(tx *gorm.DB) error {
+			var grantIDs []uint
+			if err := tx.Model(&models.OAuthGrant{}).Where("client_id = ? AND revoked_at IS NULL", client.ID).Pluck("id", &grantIDs).Error; err != nil {
+				return err
+			}
+			for _, grantID := range grantIDs {
+				if err := models.RevokeOAuthGrant(tx, grantID); err != nil {
+					return err
+				}
+			}
+			return tx.Delete(&client).Error
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to delete client"})
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// ListAuthorizedApps returns the third-party apps the authenticated user has authorized.
+func ListAuthorizedApps(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		var grants []models.OAuthGrant
+		if err := database.Where("user_id = ? AND revoked_at IS NULL", principal.UserID()).Order("created_at DESC").Find(&grants).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to list apps"})
+		}
+
+		response := make([]oauthAppResponse, 0, len(grants))
+		for _, grant := range grants {
+			var client models.OAuthClient
+			if err := database.First(&client, grant.ClientID).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					continue // The client was deleted
+				}
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to list apps"})
+			}
+			response = append(response, oauthAppResponse{
+				ID:           grant.ID,
+				ClientID:     client.ClientID,
+				Name:         client.Name,
+				Scopes:       grant.ScopeList(),
+				AuthorizedAt: grant.CreatedAt,
+				LastUsedAt:   grant.LastUsedAt,
+			})
+		}
+		return c.JSON(response)
+	}
+}
+
+// RevokeAuthorizedApp revokes the authenticated user's authorization of a third-party app. Its refresh
+// tokens stop working at once, and its access tokens from the next request on.
+func RevokeAuthorizedApp(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		id, err := strconv.ParseUint(c.Params("id"), 10, 32)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid app ID"})
+		}
+
+		var grant models.OAuthGrant
+		if err := database.Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, principal.UserID()).First(&grant).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "App not found"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to revoke app"})
+		}
+
+		if err := database.Transaction(func(tx *gorm.DB) error {
+			return models.RevokeOAuthGrant(tx, grant.ID)
+		}); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to revoke app"})
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
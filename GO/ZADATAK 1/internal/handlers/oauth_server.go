@@ -0,0 +1,608 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"zadatak-filip-janjesic/internal/models" // Import models for the OAuth structs
+	"zadatak-filip-janjesic/internal/oidc"   // PKCE helpers shared with the OpenID Connect client
+
+	"github.com/gofiber/fiber/v2" // Fiber for HTTP handling
+	"gorm.io/gorm"                // Database operations
+)
+
+// oauthCodeTTL is how long a client has to redeem an authorization code.
+const oauthCodeTTL = time.Minute
+
+// Error codes of the OAuth2 endpoints (RFC 6749, sections 4.1.2.1 and 5.2).
+const (
+	oauthInvalidRequest          = "invalid_request"
+	oauthInvalidClient           = "invalid_client"
+	oauthInvalidGrant            = "invalid_grant"
+	oauthInvalidScope            = "invalid_scope"
+	oauthAccessDenied            = "access_denied"
+	oauthUnsupportedGrantType    = "unsupported_grant_type"
+	oauthUnsupportedResponseType = "unsupported_response_type"
+	oauthServerError             = "server_error"
+)
+
+// Errors of the token endpoint that are reported to the client.
+var (
+	errInvalidOAuthClient = errors.New("invalid client")                              // Unknown client or wrong secret
+	errInvalidOAuthGrant  = errors.New("invalid authorization code or refresh token") // Code or token that cannot be redeemed
+)
+
+// authorizationRequest holds the parameters of a request to the authorization endpoint. The consent
+// form posts them back together with the user's decision.
+type authorizationRequest struct {
+	ResponseType        string `json:"response_type" form:"response_type" query:"response_type"`
+	ClientID            string `json:"client_id" form:"client_id" query:"client_id"`
+	RedirectURI         string `json:"redirect_uri" form:"redirect_uri" query:"redirect_uri"`
+	Scope               string `json:"scope" form:"scope" query:"scope"`
+	State               string `json:"state" form:"state" query:"state"`
+	CodeChallenge       string `json:"code_challenge" form:"code_challenge" query:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method" form:"code_challenge_method" query:"code_challenge_method"`
+	Prompt              string `json:"prompt" form:"prompt" query:"prompt"` // "consent" shows the consent screen even if the app was authorized before
+	Decision            string `json:"decision" form:"decision" query:"-"`  // "approve" or "deny", sent by the consent form
+}
+
+// authorization is a validated authorization request.
+type authorization struct {
+	authorizationRequest
+	Client      models.OAuthClient
+	RedirectURI string   // Redirect URI the response is sent to
+	Scopes      []string // Requested scopes, all allowed for the client
+}
+
+// oauthTokenResponse is the response of the token endpoint (RFC 6749, section 5.1).
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"` // Always "Bearer"
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+// consentPage is the consent screen shown to the user by the authorization endpoint.
+var consentPage = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>Authorize {{.Client.Name}}</title></head>
+<body>
+<h1>Authorize {{.Client.Name}}</h1>
+<p>{{.Client.Name}} wants to access your account as {{.Username}}. It will be able to:</p>
+<ul>
+{{range .Scopes}}<li>{{.}}</li>
+{{end}}</ul>
+<p>You will be redirected to {{.RedirectURI}}. You can revoke access at any time in your authorized apps.</p>
+<form method="post" action="/oauth/authorize">
+<input type="hidden" name="response_type" value="{{.ResponseType}}">
+<input type="hidden" name="client_id" value="{{.Client.ClientID}}">
+<input type="hidden" name="redirect_uri" value="{{.RedirectURI}}">
+<input type="hidden" name="scope" value="{{.Scope}}">
+<input type="hidden" name="state" value="{{.State}}">
+<input type="hidden" name="code_challenge" value="{{.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.CodeChallengeMethod}}">
+<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<button type="submit" name="decision" value="approve">Allow</button>
+<button type="submit" name="decision" value="deny">Deny</button>
+</form>
+</body>
+</html>
+`))
+
+// scopeDescriptions explain the scopes on the consent screen.
+var scopeDescriptions = map[string]string{
+	ScopeNotesRead:   "Read your notes",
+	ScopeNotesWrite:  "Create, change and delete your notes",
+	ScopeProfileRead: "Read your profile",
+}
+
+// OAuthAuthorize is the authorization endpoint. It validates the request of a third-party app and shows
+// the user the consent screen, or, for clients asking for JSON, describes the request so that the front
+// end can render its own. Apps the user already authorized for the requested scopes get a code right away.
+func OAuthAuthorize(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		var request authorizationRequest
+		if err := c.QueryParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid authorization request"})
+		}
+		auth, done, err := validateAuthorizationRequest(c, database, request)
+		if done {
+			return err
+		}
+
+		// Skip the consent screen for apps that were already authorized for these scopes
+		if request.Prompt != "consent" {
+			var grant models.OAuthGrant
+			err := database.Where("user_id = ? AND client_id = ? AND revoked_at IS NULL", principal.UserID(), auth.Client.ID).First(&grant).Error
+			if err == nil && containsAll(grant.ScopeList(), auth.Scopes) {
+				return approveAuthorization(c, database, principal, auth)
+			}
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to check authorization"})
+			}
+		}
+
+		scopes := make([]string, 0, len(auth.Scopes))
+		for _, scope := range auth.Scopes {
+			scopes = append(scopes, scopeDescriptions[scope])
+		}
+
+		if c.Accepts(fiber.MIMETextHTML, fiber.MIMEApplicationJSON) == fiber.MIMEApplicationJSON {
+			return c.JSON(fiber.Map{
+				"client_id":    auth.Client.ClientID,
+				"client_name":  auth.Client.Name,
+				"redirect_uri": auth.RedirectURI,
+				"scopes":       auth.Scopes,
+				"descriptions": scopes,
+			})
+		}
+
+		var page strings.Builder
+		if err := consentPage.Execute(&page, fiber.Map{
+			"Client":              auth.Client,
+			"Username":            principal.User.Username,
+			"Scopes":              scopes,
+			"RedirectURI":         auth.RedirectURI,
+			"ResponseType":        auth.ResponseType,
+			"Scope":               strings.Join(auth.Scopes, " "),
+			"State":               auth.State,
+			"CodeChallenge":       auth.CodeChallenge,
+			"CodeChallengeMethod": auth.CodeChallengeMethod,
+			"CSRFToken":           principal.csrfToken,
+		}); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to render consent screen"})
+		}
+
+		// The consent screen must never be framed by another site (clickjacking)
+		c.Set(fiber.HeaderXFrameOptions, "DENY")
+		c.Set(fiber.HeaderContentSecurityPolicy, "frame-ancestors 'none'")
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		c.Type("html", "utf-8")
+		return c.SendString(page.String())
+	}
+}
+
+// OAuthDecide records the user's decision on the consent screen and redirects back to the app, with an
+// authorization code if the user approved it. Requests with a JSON body get the redirect URL as JSON.
+func OAuthDecide(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		var request authorizationRequest
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid authorization request"})
+		}
+		auth, done, err := validateAuthorizationRequest(c, database, request)
+		if done {
+			return err
+		}
+
+		switch request.Decision {
+		case "approve":
+			return approveAuthorization(c, database, principal, auth)
+		case "deny":
+			return redirectToClient(c, auth, url.Values{"error": {oauthAccessDenied}, "error_description": {"The user denied access"}})
+		default:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "decision must be \"approve\" or \"deny\""})
+		}
+	}
+}
+
+// validateAuthorizationRequest checks an authorization request. Errors in the client ID or redirect URI
+// are shown to the user, since the response cannot safely be sent to the app; every other error is sent
+// to the app's redirect URI. done is true when a response has been written.
+func validateAuthorizationRequest(c *fiber.Ctx, database *gorm.DB, request authorizationRequest) (auth authorization, done bool, err error) {
+	auth.authorizationRequest = request
+
+	if err := database.Where("client_id = ?", request.ClientID).First(&auth.Client).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return auth, true, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Unknown client_id"})
+		}
+		return auth, true, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to look up client"})
+	}
+
+	// The redirect URI must match a registered one exactly; it may be omitted if only one is registered
+	registered := auth.Client.RedirectURIList()
+	switch {
+	case request.RedirectURI == "" && len(registered) == 1:
+		auth.RedirectURI = registered[0]
+	case containsAll(registered, []string{request.RedirectURI}):
+		auth.RedirectURI = request.RedirectURI
+	default:
+		return auth, true, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "redirect_uri is not registered for this client"})
+	}
+
+	if request.ResponseType != "code" {
+		return auth, true, redirectToClient(c, auth, url.Values{"error": {oauthUnsupportedResponseType}, "error_description": {"Only the code response type is supported"}})
+	}
+	if request.CodeChallenge == "" || request.CodeChallengeMethod != "S256" {
+		return auth, true, redirectToClient(c, auth, url.Values{"error": {oauthInvalidRequest}, "error_description": {"PKCE with code_challenge_method S256 is required"}})
+	}
+
+	// Without a scope parameter the client gets every scope it was registered with
+	auth.Scopes = strings.Fields(request.Scope)
+	if len(auth.Scopes) == 0 {
+		auth.Scopes = auth.Client.ScopeList()
+	}
+	if !containsAll(auth.Client.ScopeList(), auth.Scopes) {
+		return auth, true, redirectToClient(c, auth, url.Values{"error": {oauthInvalidScope}, "error_description": {"The client may not request these scopes"}})
+	}
+	return auth, false, nil
+}
+
+// approveAuthorization records that the user authorized the app for the requested scopes and redirects
+// back to the app with a new authorization code.
+func approveAuthorization(c *fiber.Ctx, database *gorm.DB, principal *Principal, auth authorization) error {
+	code, err := newOpaqueToken()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error generating authorization code"})
+	}
+
+	err = database.Transaction(func(tx *gorm.DB) error {
+		// One authorization per user and app, which grows as the app asks for more scopes
+		var grant models.OAuthGrant
+		err := tx.Where("user_id = ? AND client_id = ? AND revoked_at IS NULL", principal.UserID(), auth.Client.ID).First(&grant).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			grant = models.OAuthGrant{UserID: principal.UserID(), ClientID: auth.Client.ID, Scopes: strings.Join(auth.Scopes, " ")}
+			if err := tx.Create(&grant).Error; err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case !containsAll(grant.ScopeList(), auth.Scopes):
+			scopes := mergeScopes(grant.ScopeList(), auth.Scopes)
+			if err := tx.Model(&grant).Update("scopes", strings.Join(scopes, " ")).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Create(&models.OAuthAuthorizationCode{
+			CodeHash:      hashToken(code),
+			GrantID:       grant.ID,
+			RedirectURI:   auth.RedirectURI,
+			Scopes:        strings.Join(auth.Scopes, " "),
+			CodeChallenge: auth.CodeChallenge,
+			ExpiresAt:     time.Now().Add(oauthCodeTTL),
+		}).Error
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to authorize app"})
+	}
+
+	return redirectToClient(c, auth, url.Values{"code": {code}})
+}
+
+// redirectToClient sends the response of the authorization endpoint to the app's redirect URI, together
+// with the state of the request.
+func redirectToClient(c *fiber.Ctx, auth authorization, params url.Values) error {
+	target, err := url.Parse(auth.RedirectURI)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid redirect_uri"})
+	}
+	query := target.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if auth.State != "" {
+		query.Set("state", auth.State)
+	}
+	target.RawQuery = query.Encode()
+
+	if c.Is("json") {
+		return c.JSON(fiber.Map{"redirect_to": target.String()})
+	}
+	return c.Redirect(target.String(), fiber.StatusSeeOther)
+}
+
+// OAuthToken is the token endpoint. Apps exchange an authorization code (grant_type=authorization_code,
+// with the PKCE code_verifier) or a refresh token (grant_type=refresh_token) for an access token and a
+// new refresh token. Confidential clients authenticate with HTTP Basic or client_secret in the body.
+func OAuthToken(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Token responses must never be cached
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		c.Set(fiber.HeaderPragma, "no-cache")
+
+		client, err := authenticateOAuthClient(c, database)
+		if err != nil {
+			if errors.Is(err, errInvalidOAuthClient) {
+				c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="oauth"`)
+				return oauthError(c, fiber.StatusUnauthorized, oauthInvalidClient, "Client authentication failed")
+			}
+			return oauthError(c, fiber.StatusInternalServerError, oauthServerError, "Unable to authenticate client")
+		}
+
+		var response oauthTokenResponse
+		switch c.FormValue("grant_type") {
+		case "authorization_code":
+			response, err = redeemAuthorizationCode(database, client, c.FormValue("code"), c.FormValue("redirect_uri"), c.FormValue("code_verifier"))
+		case "refresh_token":
+			response, err = redeemOAuthRefreshToken(database, client, c.FormValue("refresh_token"), strings.Fields(c.FormValue("scope")))
+		case "":
+			return oauthError(c, fiber.StatusBadRequest, oauthInvalidRequest, "grant_type is required")
+		default:
+			return oauthError(c, fiber.StatusBadRequest, oauthUnsupportedGrantType, "Supported grant types are authorization_code and refresh_token")
+		}
+		if err != nil {
+			if errors.Is(err, errInvalidOAuthGrant) {
+				return oauthError(c, fiber.StatusBadRequest, oauthInvalidGrant, "The code or refresh token is invalid, expired or revoked")
+			}
+			log.Printf("Error issuing OAuth tokens to client %s: %v", client.ClientID, err)
+			return oauthError(c, fiber.StatusInternalServerError, oauthServerError, "Unable to issue tokens")
+		}
+		return c.JSON(response)
+	}
+}
+
+// oauthError writes an error response of the token endpoint (RFC 6749, section 5.2).
+func oauthError(c *fiber.Ctx, status int, code, description string) error {
+	return c.Status(status).JSON(fiber.Map{"error": code, "error_description": description})
+}
+
+// authenticateOAuthClient identifies the client of a token request. Confidential clients must present
+// their secret, with HTTP Basic authentication or as client_secret; public clients only send client_id.
+func authenticateOAuthClient(c *fiber.Ctx, database *gorm.DB) (models.OAuthClient, error) {
+	clientID, secret := c.FormValue("client_id"), c.FormValue("client_secret")
+	if header := c.Get(fiber.HeaderAuthorization); strings.HasPrefix(header, "Basic ") {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(header, "Basic "))
+		if err != nil {
+			return models.OAuthClient{}, errInvalidOAuthClient
+		}
+		id, password, found := strings.Cut(string(decoded), ":")
+		if !found {
+			return models.OAuthClient{}, errInvalidOAuthClient
+		}
+		// Both halves are form-encoded (RFC 6749, section 2.3.1)
+		if clientID, err = url.QueryUnescape(id); err != nil {
+			return models.OAuthClient{}, errInvalidOAuthClient
+		}
+		if secret, err = url.QueryUnescape(password); err != nil {
+			return models.OAuthClient{}, errInvalidOAuthClient
+		}
+	}
+	if clientID == "" {
+		return models.OAuthClient{}, errInvalidOAuthClient
+	}
+
+	var client models.OAuthClient
+	if err := database.Where("client_id = ?", clientID).First(&client).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.OAuthClient{}, errInvalidOAuthClient
+		}
+		return models.OAuthClient{}, err
+	}
+
+	if client.IsConfidential() {
+		if secret == "" || subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(client.SecretHash)) != 1 {
+			return models.OAuthClient{}, errInvalidOAuthClient
+		}
+	} else if secret != "" {
+		return models.OAuthClient{}, errInvalidOAuthClient
+	}
+	return client, nil
+}
+
+// redeemAuthorizationCode exchanges an authorization code for tokens. The redirect URI must be the one of
+// the authorization request, and the PKCE verifier must match its challenge. A code presented a second
+// time revokes the authorization, since one of the two requests did not come from the app.
+func redeemAuthorizationCode(database *gorm.DB, client models.OAuthClient, code, redirectURI, verifier string) (oauthTokenResponse, error) {
+	if code == "" {
+		return oauthTokenResponse{}, errInvalidOAuthGrant
+	}
+
+	var stored models.OAuthAuthorizationCode
+	if err := database.Where("code_hash = ?", hashToken(code)).First(&stored).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return oauthTokenResponse{}, errInvalidOAuthGrant
+		}
+		return oauthTokenResponse{}, err
+	}
+
+	grant, err := activeOAuthGrant(database, stored.GrantID, client)
+	if err != nil {
+		return oauthTokenResponse{}, err
+	}
+
+	// Mark the code as used; the condition on used_at makes concurrent redemptions of the same code lose
+	result := database.Model(&models.OAuthAuthorizationCode{}).
+		Where("id = ? AND used_at IS NULL", stored.ID).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return oauthTokenResponse{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		log.Printf("Authorization code reuse detected for client %s, revoking grant %d", client.ClientID, grant.ID)
+		if err := models.RevokeOAuthGrant(database, grant.ID); err != nil {
+			return oauthTokenResponse{}, fmt.Errorf("error revoking grant: %w", err)
+		}
+		return oauthTokenResponse{}, errInvalidOAuthGrant
+	}
+
+	if time.Now().After(stored.ExpiresAt) || redirectURI != stored.RedirectURI {
+		return oauthTokenResponse{}, errInvalidOAuthGrant
+	}
+	if len(verifier) < 43 || len(verifier) > 128 ||
+		subtle.ConstantTimeCompare([]byte(oidc.CodeChallengeS256(verifier)), []byte(stored.CodeChallenge)) != 1 {
+		return oauthTokenResponse{}, errInvalidOAuthGrant
+	}
+
+	return issueOAuthTokens(database, client, grant, strings.Fields(stored.Scopes))
+}
+
+// redeemOAuthRefreshToken exchanges a refresh token of a client for new tokens, optionally with fewer
+// scopes. Like the refresh tokens of logins, each one is used once, and reuse revokes the authorization.
+func redeemOAuthRefreshToken(database *gorm.DB, client models.OAuthClient, presented string, scopes []string) (oauthTokenResponse, error) {
+	if presented == "" {
+		return oauthTokenResponse{}, errInvalidOAuthGrant
+	}
+
+	var stored models.OAuthRefreshToken
+	if err := database.Where("token_hash = ?", hashToken(presented)).First(&stored).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return oauthTokenResponse{}, errInvalidOAuthGrant
+		}
+		return oauthTokenResponse{}, err
+	}
+	if stored.RevokedAt != nil {
+		return oauthTokenResponse{}, errInvalidOAuthGrant
+	}
+
+	grant, err := activeOAuthGrant(database, stored.GrantID, client)
+	if err != nil {
+		return oauthTokenResponse{}, err
+	}
+
+	result := database.Model(&models.OAuthRefreshToken{}).
+		Where("id = ? AND used_at IS NULL", stored.ID).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return oauthTokenResponse{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		log.Printf("OAuth refresh token reuse detected for client %s, revoking grant %d", client.ClientID, grant.ID)
+		if err := models.RevokeOAuthGrant(database, grant.ID); err != nil {
+			return oauthTokenResponse{}, fmt.Errorf("error revoking grant: %w", err)
+		}
+		return oauthTokenResponse{}, errInvalidOAuthGrant
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return oauthTokenResponse{}, errInvalidOAuthGrant
+	}
+
+	// A refresh may narrow the scopes, but never widen them
+	granted := strings.Fields(stored.Scopes)
+	if len(scopes) == 0 {
+		scopes = granted
+	}
+	if !containsAll(granted, scopes) {
+		return oauthTokenResponse{}, errInvalidOAuthGrant
+	}
+
+	return issueOAuthTokens(database, client, grant, scopes)
+}
+
+// activeOAuthGrant loads an authorization that has not been revoked and belongs to the client.
+func activeOAuthGrant(database *gorm.DB, grantID uint, client models.OAuthClient) (models.OAuthGrant, error) {
+	var grant models.OAuthGrant
+	if err := database.Where("id = ? AND client_id = ? AND revoked_at IS NULL", grantID, client.ID).First(&grant).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.OAuthGrant{}, errInvalidOAuthGrant
+		}
+		return models.OAuthGrant{}, err
+	}
+	return grant, nil
+}
+
+// issueOAuthTokens issues an access token and a refresh token with the given scopes to a client.
+func issueOAuthTokens(database *gorm.DB, client models.OAuthClient, grant models.OAuthGrant, scopes []string) (oauthTokenResponse, error) {
+	var user models.User
+	if err := database.First(&user, grant.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return oauthTokenResponse{}, errInvalidOAuthGrant
+		}
+		return oauthTokenResponse{}, err
+	}
+	if user.DisabledAt != nil {
+		return oauthTokenResponse{}, errInvalidOAuthGrant
+	}
+
+	jti, err := newOpaqueToken()
+	if err != nil {
+		return oauthTokenResponse{}, err
+	}
+	scope := strings.Join(scopes, " ")
+	claims := newClaims(user, purposeAccess, jti, time.Now(), accessTokenTTL())
+	claims.GrantID = grant.ID
+	claims.ClientID = client.ClientID
+	claims.Scope = scope
+	accessToken, err := keyring.Sign(claims)
+	if err != nil {
+		return oauthTokenResponse{}, fmt.Errorf("error generating token: %w", err)
+	}
+
+	refreshToken, err := newOpaqueToken()
+	if err != nil {
+		return oauthTokenResponse{}, err
+	}
+	if err := database.Create(&models.OAuthRefreshToken{
+		GrantID:   grant.ID,
+		TokenHash: hashToken(refreshToken),
+		Scopes:    scope,
+		ExpiresAt: time.Now().Add(refreshTokenTTL()),
+	}).Error; err != nil {
+		return oauthTokenResponse{}, fmt.Errorf("error storing refresh token: %w", err)
+	}
+
+	return oauthTokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(accessTokenTTL().Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        scope,
+	}, nil
+}
+
+// touchOAuthGrant reports whether the authorization of an access token is still active and records that
+// the app used it, at most once per interval.
+func touchOAuthGrant(database *gorm.DB, grantID, userID uint) (bool, error) {
+	var grant models.OAuthGrant
+	if err := database.Where("id = ? AND user_id = ? AND revoked_at IS NULL", grantID, userID).First(&grant).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	now := time.Now()
+	if grant.LastUsedAt == nil || now.Sub(*grant.LastUsedAt) > lastUsedUpdateInterval {
+		if err := database.Model(&grant).Update("last_used_at", now).Error; err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// containsAll reports whether every wanted value is in the list.
+func containsAll(list, wanted []string) bool {
+	for _, value := range wanted {
+		found := false
+		for _, candidate := range list {
+			if candidate == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeScopes returns the scopes of both lists, without duplicates.
+func mergeScopes(a, b []string) []string {
+	merged := append([]string{}, a...)
+	for _, scope := range b {
+		if !containsAll(merged, []string{scope}) {
+			merged = append(merged, scope)
+		}
+	}
+	return merged
+}
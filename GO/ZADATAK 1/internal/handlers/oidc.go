@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"zadatak-filip-janjesic/internal/models" // Import models for user and identity structs
+	"zadatak-filip-janjesic/internal/oidc"   // OpenID Connect client
+
+	"github.com/gofiber/fiber/v2" // Fiber for HTTP handling
+	"gorm.io/gorm"                // Database operations
+)
+
+// oidcLoginTTL is how long a user has to complete a login at the provider.
+const oidcLoginTTL = 10 * time.Minute
+
+// oidcStateCookie binds a login started at the provider to the browser that started it.
+const oidcStateCookie = "oidc_state"
+
+// OpenID Connect provider for single sign-on; nil unless configured. Set from main with SetOIDCProvider.
+var oidcProvider *oidc.Provider
+
+// Reasons for refusing a login through the provider.
+var (
+	errOIDCEmailTaken = errors.New("email address belongs to an account that cannot be linked")
+	errOIDCNoEmail    = errors.New("no verified email address in the ID token")
+	errOIDCNoAccount  = errors.New("no linked account and provisioning is disabled")
+)
+
+// usernameDisallowed matches characters that provisioned usernames may not contain.
+var usernameDisallowed = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// SetOIDCProvider sets the provider used for single sign-on.
+func SetOIDCProvider(p *oidc.Provider) {
+	oidcProvider = p
+}
+
+// OIDCCallbackURL returns the callback URL to register at the provider.
+func OIDCCallbackURL() string {
+	return appURL("/auth/oidc/callback", nil)
+}
+
+// OIDCLogin starts a login at the OpenID Connect provider: it stores the state, nonce and PKCE
+// verifier of the login and redirects the browser to the provider.
+func OIDCLogin(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if oidcProvider == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Single sign-on is not configured"})
+		}
+		sessionMode := c.Query("session_mode")
+		if !isValidSessionMode(sessionMode) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "session_mode must be \"token\" or \"cookie\""})
+		}
+
+		state, err := newOpaqueToken()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to start login"})
+		}
+		nonce, err := newOpaqueToken()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to start login"})
+		}
+		verifier, err := oidc.NewCodeVerifier()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to start login"})
+		}
+
+		redirect, err := oidcProvider.AuthCodeURL(c.UserContext(), state, nonce, oidc.CodeChallengeS256(verifier))
+		if err != nil {
+			log.Printf("Error contacting the OIDC provider: %v", err)
+			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "Identity provider unavailable"})
+		}
+
+		record := models.OIDCLoginState{
+			StateHash:    hashToken(state),
+			Nonce:        nonce,
+			CodeVerifier: verifier,
+			SessionMode:  sessionMode,
+			ExpiresAt:    time.Now().Add(oidcLoginTTL),
+		}
+		if err := database.Create(&record).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to start login"})
+		}
+
+		c.Cookie(&fiber.Cookie{
+			Name:     oidcStateCookie,
+			Value:    state,
+			Path:     "/auth/oidc",
+			MaxAge:   int(oidcLoginTTL.Seconds()),
+			Secure:   strings.HasPrefix(appURL("", nil), "https://"),
+			HTTPOnly: true,
+			SameSite: fiber.CookieSameSiteLaxMode, // Sent along with the provider's redirect back to us
+		})
+		return c.Redirect(redirect, fiber.StatusFound)
+	}
+}
+
+// OIDCCallback completes a login at the provider. It checks the state, redeems the code with the PKCE
+// verifier, verifies the ID token, and then logs in the linked user, links an existing user by verified
+// email address, or provisions a new user from the claims.
+func OIDCCallback(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if oidcProvider == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Single sign-on is not configured"})
+		}
+		if providerError := c.Query("error"); providerError != "" {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Login at the identity provider failed: " + providerError})
+		}
+
+		// The state must come back to the browser that started the login, and only once
+		state := c.Query("state")
+		if state == "" || c.Cookies(oidcStateCookie) != state {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid login state; start the login again"})
+		}
+		c.ClearCookie(oidcStateCookie)
+
+		var record models.OIDCLoginState
+		if err := database.Where("state_hash = ? AND expires_at > ?", hashToken(state), time.Now()).First(&record).Error; err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid login state; start the login again"})
+		}
+		result := database.Unscoped().Where("id = ?", record.ID).Delete(&models.OIDCLoginState{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid login state; start the login again"})
+		}
+
+		claims, err := oidcProvider.Exchange(c.UserContext(), c.Query("code"), record.CodeVerifier, record.Nonce)
+		if err != nil {
+			log.Printf("Error completing OIDC login: %v", err)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Login at the identity provider could not be verified"})
+		}
+
+		user, err := userForExternalIdentity(database, claims)
+		if err != nil {
+			switch {
+			case errors.Is(err, errOIDCEmailTaken):
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "An account with this email address already exists; log in with your password"})
+			case errors.Is(err, errOIDCNoEmail):
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "The identity provider did not share a verified email address"})
+			case errors.Is(err, errOIDCNoAccount):
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "No account is linked to this identity"})
+			}
+			log.Printf("Error signing in external identity %s: %v", claims.Subject, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to sign in"})
+		}
+
+		if err := checkLoginAllowedState(user); err != nil {
+			return refuseLogin(c, err)
+		}
+		if user.TOTPEnabledAt != nil {
+			return respondWithMFAChallenge(c, user)
+		}
+
+		return respondWithLogin(c, database, user, newSessionInfo(c, ""), record.SessionMode)
+	}
+}
+
+// userForExternalIdentity returns the user linked to the identity in the ID token. An unlinked identity
+// is linked to the user with the same email address if both the provider and this service verified it;
+// otherwise a new user is provisioned, unless OIDC_AUTO_PROVISION is "false".
+func userForExternalIdentity(database *gorm.DB, claims *oidc.Claims) (models.User, error) {
+	var user models.User
+	err := database.Transaction(func(tx *gorm.DB) error {
+		var identity models.ExternalIdentity
+		err := tx.Where("issuer = ? AND subject = ?", oidcProvider.Issuer(), claims.Subject).First(&identity).Error
+		if err == nil {
+			if err := tx.First(&user, identity.UserID).Error; err != nil {
+				return err
+			}
+			return tx.Model(&identity).Updates(map[string]interface{}{"email": claims.Email, "last_login_at": time.Now()}).Error
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		// Without an email address the provider vouches for, the identity can neither be linked nor provisioned
+		if claims.Email == "" || !claims.EmailVerified {
+			return errOIDCNoEmail
+		}
+
+		err = tx.Where("LOWER(email) = ?", strings.ToLower(claims.Email)).First(&user).Error
+		switch {
+		case err == nil:
+			// Only link to an address this service verified too; otherwise whoever registered it first
+			// (possibly not its owner) would get the SSO account
+			if user.EmailVerifiedAt == nil {
+				return errOIDCEmailTaken
+			}
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			if os.Getenv("OIDC_AUTO_PROVISION") == "false" {
+				return errOIDCNoAccount
+			}
+			if user, err = provisionUser(tx, claims); err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+
+		return tx.Create(&models.ExternalIdentity{
+			UserID:      user.ID,
+			Issuer:      oidcProvider.Issuer(),
+			Subject:     claims.Subject,
+			Email:       claims.Email,
+			LastLoginAt: time.Now(),
+		}).Error
+	})
+	return user, err
+}
+
+// provisionUser creates a user from the claims of an ID token. The user gets a random password that
+// nobody knows; they can set one through the password reset flow.
+func provisionUser(tx *gorm.DB, claims *oidc.Claims) (models.User, error) {
+	secret, err := newOpaqueToken()
+	if err != nil {
+		return models.User{}, err
+	}
+	hashedPassword, err := hashPassword(secret)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	username, err := availableUsername(tx, claims)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	firstName, lastName := claims.GivenName, claims.FamilyName
+	if firstName == "" && lastName == "" {
+		firstName, lastName, _ = strings.Cut(strings.TrimSpace(claims.Name), " ")
+	}
+	if firstName == "" {
+		firstName = username
+	}
+	if lastName == "" {
+		lastName = "-"
+	}
+
+	now := time.Now()
+	user := models.User{
+		Username:        username,
+		Password:        hashedPassword,
+		FirstName:       firstName,
+		LastName:        lastName,
+		Email:           claims.Email,
+		EmailVerifiedAt: &now, // The provider verified the address
+		Role:            models.RoleUser,
+	}
+	if err := tx.Create(&user).Error; err != nil {
+		return models.User{}, err
+	}
+	log.Printf("Provisioned user %d (%s) for external identity %s", user.ID, user.Username, claims.Subject)
+	return user, nil
+}
+
+// availableUsername derives an unused username from the preferred username or email address in the claims.
+func availableUsername(tx *gorm.DB, claims *oidc.Claims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base = usernameDisallowed.ReplaceAllString(base, "")
+	if len(base) > 28 {
+		base = base[:28] // Leaves room for a numeric suffix within the 32 character limit
+	}
+	for len(base) < 3 {
+		base += "_"
+	}
+
+	for i := 0; i < 100; i++ {
+		candidate := base
+		if i > 0 {
+			candidate = fmt.Sprintf("%s%d", base, i+1)
+		}
+		var count int64
+		if err := tx.Model(&models.User{}).Unscoped().Where("username = ?", candidate).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no free username for %q", base)
+}
@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"zadatak-filip-janjesic/internal/models"
+	"zadatak-filip-janjesic/internal/oidc"
+	"zadatak-filip-janjesic/internal/oidc/oidctest"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// oidcTestEnv is the API with single sign-on through a mock provider.
+type oidcTestEnv struct {
+	database *gorm.DB
+	app      *fiber.App
+	provider *oidctest.Provider
+}
+
+func newOIDCTestEnv(t *testing.T) *oidcTestEnv {
+	t.Helper()
+	database := newTestDB(t)
+	useTestKeyring(t, database)
+	useTestPasswordHasher(t)
+
+	provider, err := oidctest.NewProvider("notes", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(provider.Close)
+	previous := oidcProvider
+	SetOIDCProvider(oidc.New(oidc.Config{
+		Issuer:       provider.Issuer(),
+		ClientID:     "notes",
+		ClientSecret: "secret",
+		RedirectURL:  OIDCCallbackURL(),
+		Scopes:       []string{"openid", "email", "profile"},
+	}, nil))
+	t.Cleanup(func() { SetOIDCProvider(previous) })
+
+	app := fiber.New()
+	app.Get("/auth/oidc/login", OIDCLogin(database))
+	app.Get("/auth/oidc/callback", OIDCCallback(database))
+	return &oidcTestEnv{database: database, app: app, provider: provider}
+}
+
+// do sends a request to the API with the cookie, if any, and returns the response and its body.
+func (env *oidcTestEnv) do(t *testing.T, target, cookie string) (*http.Response, []byte) {
+	t.Helper()
+	request := httptest.NewRequest(http.MethodGet, target, nil)
+	if cookie != "" {
+		request.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: cookie})
+	}
+	response, err := env.app.Test(request, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response, body
+}
+
+// startLogin starts a login and follows the redirect to the provider. It returns the state cookie and the
+// callback URL the provider redirects the browser back to, with the code and state.
+func (env *oidcTestEnv) startLogin(t *testing.T, identity oidctest.Identity) (string, *url.URL) {
+	t.Helper()
+	env.provider.SetIdentity(identity)
+
+	response, body := env.do(t, "/auth/oidc/login", "")
+	if response.StatusCode != fiber.StatusFound {
+		t.Fatalf("login: status %d: %s", response.StatusCode, body)
+	}
+	var state string
+	for _, cookie := range response.Cookies() {
+		if cookie.Name == oidcStateCookie {
+			state = cookie.Value
+		}
+	}
+	authorize, err := url.Parse(response.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(authorize.String(), env.provider.Issuer()+"/authorize?") {
+		t.Fatalf("login redirected to %q", response.Header.Get("Location"))
+	}
+
+	// The redirect carries the state of the cookie and the nonce and PKCE challenge of the stored login
+	var record models.OIDCLoginState
+	if err := env.database.Where("state_hash = ?", hashToken(state)).First(&record).Error; err != nil {
+		t.Fatalf("login state not stored: %v", err)
+	}
+	query := authorize.Query()
+	if query.Get("state") != state || query.Get("nonce") != record.Nonce ||
+		query.Get("code_challenge") != oidc.CodeChallengeS256(record.CodeVerifier) || query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("authorization request %v does not match the stored login", query)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	atProvider, err := client.Get(authorize.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	atProvider.Body.Close()
+	callback, err := url.Parse(atProvider.Header.Get("Location"))
+	if err != nil || callback.Path != "/auth/oidc/callback" {
+		t.Fatalf("provider redirected to %q", atProvider.Header.Get("Location"))
+	}
+	return state, callback
+}
+
+// callback completes a login at the API.
+func (env *oidcTestEnv) callback(t *testing.T, state string, callback *url.URL) (*http.Response, []byte) {
+	t.Helper()
+	return env.do(t, "/auth/oidc/callback?"+callback.RawQuery, state)
+}
+
+// login runs a whole login for the identity and returns the final response.
+func (env *oidcTestEnv) login(t *testing.T, identity oidctest.Identity) (*http.Response, []byte) {
+	t.Helper()
+	state, callback := env.startLogin(t, identity)
+	return env.callback(t, state, callback)
+}
+
+// loggedInUser returns the user the access token of a successful login was issued to.
+func loggedInUser(t *testing.T, body []byte) uint {
+	t.Helper()
+	var pair TokenPair
+	if err := json.Unmarshal(body, &pair); err != nil {
+		t.Fatalf("decoding token pair: %v", err)
+	}
+	claims, err := parseAccessToken(pair.Token)
+	if err != nil {
+		t.Fatalf("access token of the login does not verify: %v", err)
+	}
+	return claims.UserID
+}
+
+func TestOIDCLoginProvisionsAndLinks(t *testing.T) {
+	env := newOIDCTestEnv(t)
+	now := time.Now()
+	verified := createTestUser(t, env.database, "bob")
+	env.database.Model(&verified).Update("email_verified_at", now)
+	createTestUser(t, env.database, "carol") // Address not verified by this service
+	withTOTP := createTestUser(t, env.database, "dave")
+	env.database.Model(&withTOTP).Updates(map[string]interface{}{"email_verified_at": now, "totp_secret": "GEZDGNBVGY3TQOJQ", "totp_enabled_at": now})
+	disabled := createTestUser(t, env.database, "erin")
+	env.database.Model(&disabled).Updates(map[string]interface{}{"email_verified_at": now, "disabled_at": now})
+
+	tests := []struct {
+		name       string
+		identity   oidctest.Identity
+		wantStatus int
+		wantUser   string // Username of the user logged in, if any
+		wantMFA    bool
+	}{
+		{"new identity is provisioned", oidctest.Identity{Subject: "alice-1", Email: "alice@example.com", EmailVerified: true, PreferredUsername: "alice", GivenName: "Alice", FamilyName: "Smith"}, fiber.StatusOK, "alice", false},
+		{"linked identity logs in again", oidctest.Identity{Subject: "alice-1", Email: "alice@example.com", EmailVerified: true}, fiber.StatusOK, "alice", false},
+		{"taken username gets a suffix", oidctest.Identity{Subject: "alice-2", Email: "alice2@example.com", EmailVerified: true, PreferredUsername: "alice"}, fiber.StatusOK, "alice2", false},
+		{"linked by verified email", oidctest.Identity{Subject: "bob-1", Email: "BOB@example.com", EmailVerified: true}, fiber.StatusOK, "bob", false},
+		{"email not verified here", oidctest.Identity{Subject: "carol-1", Email: "carol@example.com", EmailVerified: true}, fiber.StatusConflict, "", false},
+		{"email not verified by the provider", oidctest.Identity{Subject: "frank-1", Email: "frank@example.com"}, fiber.StatusForbidden, "", false},
+		{"second factor still required", oidctest.Identity{Subject: "dave-1", Email: "dave@example.com", EmailVerified: true}, fiber.StatusOK, "", true},
+		{"disabled account", oidctest.Identity{Subject: "erin-1", Email: "erin@example.com", EmailVerified: true}, fiber.StatusForbidden, "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response, body := env.login(t, test.identity)
+			if response.StatusCode != test.wantStatus {
+				t.Fatalf("status %d, want %d: %s", response.StatusCode, test.wantStatus, body)
+			}
+			if test.wantMFA && !strings.Contains(string(body), `"mfa_required":true`) {
+				t.Errorf("response %s does not ask for the second factor", body)
+			}
+			if test.wantUser == "" {
+				return
+			}
+
+			var user models.User
+			if err := env.database.Where("username = ?", test.wantUser).First(&user).Error; err != nil {
+				t.Fatalf("user %s: %v", test.wantUser, err)
+			}
+			if id := loggedInUser(t, body); id != user.ID {
+				t.Errorf("logged in as user %d, want %s (%d)", id, test.wantUser, user.ID)
+			}
+			if user.EmailVerifiedAt == nil {
+				t.Errorf("user %s has no verified address", user.Username)
+			}
+			var identity models.ExternalIdentity
+			if err := env.database.Where("issuer = ? AND subject = ?", env.provider.Issuer(), test.identity.Subject).First(&identity).Error; err != nil || identity.UserID != user.ID {
+				t.Errorf("identity %s linked to %d (%v), want %d", test.identity.Subject, identity.UserID, err, user.ID)
+			}
+		})
+	}
+
+	var alice models.User
+	if err := env.database.Where("username = ?", "alice").First(&alice).Error; err != nil {
+		t.Fatal(err)
+	}
+	if alice.FirstName != "Alice" || alice.LastName != "Smith" || alice.Email != "alice@example.com" {
+		t.Errorf("provisioned user = %+v", alice)
+	}
+	// Identities are linked before the second factor and the account state are checked
+	var identities int64
+	env.database.Model(&models.ExternalIdentity{}).Count(&identities)
+	if identities != 5 {
+		t.Errorf("%d external identities, want 5", identities)
+	}
+}
+
+func TestOIDCLoginWithoutProvisioning(t *testing.T) {
+	env := newOIDCTestEnv(t)
+	t.Setenv("OIDC_AUTO_PROVISION", "false")
+
+	response, body := env.login(t, oidctest.Identity{Subject: "alice-1", Email: "alice@example.com", EmailVerified: true})
+	if response.StatusCode != fiber.StatusForbidden {
+		t.Fatalf("status %d, want 403: %s", response.StatusCode, body)
+	}
+	var users int64
+	env.database.Model(&models.User{}).Count(&users)
+	if users != 0 {
+		t.Errorf("%d users were created", users)
+	}
+}
+
+func TestOIDCCallbackRejects(t *testing.T) {
+	identity := oidctest.Identity{Subject: "alice-1", Email: "alice@example.com", EmailVerified: true}
+
+	tests := []struct {
+		name       string
+		tamper     func(env *oidcTestEnv, state *string, callback *url.URL)
+		wantStatus int
+	}{
+		{"no state cookie", func(_ *oidcTestEnv, state *string, _ *url.URL) { *state = "" }, fiber.StatusBadRequest},
+		{"state of another browser", func(_ *oidcTestEnv, state *string, _ *url.URL) { *state = "other" }, fiber.StatusBadRequest},
+		{"state in the cookie and the query differ", func(_ *oidcTestEnv, _ *string, callback *url.URL) {
+			query := callback.Query()
+			query.Set("state", "other")
+			callback.RawQuery = query.Encode()
+		}, fiber.StatusBadRequest},
+		{"expired login", func(env *oidcTestEnv, _ *string, _ *url.URL) {
+			env.database.Model(&models.OIDCLoginState{}).Where("1 = 1").Update("expires_at", time.Now().Add(-time.Minute))
+		}, fiber.StatusBadRequest},
+		{"error at the provider", func(_ *oidcTestEnv, _ *string, callback *url.URL) {
+			query := callback.Query()
+			query.Set("error", "access_denied")
+			callback.RawQuery = query.Encode()
+		}, fiber.StatusUnauthorized},
+		{"other PKCE verifier", func(env *oidcTestEnv, _ *string, _ *url.URL) {
+			env.database.Model(&models.OIDCLoginState{}).Where("1 = 1").Update("code_verifier", "other-verifier-other-verifier-other-verifier")
+		}, fiber.StatusUnauthorized},
+		{"ID token for another nonce", func(env *oidcTestEnv, _ *string, _ *url.URL) {
+			env.database.Model(&models.OIDCLoginState{}).Where("1 = 1").Update("nonce", "other")
+		}, fiber.StatusUnauthorized},
+		{"unknown code", func(_ *oidcTestEnv, _ *string, callback *url.URL) {
+			query := callback.Query()
+			query.Set("code", "unknown")
+			callback.RawQuery = query.Encode()
+		}, fiber.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := newOIDCTestEnv(t)
+			state, callback := env.startLogin(t, identity)
+			test.tamper(env, &state, callback)
+			if response, body := env.callback(t, state, callback); response.StatusCode != test.wantStatus {
+				t.Errorf("status %d, want %d: %s", response.StatusCode, test.wantStatus, body)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackIsSingleUse(t *testing.T) {
+	env := newOIDCTestEnv(t)
+	state, callback := env.startLogin(t, oidctest.Identity{Subject: "alice-1", Email: "alice@example.com", EmailVerified: true})
+	if response, body := env.callback(t, state, callback); response.StatusCode != fiber.StatusOK {
+		t.Fatalf("status %d: %s", response.StatusCode, body)
+	}
+	if response, body := env.callback(t, state, callback); response.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("replayed callback: status %d, want 400: %s", response.StatusCode, body)
+	}
+}
+
+func TestOIDCNotConfigured(t *testing.T) {
+	env := newOIDCTestEnv(t)
+	SetOIDCProvider(nil)
+	for _, target := range []string{"/auth/oidc/login", "/auth/oidc/callback?state=x&code=y"} {
+		if response, body := env.do(t, target, "x"); response.StatusCode != fiber.StatusNotFound {
+			t.Errorf("%s: status %d, want 404: %s", target, response.StatusCode, body)
+		}
+	}
+}
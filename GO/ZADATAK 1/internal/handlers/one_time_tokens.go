@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"zadatak-filip-janjesic/internal/models" // Import models for the one-time token struct
+
+	"gorm.io/gorm" // GORM for ORM and database operations
+)
+
+// errInvalidOneTimeToken is returned for one-time tokens that are unknown, expired, already used or issued for another purpose.
+var errInvalidOneTimeToken = errors.New("invalid or expired token")
+
+// issueOneTimeToken creates a new one-time token for the user and purpose. Earlier unused tokens
+// for the same purpose are invalidated, so only the most recent email works.
+func issueOneTimeToken(database *gorm.DB, userID uint, purpose string, ttl time.Duration) (string, error) {
+	token, err := newOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = database.Transaction(func(tx *gorm.DB) error {
+		// Invalidate tokens that were issued earlier for the same purpose
+		if err := tx.Model(&models.OneTimeToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+			Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&models.OneTimeToken{
+			UserID:    userID,
+			Purpose:   purpose,
+			TokenHash: hashToken(token),
+			ExpiresAt: time.Now().Add(ttl),
+		}).Error
+	})
+	if err != nil {
+		return "", fmt.Errorf("error storing one-time token: %w", err)
+	}
+	return token, nil
+}
+
+// consumeOneTimeToken redeems a one-time token for the given purpose and returns its record.
+// The token is marked as used in the same statement that checks it, so it can be redeemed only once.
+func consumeOneTimeToken(database *gorm.DB, token, purpose string) (models.OneTimeToken, error) {
+	now := time.Now()
+	result := database.Model(&models.OneTimeToken{}).
+		Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", hashToken(token), purpose, now).
+		Update("used_at", now)
+	if result.Error != nil {
+		return models.OneTimeToken{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return models.OneTimeToken{}, errInvalidOneTimeToken
+	}
+
+	var record models.OneTimeToken
+	if err := database.Where("token_hash = ?", hashToken(token)).First(&record).Error; err != nil {
+		return models.OneTimeToken{}, err
+	}
+	return record, nil
+}
@@ -0,0 +1,480 @@
+package handlers
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"zadatak-filip-janjesic/internal/models"   // Import models for user and passkey structs
+	"zadatak-filip-janjesic/internal/webauthn" // WebAuthn ceremonies
+
+	"github.com/go-playground/validator/v10" // Validator for input validation
+	"github.com/gofiber/fiber/v2"            // Fiber for HTTP handling
+	"gorm.io/gorm"                           // Database operations
+)
+
+// passkeyChallengeTTL is how long the browser has to complete a passkey ceremony.
+const passkeyChallengeTTL = 5 * time.Minute
+
+// Purposes of WebAuthn challenges; a challenge can only finish the ceremony it was issued for.
+const (
+	challengeRegistration = "registration"
+	challengeLogin        = "login"
+	challengeSecondFactor = "second_factor"
+)
+
+// Errors of passkey logins. Both are answered like a wrong password.
+var (
+	errInvalidPasskey = errors.New("invalid passkey assertion")
+	errClonedPasskey  = errors.New("passkey signature counter went backwards")
+)
+
+// passkeyResponse is the JSON representation of a registered passkey.
+type passkeyResponse struct {
+	ID           uint       `json:"id"`
+	Name         string     `json:"name"`
+	CredentialID string     `json:"credential_id"`
+	AAGUID       string     `json:"aaguid,omitempty"`
+	Transports   []string   `json:"transports,omitempty"`
+	BackedUp     bool       `json:"backed_up"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty"`
+}
+
+// newPasskeyResponse converts a stored passkey into its JSON representation.
+func newPasskeyResponse(passkey models.Passkey) passkeyResponse {
+	return passkeyResponse{
+		ID:           passkey.ID,
+		Name:         passkey.Name,
+		CredentialID: passkey.CredentialID,
+		AAGUID:       passkey.AAGUID,
+		Transports:   passkey.TransportList(),
+		BackedUp:     passkey.BackedUp,
+		CreatedAt:    passkey.CreatedAt,
+		LastUsedAt:   passkey.LastUsedAt,
+	}
+}
+
+// relyingParty returns this service as a WebAuthn relying party (WEBAUTHN_RP_ID, WEBAUTHN_RP_NAME,
+// WEBAUTHN_ORIGINS), by default scoped to the host of APP_BASE_URL.
+func relyingParty() webauthn.RelyingParty {
+	return webauthn.RelyingPartyFromEnv(appURL("", nil))
+}
+
+// passkeyUserHandle returns the user handle stored in a user's passkeys: the user ID as 8 big-endian bytes.
+func passkeyUserHandle(userID uint) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(userID))
+}
+
+// BeginPasskeyRegistration starts adding a passkey to the authenticated user's account and returns the
+// options for navigator.credentials.create(). The password is required, so that a stolen access token
+// alone cannot add a permanent way into the account.
+func BeginPasskeyRegistration(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		var request struct {
+			Password string `json:"password" validate:"required"`
+		}
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+		}
+		if err := validator.New().Struct(request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Validation failed: " + err.Error()})
+		}
+
+		user := principal.User
+		if wrong, err := verifyCurrentPassword(c, database, user, request.Password); wrong || err != nil {
+			return err
+		}
+
+		// Authenticators refuse to create a second credential for the account
+		var passkeys []models.Passkey
+		if err := database.Where("user_id = ?", user.ID).Find(&passkeys).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to start passkey registration"})
+		}
+		exclude := make([]webauthn.CredentialDescriptor, 0, len(passkeys))
+		for _, passkey := range passkeys {
+			exclude = append(exclude, webauthn.CredentialDescriptor{Type: "public-key", ID: passkey.CredentialID, Transports: passkey.TransportList()})
+		}
+
+		challenge, err := issueWebAuthnChallenge(database, user.ID, challengeRegistration)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to start passkey registration"})
+		}
+		entity := webauthn.UserEntity{
+			ID:          webauthn.EncodeBase64URL(passkeyUserHandle(user.ID)),
+			Name:        user.Username,
+			DisplayName: strings.TrimSpace(user.FirstName + " " + user.LastName),
+		}
+		if entity.DisplayName == "" {
+			entity.DisplayName = user.Username
+		}
+		return c.JSON(relyingParty().CreationOptions(challenge, entity, exclude, passkeyChallengeTTL))
+	}
+}
+
+// FinishPasskeyRegistration verifies the credential created by the authenticator and stores it as a passkey
+// of the authenticated user.
+func FinishPasskeyRegistration(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		var request struct {
+			Name       string                       `json:"name" validate:"required,max=100"` // e.g. "work laptop"
+			Credential webauthn.AttestationResponse `json:"credential"`                       // Result of navigator.credentials.create()
+		}
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+		}
+		if err := validator.New().Struct(request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Validation failed: " + err.Error()})
+		}
+
+		user := principal.User
+		challenge, err := consumeWebAuthnChallenge(database, request.Credential.Response.ClientDataJSON, user.ID, challengeRegistration)
+		if err != nil {
+			if errors.Is(err, errInvalidPasskey) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired passkey registration"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to register passkey"})
+		}
+		credential, err := relyingParty().VerifyRegistration(request.Credential, challenge, false)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid passkey: " + err.Error()})
+		}
+
+		passkey := models.Passkey{
+			UserID:       user.ID,
+			CredentialID: webauthn.EncodeBase64URL(credential.ID),
+			PublicKey:    credential.PublicKey,
+			Algorithm:    credential.Algorithm,
+			SignCount:    credential.SignCount,
+			Transports:   strings.Join(credential.Transports, " "),
+			Name:         request.Name,
+			BackedUp:     credential.BackedUp,
+		}
+		if strings.Trim(hex.EncodeToString(credential.AAGUID), "0") != "" {
+			passkey.AAGUID = hex.EncodeToString(credential.AAGUID)
+		}
+
+		var count int64
+		if err := database.Model(&models.Passkey{}).Where("credential_id = ?", passkey.CredentialID).Count(&count).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to register passkey"})
+		}
+		if count > 0 {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Passkey already registered"})
+		}
+
+		err = database.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&passkey).Error; err != nil {
+				return err
+			}
+			return models.RecordAudit(tx, principal.ActorID(), "user.passkey_add", &user.ID, c.IP(), map[string]interface{}{
+				"passkey_id": passkey.ID,
+				"name":       passkey.Name,
+			})
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to register passkey"})
+		}
+
+		if err := sendSecurityNotification(user.Email, user.Username, "A passkey was added to your account",
+			fmt.Sprintf("The passkey %q was added to your account and can now be used to log in.", passkey.Name)); err != nil {
+			log.Printf("Error sending passkey notification to user %d: %v", user.ID, err)
+		}
+		return c.Status(fiber.StatusCreated).JSON(newPasskeyResponse(passkey))
+	}
+}
+
+// ListPasskeys returns the passkeys of the authenticated user.
+func ListPasskeys(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		var passkeys []models.Passkey
+		if err := database.Where("user_id = ?", principal.UserID()).Order("created_at DESC").Find(&passkeys).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to list passkeys"})
+		}
+
+		response := make([]passkeyResponse, 0, len(passkeys))
+		for _, passkey := range passkeys {
+			response = append(response, newPasskeyResponse(passkey))
+		}
+		return c.JSON(response)
+	}
+}
+
+// DeletePasskey removes one of the authenticated user's passkeys, so that it can no longer be used to log in.
+func DeletePasskey(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		passkeyID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid passkey ID"})
+		}
+
+		user := principal.User
+		var passkey models.Passkey
+		if err := database.Where("id = ? AND user_id = ?", passkeyID, user.ID).First(&passkey).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Passkey not found"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to remove passkey"})
+		}
+
+		err = database.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Unscoped().Delete(&passkey).Error; err != nil {
+				return err
+			}
+			return models.RecordAudit(tx, principal.ActorID(), "user.passkey_remove", &user.ID, c.IP(), map[string]interface{}{
+				"passkey_id": passkey.ID,
+				"name":       passkey.Name,
+			})
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to remove passkey"})
+		}
+
+		if err := sendSecurityNotification(user.Email, user.Username, "A passkey was removed from your account",
+			fmt.Sprintf("The passkey %q was removed from your account and can no longer be used to log in.", passkey.Name)); err != nil {
+			log.Printf("Error sending passkey notification to user %d: %v", user.ID, err)
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// BeginPasskeyLogin starts a passwordless login and returns the options for navigator.credentials.get().
+// No username is needed: the authenticator offers the passkeys it holds for this site, and user verification
+// (a PIN or biometric check) is required. The assertion is then sent to POST /login/passkey/finish within
+// passkeyChallengeTTL; the challenge works only once.
+func BeginPasskeyLogin(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		challenge, err := issueWebAuthnChallenge(database, 0, challengeLogin)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to start passkey login"})
+		}
+		return c.JSON(relyingParty().RequestOptions(challenge, nil, webauthn.UserVerificationRequired, passkeyChallengeTTL))
+	}
+}
+
+// FinishPasskeyLogin completes a passwordless login with the assertion of a passkey and issues the same
+// token pair or browser session as Login. The authenticator verified the user with a PIN or biometric
+// check, so the passkey counts as both factors and no two-factor challenge follows.
+func FinishPasskeyLogin(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var request struct {
+			Credential  webauthn.AssertionResponse `json:"credential"`   // Result of navigator.credentials.get()
+			DeviceName  string                     `json:"device_name"`  // Optional name of the device, shown in the session list
+			SessionMode string                     `json:"session_mode"` // "token" (default) or "cookie" for a browser session
+		}
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+		}
+		if !isValidSessionMode(request.SessionMode) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "session_mode must be \"token\" or \"cookie\""})
+		}
+
+		// The challenge is spent even if the assertion turns out to be invalid
+		challenge, err := consumeWebAuthnChallenge(database, request.Credential.Response.ClientDataJSON, 0, challengeLogin)
+		if err != nil {
+			if errors.Is(err, errInvalidPasskey) {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired passkey login"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error checking passkey"})
+		}
+		credentialID, err := request.Credential.CredentialID()
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid passkey"})
+		}
+		var passkey models.Passkey
+		if err := database.Where("credential_id = ?", credentialID).First(&passkey).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid passkey"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error checking passkey"})
+		}
+		var user models.User
+		if err := database.First(&user, passkey.UserID).Error; err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "User not found"})
+		}
+
+		// Failed assertions count as failed logins, like wrong passwords
+		throttleKeys := loginThrottleKeys(user.Username, c.IP())
+		wait, err := checkLoginAllowed(database, throttleKeys, &user)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error checking login attempts"})
+		}
+		if wait > 0 {
+			return tooManyLoginAttempts(c, wait)
+		}
+		if err := verifyPasskeyAssertion(database, passkey, request.Credential, challenge, true); err != nil {
+			if errors.Is(err, errInvalidPasskey) || errors.Is(err, errClonedPasskey) {
+				if err := handleLoginFailure(database, throttleKeys, &user); err != nil {
+					return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error recording login attempt"})
+				}
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid passkey"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error checking passkey"})
+		}
+
+		if err := checkLoginAllowedState(user); err != nil {
+			return refuseLogin(c, err)
+		}
+
+		if err := models.ClearLoginThrottle(database, models.UsernameThrottleKey(user.Username)); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error recording login attempt"})
+		}
+		return respondWithLogin(c, database, user, newSessionInfo(c, request.DeviceName), request.SessionMode)
+	}
+}
+
+// BeginPasskeySecondFactor returns the options for navigator.credentials.get() to use one of the user's
+// passkeys, instead of a TOTP code, as the second factor of a login. The assertion is then sent as passkey
+// to POST /login/2fa together with the same mfa_token.
+func BeginPasskeySecondFactor(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var request struct {
+			MFAToken string `json:"mfa_token"`
+		}
+		if err := c.BodyParser(&request); err != nil || request.MFAToken == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "mfa_token required"})
+		}
+
+		claims, err := parseToken(request.MFAToken, purposeMFA)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired mfa_token"})
+		}
+		revoked, err := models.IsTokenRevoked(database, claims.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error checking token"})
+		}
+		if revoked {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired mfa_token"})
+		}
+
+		var passkeys []models.Passkey
+		if err := database.Where("user_id = ?", claims.UserID).Find(&passkeys).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to start passkey login"})
+		}
+		if len(passkeys) == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "No passkeys registered; use a two-factor code instead"})
+		}
+		allow := make([]webauthn.CredentialDescriptor, 0, len(passkeys))
+		for _, passkey := range passkeys {
+			allow = append(allow, webauthn.CredentialDescriptor{Type: "public-key", ID: passkey.CredentialID, Transports: passkey.TransportList()})
+		}
+
+		challenge, err := issueWebAuthnChallenge(database, claims.UserID, challengeSecondFactor)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to start passkey login"})
+		}
+		// The password was the first factor, so presence on the authenticator is enough
+		return c.JSON(relyingParty().RequestOptions(challenge, allow, webauthn.UserVerificationDiscouraged, passkeyChallengeTTL))
+	}
+}
+
+// verifyPasskeySecondFactor checks a passkey assertion given as the second factor of a user's login.
+func verifyPasskeySecondFactor(database *gorm.DB, user models.User, response webauthn.AssertionResponse) error {
+	challenge, err := consumeWebAuthnChallenge(database, response.Response.ClientDataJSON, user.ID, challengeSecondFactor)
+	if err != nil {
+		return err
+	}
+	credentialID, err := response.CredentialID()
+	if err != nil {
+		return errInvalidPasskey
+	}
+	var passkey models.Passkey
+	if err := database.Where("credential_id = ? AND user_id = ?", credentialID, user.ID).First(&passkey).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errInvalidPasskey
+		}
+		return err
+	}
+	return verifyPasskeyAssertion(database, passkey, response, challenge, false)
+}
+
+// verifyPasskeyAssertion checks an assertion made with a stored passkey and records its use. A signature
+// counter that does not increase means the credential was copied, and the assertion is refused.
+func verifyPasskeyAssertion(database *gorm.DB, passkey models.Passkey, response webauthn.AssertionResponse, challenge string, requireUserVerification bool) error {
+	assertion, err := relyingParty().VerifyAssertion(response, challenge, passkey.PublicKey, requireUserVerification)
+	if err != nil {
+		return errInvalidPasskey
+	}
+	if assertion.UserHandle != nil && string(assertion.UserHandle) != string(passkeyUserHandle(passkey.UserID)) {
+		return errInvalidPasskey
+	}
+	if !webauthn.SignCountValid(passkey.SignCount, assertion.SignCount) {
+		log.Printf("Passkey %d of user %d reported signature counter %d after %d; possible cloned authenticator",
+			passkey.ID, passkey.UserID, assertion.SignCount, passkey.SignCount)
+		return errClonedPasskey
+	}
+
+	// Store the counter only if no concurrent login stored another one in the meantime
+	result := database.Model(&models.Passkey{}).
+		Where("id = ? AND sign_count = ?", passkey.ID, passkey.SignCount).
+		Updates(map[string]interface{}{
+			"sign_count":   assertion.SignCount,
+			"backed_up":    assertion.BackedUp,
+			"last_used_at": time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errClonedPasskey
+	}
+	return nil
+}
+
+// issueWebAuthnChallenge creates a challenge for a ceremony of the user (0 when not known yet) and stores its hash.
+func issueWebAuthnChallenge(database *gorm.DB, userID uint, purpose string) (string, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return "", err
+	}
+	err = database.Create(&models.WebAuthnChallenge{
+		UserID:        userID,
+		Purpose:       purpose,
+		ChallengeHash: hashToken(challenge),
+		ExpiresAt:     time.Now().Add(passkeyChallengeTTL),
+	}).Error
+	return challenge, err
+}
+
+// consumeWebAuthnChallenge takes the challenge from the client data of a response and deletes it, so that
+// it can be answered only once. It returns errInvalidPasskey for unknown, expired and foreign challenges.
+func consumeWebAuthnChallenge(database *gorm.DB, clientDataJSON string, userID uint, purpose string) (string, error) {
+	clientData, err := webauthn.ParseClientData(clientDataJSON)
+	if err != nil || clientData.Challenge == "" {
+		return "", errInvalidPasskey
+	}
+	result := database.Unscoped().
+		Where("challenge_hash = ? AND user_id = ? AND purpose = ? AND expires_at > ?", hashToken(clientData.Challenge), userID, purpose, time.Now()).
+		Delete(&models.WebAuthnChallenge{})
+	if result.Error != nil {
+		return "", result.Error
+	}
+	if result.RowsAffected == 0 {
+		return "", errInvalidPasskey
+	}
+	return clientData.Challenge, nil
+}
@@ -0,0 +1,391 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"zadatak-filip-janjesic/internal/models"
+	"zadatak-filip-janjesic/internal/webauthn"
+	"zadatak-filip-janjesic/internal/webauthn/webauthntest"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// passkeyTestOrigin is the origin of the browser in the passkey tests; the relying party follows APP_BASE_URL.
+const passkeyTestOrigin = "http://localhost:8080"
+
+// passkeyTestPassword is the password of the users created by newPasskeyTestEnv.
+const passkeyTestPassword = "correct horse battery staple"
+
+// passkeyTestEnv is the API with the login and passkey routes.
+type passkeyTestEnv struct {
+	database *gorm.DB
+	app      *fiber.App
+	mailer   *testMailer
+}
+
+func newPasskeyTestEnv(t *testing.T) *passkeyTestEnv {
+	t.Helper()
+	t.Setenv("APP_BASE_URL", passkeyTestOrigin)
+	for _, key := range []string{"WEBAUTHN_RP_ID", "WEBAUTHN_RP_NAME", "WEBAUTHN_ORIGINS"} {
+		t.Setenv(key, "")
+	}
+	database := newTestDB(t)
+	useTestKeyring(t, database)
+	useTestPasswordHasher(t)
+
+	app := fiber.New()
+	app.Post("/login", Login(database))
+	app.Post("/login/2fa", CompleteTwoFactorLogin(database))
+	app.Post("/login/2fa/passkey", BeginPasskeySecondFactor(database))
+	app.Post("/login/passkey/begin", BeginPasskeyLogin(database))
+	app.Post("/login/passkey/finish", FinishPasskeyLogin(database))
+	app.Use("/me", AuthMiddleware(database))
+	app.Post("/me/passkeys/register/begin", BeginPasskeyRegistration(database))
+	app.Post("/me/passkeys/register/finish", FinishPasskeyRegistration(database))
+	app.Get("/me/passkeys", ListPasskeys(database))
+	return &passkeyTestEnv{database: database, app: app, mailer: useTestMailer(t)}
+}
+
+// createUser stores a user who logs in with passkeyTestPassword.
+func (env *passkeyTestEnv) createUser(t *testing.T, username string) models.User {
+	t.Helper()
+	user := createTestUser(t, env.database, username)
+	hashed, err := hashPassword(passkeyTestPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := env.database.Model(&user).Update("password", hashed).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// request sends a JSON request, authenticated with the access token if given, and decodes the response into out.
+func (env *passkeyTestEnv) request(t *testing.T, method, path, token string, body, out interface{}) int {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	request := httptest.NewRequest(method, path, reader)
+	request.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	response, err := env.app.Test(request, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != nil && len(raw) > 0 {
+		if err := json.Unmarshal(raw, out); err != nil {
+			t.Fatalf("%s %s: decoding %s: %v", method, path, raw, err)
+		}
+	}
+	return response.StatusCode
+}
+
+// accessToken logs the user in with the password and returns the access token.
+func (env *passkeyTestEnv) accessToken(t *testing.T, username string) string {
+	t.Helper()
+	var pair TokenPair
+	if status := env.request(t, http.MethodPost, "/login", "", fiber.Map{"username": username, "password": passkeyTestPassword}, &pair); status != fiber.StatusOK || pair.Token == "" {
+		t.Fatalf("login of %s: status %d", username, status)
+	}
+	return pair.Token
+}
+
+// registerPasskey adds a passkey of the authenticator to the account of the access token.
+func (env *passkeyTestEnv) registerPasskey(t *testing.T, token string, authenticator *webauthntest.Authenticator, name string) passkeyResponse {
+	t.Helper()
+	var options webauthn.CreationOptions
+	if status := env.request(t, http.MethodPost, "/me/passkeys/register/begin", token, fiber.Map{"password": passkeyTestPassword}, &options); status != fiber.StatusOK {
+		t.Fatalf("beginning registration: status %d", status)
+	}
+	credential, err := authenticator.Create(options)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	var passkey passkeyResponse
+	if status := env.request(t, http.MethodPost, "/me/passkeys/register/finish", token, fiber.Map{"name": name, "credential": credential}, &passkey); status != fiber.StatusCreated {
+		t.Fatalf("finishing registration: status %d", status)
+	}
+	return passkey
+}
+
+// passkeyLogin logs in without a password and returns the status and the user the tokens were issued to.
+func (env *passkeyTestEnv) passkeyLogin(t *testing.T, authenticator *webauthntest.Authenticator) (int, uint) {
+	t.Helper()
+	var options webauthn.RequestOptions
+	if status := env.request(t, http.MethodPost, "/login/passkey/begin", "", nil, &options); status != fiber.StatusOK {
+		t.Fatalf("beginning passkey login: status %d", status)
+	}
+	assertion, err := authenticator.Get(options)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	var pair TokenPair
+	status := env.request(t, http.MethodPost, "/login/passkey/finish", "", fiber.Map{"credential": assertion}, &pair)
+	if status != fiber.StatusOK {
+		return status, 0
+	}
+	claims, err := parseAccessToken(pair.Token)
+	if err != nil {
+		t.Fatalf("access token of the passkey login does not verify: %v", err)
+	}
+	return status, claims.UserID
+}
+
+func TestPasskeyRegistration(t *testing.T) {
+	env := newPasskeyTestEnv(t)
+	user := env.createUser(t, "john")
+	token := env.accessToken(t, "john")
+	authenticator := webauthntest.New(passkeyTestOrigin)
+
+	// The password is required to start a registration
+	if status := env.request(t, http.MethodPost, "/me/passkeys/register/begin", token, fiber.Map{"password": "wrong"}, nil); status != fiber.StatusForbidden {
+		t.Errorf("registration with a wrong password: status %d, want 403", status)
+	}
+
+	passkey := env.registerPasskey(t, token, authenticator, "Laptop")
+	if passkey.Name != "Laptop" || passkey.AAGUID == "" || passkey.CredentialID == "" {
+		t.Errorf("passkey = %+v", passkey)
+	}
+	var stored models.Passkey
+	if err := env.database.Where("user_id = ?", user.ID).First(&stored).Error; err != nil || stored.CredentialID != passkey.CredentialID {
+		t.Fatalf("stored passkey = %+v, %v", stored, err)
+	}
+	if sent := env.mailer.sent(); len(sent) != 1 || sent[0].To != user.Email {
+		t.Errorf("notifications = %+v, want one to %s", sent, user.Email)
+	}
+
+	// The next registration excludes the passkey, so the same authenticator refuses to register again
+	var options webauthn.CreationOptions
+	if status := env.request(t, http.MethodPost, "/me/passkeys/register/begin", token, fiber.Map{"password": passkeyTestPassword}, &options); status != fiber.StatusOK {
+		t.Fatalf("beginning registration: status %d", status)
+	}
+	if len(options.ExcludeCredentials) != 1 || options.ExcludeCredentials[0].ID != passkey.CredentialID {
+		t.Errorf("excluded credentials = %+v, want the registered passkey", options.ExcludeCredentials)
+	}
+	if _, err := authenticator.Create(options); err == nil {
+		t.Error("the authenticator registered a second credential for the account")
+	}
+
+	// A registration answers its challenge only once
+	second, err := webauthntest.New(passkeyTestOrigin).Create(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := env.request(t, http.MethodPost, "/me/passkeys/register/finish", token, fiber.Map{"name": "Phone", "credential": second}, nil); status != fiber.StatusCreated {
+		t.Fatalf("finishing registration: status %d", status)
+	}
+	if status := env.request(t, http.MethodPost, "/me/passkeys/register/finish", token, fiber.Map{"name": "Phone", "credential": second}, nil); status != fiber.StatusBadRequest {
+		t.Errorf("replayed registration: status %d, want 400", status)
+	}
+
+	var listed []passkeyResponse
+	if status := env.request(t, http.MethodGet, "/me/passkeys", token, nil, &listed); status != fiber.StatusOK || len(listed) != 2 {
+		t.Errorf("listing passkeys: status %d, %d passkeys; want 2", status, len(listed))
+	}
+}
+
+func TestPasswordlessPasskeyLogin(t *testing.T) {
+	env := newPasskeyTestEnv(t)
+	john := env.createUser(t, "john")
+	jane := env.createUser(t, "jane")
+	johnsKey := webauthntest.New(passkeyTestOrigin)
+	janesKey := webauthntest.New(passkeyTestOrigin)
+	env.registerPasskey(t, env.accessToken(t, "john"), johnsKey, "Laptop")
+	env.registerPasskey(t, env.accessToken(t, "jane"), janesKey, "Phone")
+
+	// An authenticator with a credential this service never registered
+	unregistered := webauthntest.New(passkeyTestOrigin)
+	if _, err := unregistered.Create(relyingParty().CreationOptions("challenge", webauthn.UserEntity{ID: webauthn.EncodeBase64URL(passkeyUserHandle(john.ID))}, nil, time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	// An authenticator that does not verify the user, although passwordless logins require it
+	unverified := webauthntest.New(passkeyTestOrigin)
+	env.registerPasskey(t, env.accessToken(t, "john"), unverified, "Key")
+	unverified.UserVerified = false
+
+	tests := []struct {
+		name          string
+		authenticator *webauthntest.Authenticator
+		wantStatus    int
+		wantUser      uint
+	}{
+		{"john's passkey", johnsKey, fiber.StatusOK, john.ID},
+		{"jane's passkey", janesKey, fiber.StatusOK, jane.ID},
+		{"unregistered passkey", unregistered, fiber.StatusUnauthorized, 0},
+		{"user not verified", unverified, fiber.StatusUnauthorized, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, userID := env.passkeyLogin(t, test.authenticator)
+			if status != test.wantStatus || userID != test.wantUser {
+				t.Errorf("passkey login: status %d as user %d, want %d as user %d", status, userID, test.wantStatus, test.wantUser)
+			}
+		})
+	}
+
+	// The options of a passwordless login require user verification and name no credentials
+	var options webauthn.RequestOptions
+	env.request(t, http.MethodPost, "/login/passkey/begin", "", nil, &options)
+	if options.UserVerification != webauthn.UserVerificationRequired || len(options.AllowCredentials) != 0 || options.RPID != "localhost" {
+		t.Errorf("login options = %+v", options)
+	}
+
+	// Each login challenge can be answered only once
+	assertion, err := johnsKey.Get(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := env.request(t, http.MethodPost, "/login/passkey/finish", "", fiber.Map{"credential": assertion}, nil); status != fiber.StatusOK {
+		t.Fatalf("passkey login: status %d", status)
+	}
+	if status := env.request(t, http.MethodPost, "/login/passkey/finish", "", fiber.Map{"credential": assertion}, nil); status != fiber.StatusUnauthorized {
+		t.Errorf("replayed passkey login: status %d, want 401", status)
+	}
+}
+
+func TestPasskeyAsSecondFactor(t *testing.T) {
+	env := newPasskeyTestEnv(t)
+	john := env.createUser(t, "john")
+	env.createUser(t, "jane")
+	johnsKey := webauthntest.New(passkeyTestOrigin)
+	janesKey := webauthntest.New(passkeyTestOrigin)
+	env.registerPasskey(t, env.accessToken(t, "john"), johnsKey, "Laptop")
+	env.registerPasskey(t, env.accessToken(t, "jane"), janesKey, "Phone")
+	if err := env.database.Model(&john).Updates(map[string]interface{}{"totp_secret": "GEZDGNBVGY3TQOJQ", "totp_enabled_at": time.Now()}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// mfaChallenge logs john in with the password and returns the challenge token
+	mfaChallenge := func(t *testing.T) string {
+		t.Helper()
+		var challenge struct {
+			MFARequired bool   `json:"mfa_required"`
+			MFAToken    string `json:"mfa_token"`
+		}
+		if status := env.request(t, http.MethodPost, "/login", "", fiber.Map{"username": "john", "password": passkeyTestPassword}, &challenge); status != fiber.StatusOK || !challenge.MFARequired {
+			t.Fatalf("login: status %d, %+v", status, challenge)
+		}
+		return challenge.MFAToken
+	}
+	// passkeyOptions returns the options for answering the challenge token with a passkey
+	passkeyOptions := func(t *testing.T, mfaToken string) webauthn.RequestOptions {
+		t.Helper()
+		var options webauthn.RequestOptions
+		if status := env.request(t, http.MethodPost, "/login/2fa/passkey", "", fiber.Map{"mfa_token": mfaToken}, &options); status != fiber.StatusOK {
+			t.Fatalf("beginning passkey second factor: status %d", status)
+		}
+		return options
+	}
+
+	mfaToken := mfaChallenge(t)
+	options := passkeyOptions(t, mfaToken)
+	if len(options.AllowCredentials) != 1 || options.UserVerification != webauthn.UserVerificationDiscouraged {
+		t.Errorf("second factor options = %+v, want john's passkey without user verification", options)
+	}
+
+	// A user who is merely present on john's authenticator completes the login
+	johnsKey.UserVerified = false
+	assertion, err := johnsKey.Get(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pair TokenPair
+	if status := env.request(t, http.MethodPost, "/login/2fa", "", fiber.Map{"mfa_token": mfaToken, "passkey": assertion}, &pair); status != fiber.StatusOK {
+		t.Fatalf("second factor: status %d", status)
+	}
+	if claims, err := parseAccessToken(pair.Token); err != nil || claims.UserID != john.ID {
+		t.Errorf("access token = %+v, %v; want one for john", claims, err)
+	}
+
+	// The challenge token is spent
+	if status := env.request(t, http.MethodPost, "/login/2fa", "", fiber.Map{"mfa_token": mfaToken, "passkey": assertion}, nil); status != fiber.StatusUnauthorized {
+		t.Errorf("replayed second factor: status %d, want 401", status)
+	}
+
+	// Another user's passkey is no second factor for john, even for a challenge issued to john
+	mfaToken = mfaChallenge(t)
+	options = passkeyOptions(t, mfaToken)
+	options.AllowCredentials = nil
+	assertion, err = janesKey.Get(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := env.request(t, http.MethodPost, "/login/2fa", "", fiber.Map{"mfa_token": mfaToken, "passkey": assertion}, nil); status != fiber.StatusUnauthorized {
+		t.Errorf("second factor with jane's passkey: status %d, want 401", status)
+	}
+
+	// A challenge of a passwordless login cannot be used as a second factor
+	var loginOptions webauthn.RequestOptions
+	env.request(t, http.MethodPost, "/login/passkey/begin", "", nil, &loginOptions)
+	assertion, err = johnsKey.Get(loginOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := env.request(t, http.MethodPost, "/login/2fa", "", fiber.Map{"mfa_token": mfaToken, "passkey": assertion}, nil); status != fiber.StatusUnauthorized {
+		t.Errorf("second factor with a login challenge: status %d, want 401", status)
+	}
+}
+
+func TestPasskeyCloneDetection(t *testing.T) {
+	env := newPasskeyTestEnv(t)
+	john := env.createUser(t, "john")
+	authenticator := webauthntest.New(passkeyTestOrigin)
+	env.registerPasskey(t, env.accessToken(t, "john"), authenticator, "Laptop")
+
+	tests := []struct {
+		name         string
+		signCount    uint32 // Counter of the authenticator before the login; it reports one more
+		wantStatus   int
+		wantStored   uint32 // Counter stored after the login
+		wantFailures int    // Failed logins recorded for the user; refused assertions count, a login clears them
+	}{
+		{"first login", 0, fiber.StatusOK, 1, 0},
+		{"counter grows", 4, fiber.StatusOK, 5, 0},
+		{"clone reports a counter already seen", 4, fiber.StatusUnauthorized, 5, 1},
+		{"clone reports an older counter", 1, fiber.StatusUnauthorized, 5, 2},
+		{"original keeps working", 5, fiber.StatusOK, 6, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			authenticator.SetSignCount(test.signCount)
+			status, userID := env.passkeyLogin(t, authenticator)
+			if status != test.wantStatus {
+				t.Fatalf("passkey login: status %d, want %d", status, test.wantStatus)
+			}
+			if status == fiber.StatusOK && userID != john.ID {
+				t.Errorf("logged in as user %d, want %d", userID, john.ID)
+			}
+			var stored models.Passkey
+			if err := env.database.Where("user_id = ?", john.ID).First(&stored).Error; err != nil {
+				t.Fatal(err)
+			}
+			if stored.SignCount != test.wantStored {
+				t.Errorf("stored sign count %d, want %d", stored.SignCount, test.wantStored)
+			}
+			var throttle models.LoginThrottle
+			env.database.Where("throttle_key = ?", models.UsernameThrottleKey("john")).Limit(1).Find(&throttle)
+			if throttle.Failures != test.wantFailures {
+				t.Errorf("%d failed logins recorded, want %d", throttle.Failures, test.wantFailures)
+			}
+		})
+	}
+}
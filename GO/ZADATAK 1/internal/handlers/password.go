@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"zadatak-filip-janjesic/internal/mail"   // Mailer used for the reset email
+	"zadatak-filip-janjesic/internal/models" // Import models for user and token structs
+
+	"github.com/go-playground/validator/v10" // Validator for input validation
+	"github.com/gofiber/fiber/v2"            // Fiber for HTTP handling
+	"gorm.io/gorm"                           // Database operations
+)
+
+// Defaults for password reset emails, overridden by PASSWORD_RESET_TTL, PASSWORD_RESET_LIMIT and
+// PASSWORD_RESET_WINDOW.
+const (
+	defaultPasswordResetTTL    = time.Hour // How long a password reset link stays valid
+	defaultPasswordResetLimit  = 3         // Password reset emails sent to one address per window
+	defaultPasswordResetWindow = time.Hour // Window of the per-address limit
+)
+
+// ForgotPassword emails a password reset link to the user with the given address. The response is
+// the same whether or not the address is registered, so the endpoint cannot be used to find accounts.
+func ForgotPassword(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var request struct {
+			Email string `json:"email" validate:"required,email"`
+		}
+
+		// Parse and validate the JSON request body
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+		}
+		if err := validator.New().Struct(request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Validation failed: " + err.Error()})
+		}
+
+		accepted := func() error {
+			return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+				"message": "If the address is registered, a password reset link has been sent",
+			})
+		}
+
+		// Look up the user; an unknown address gets the same response
+		var user models.User
+		if err := database.Where("email = ?", request.Email).First(&user).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return accepted()
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error retrieving user data"})
+		}
+
+		// Limit the emails sent to one address, so the endpoint cannot be used to flood a mailbox
+		window := envDuration("PASSWORD_RESET_WINDOW", defaultPasswordResetWindow)
+		var recent int64
+		if err := database.Model(&models.OneTimeToken{}).
+			Where("user_id = ? AND purpose = ? AND created_at > ?", user.ID, models.PurposePasswordReset, time.Now().UTC().Add(-window)).
+			Count(&recent).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to send password reset email"})
+		}
+		if recent >= int64(envInt("PASSWORD_RESET_LIMIT", defaultPasswordResetLimit)) {
+			log.Printf("Password reset email limit reached for user %d, request from %s dropped", user.ID, c.IP())
+			return accepted()
+		}
+
+		// A failure to send gets the same response as well; an error here would tell that the address is registered
+		if err := sendPasswordResetEmail(database, user); err != nil {
+			log.Printf("Error sending password reset email to user %d: %v", user.ID, err)
+		}
+		return accepted()
+	}
+}
+
+// ResetPasswordPage is opened by the link in the password reset email. It asks for the new password and
+// posts it, with the token, to ResetPassword.
+func ResetPasswordPage(c *fiber.Ctx) error {
+	return showLinkPage(c, linkPageContent{
+		Title:    "Reset your password",
+		Text:     "Choose a new password for your account. You will be logged out everywhere.",
+		Button:   "Reset password",
+		Password: true,
+	})
+}
+
+// ResetPassword sets a new password using a reset token and invalidates all existing sessions of the user.
+func ResetPassword(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var request struct {
+			Token    string `json:"token" form:"token" validate:"required"`
+			Password string `json:"password" form:"password" validate:"required"`
+		}
+
+		// Parse and validate the JSON request body, or the form of ResetPasswordPage
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+		}
+		if err := validator.New().Struct(request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Validation failed: " + err.Error()})
+		}
+
+		// Check the new password against the password policy
+		if err := passwordPolicy.Check(request.Password); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		// Hash the new password before redeeming the token, so a hashing error does not burn it
+		hashedPassword, err := hashPassword(request.Password)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error while hashing password"})
+		}
+
+		err = database.Transaction(func(tx *gorm.DB) error {
+			record, err := consumeOneTimeToken(tx, request.Token, models.PurposePasswordReset)
+			if err != nil {
+				return err
+			}
+
+			// Store the new password, lift a forced reset and log the user out everywhere
+			if err := tx.Model(&models.User{}).Where("id = ?", record.UserID).Updates(map[string]interface{}{
+				"password":            hashedPassword,
+				"must_reset_password": false,
+			}).Error; err != nil {
+				return err
+			}
+			return revokeAllUserTokens(tx, record.UserID)
+		})
+		if err != nil {
+			if errors.Is(err, errInvalidOneTimeToken) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired reset token"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to reset password"})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Password has been reset; please log in again"})
+	}
+}
+
+// sendPasswordResetEmail issues a reset token for the user and emails the reset link.
+func sendPasswordResetEmail(database *gorm.DB, user models.User) error {
+	ttl := envDuration("PASSWORD_RESET_TTL", defaultPasswordResetTTL)
+	token, err := issueOneTimeToken(database, user.ID, models.PurposePasswordReset, ttl)
+	if err != nil {
+		return err
+	}
+
+	link := appURL("/password/reset", url.Values{"token": {token}})
+	return mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"Someone asked to reset the password of your account. If it was you, open the link below\n"+
+			"within %s and choose a new password:\n\n%s\n\n"+
+			"Or send this token to POST /password/reset: %s\n\n"+
+			"If you did not ask for this, you can ignore this email.\n", user.Username, ttl, link, token),
+	})
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+
+	"zadatak-filip-janjesic/internal/models"   // Import models for user struct
+	"zadatak-filip-janjesic/internal/password" // Password hashing and policy
+
+	"gorm.io/gorm" // Database operations
+)
+
+// Hasher and policy for user passwords; set from main with SetPasswordHasher and SetPasswordPolicy
+var (
+	passwordHasher password.PasswordHasher
+	passwordPolicy *password.Policy
+)
+
+// SetPasswordHasher sets the hasher used to hash and verify passwords.
+func SetPasswordHasher(h password.PasswordHasher) {
+	passwordHasher = h
+}
+
+// SetPasswordPolicy sets the policy new passwords have to satisfy.
+func SetPasswordPolicy(p *password.Policy) {
+	passwordPolicy = p
+}
+
+// hashPassword hashes a password with the configured hasher
+func hashPassword(plain string) (string, error) {
+	hashedPassword, err := passwordHasher.Hash(plain)
+	if err != nil {
+		return "", fmt.Errorf("error hashing password: %w", err)
+	}
+	return hashedPassword, nil
+}
+
+// checkPassword reports whether the password matches the user's stored hash. When the hash was made
+// with an outdated algorithm or parameters, it is replaced by a fresh hash of the now known password.
+func checkPassword(database *gorm.DB, user models.User, plain string) (bool, error) {
+	valid, err := passwordHasher.Verify(plain, user.Password)
+	if err != nil || !valid {
+		return false, err
+	}
+
+	if passwordHasher.NeedsRehash(user.Password) {
+		// A failed rehash is not a failed login; the next login tries again
+		rehashed, err := hashPassword(plain)
+		if err == nil {
+			// Only replace the hash that was verified, in case the password was changed meanwhile
+			err = database.Model(&models.User{}).
+				Where("id = ? AND password = ?", user.ID, user.Password).
+				Update("password", rehashed).Error
+		}
+		if err != nil {
+			log.Printf("Error rehashing password of user %d: %v", user.ID, err)
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"zadatak-filip-janjesic/internal/models" // Import models for the personal access token struct
+
+	"github.com/go-playground/validator/v10" // Validator for input validation
+	"github.com/gofiber/fiber/v2"            // Fiber for HTTP handling
+	"gorm.io/gorm"                           // Database operations
+)
+
+// personalAccessTokenPrefix starts every personal access token, so that they are easy to recognise
+// (for example by secret scanners) and can be told apart from JWTs.
+const personalAccessTokenPrefix = "npat_"
+
+// personalAccessTokenDisplayLength is the number of leading characters kept for display.
+const personalAccessTokenDisplayLength = len(personalAccessTokenPrefix) + 6
+
+// lastUsedUpdateInterval throttles writes of the last-used timestamps of busy tokens and sessions.
+const lastUsedUpdateInterval = time.Minute
+
+// errInvalidPersonalAccessToken is returned for unknown, revoked and expired personal access tokens.
+var errInvalidPersonalAccessToken = errors.New("invalid personal access token")
+
+// personalAccessTokenResponse is the JSON representation of a personal access token.
+type personalAccessTokenResponse struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `json:"last_used_ip,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	Token      string     `json:"token,omitempty"` // Only set in the response to the creation request
+}
+
+// newPersonalAccessTokenResponse converts a stored token into its JSON representation.
+func newPersonalAccessTokenResponse(token models.PersonalAccessToken) personalAccessTokenResponse {
+	return personalAccessTokenResponse{
+		ID:         token.ID,
+		Name:       token.Name,
+		Prefix:     token.Prefix,
+		Scopes:     token.ScopeList(),
+		CreatedAt:  token.CreatedAt,
+		ExpiresAt:  token.ExpiresAt,
+		LastUsedAt: token.LastUsedAt,
+		LastUsedIP: token.LastUsedIP,
+		RevokedAt:  token.RevokedAt,
+	}
+}
+
+// ListPersonalAccessTokens returns the personal access tokens of the authenticated user.
+func ListPersonalAccessTokens(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		var tokens []models.PersonalAccessToken
+		if err := database.Where("user_id = ?", principal.UserID()).Order("created_at DESC").Find(&tokens).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to list tokens"})
+		}
+
+		response := make([]personalAccessTokenResponse, 0, len(tokens))
+		for _, token := range tokens {
+			response = append(response, newPersonalAccessTokenResponse(token))
+		}
+		return c.JSON(response)
+	}
+}
+
+// CreatePersonalAccessToken creates a personal access token. The token value is returned only in this response.
+func CreatePersonalAccessToken(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		var request struct {
+			Name      string     `json:"name" validate:"required,max=100"`
+			Scopes    []string   `json:"scopes" validate:"required,min=1"`
+			ExpiresAt *time.Time `json:"expires_at"` // Optional, RFC 3339
+		}
+
+		// Parse and validate the JSON request body
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+		}
+		if err := validator.New().Struct(request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Validation failed: " + err.Error()})
+		}
+		for _, scope := range request.Scopes {
+			if !isGrantableScope(scope) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error":            "Unknown scope: " + scope,
+					"available_scopes": grantableScopes,
+				})
+			}
+		}
+		if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "expires_at must be in the future"})
+		}
+
+		secret, err := newOpaqueToken()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error generating token"})
+		}
+		value := personalAccessTokenPrefix + secret
+
+		token := models.PersonalAccessToken{
+			UserID:    principal.UserID(),
+			Name:      request.Name,
+			Prefix:    value[:personalAccessTokenDisplayLength],
+			TokenHash: hashToken(value),
+			Scopes:    strings.Join(request.Scopes, " "),
+			ExpiresAt: request.ExpiresAt,
+		}
+		if err := database.Create(&token).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to create token"})
+		}
+
+		response := newPersonalAccessTokenResponse(token)
+		response.Token = value
+		return c.Status(fiber.StatusCreated).JSON(response)
+	}
+}
+
+// RevokePersonalAccessToken revokes one of the authenticated user's personal access tokens.
+func RevokePersonalAccessToken(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		tokenID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid token ID"})
+		}
+
+		result := database.Model(&models.PersonalAccessToken{}).
+			Where("id = ? AND user_id = ? AND revoked_at IS NULL", tokenID, principal.UserID()).
+			Update("revoked_at", time.Now())
+		if result.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to revoke token"})
+		}
+		if result.RowsAffected == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Token not found"})
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// isPersonalAccessToken reports whether a bearer token is a personal access token rather than a JWT.
+func isPersonalAccessToken(value string) bool {
+	return strings.HasPrefix(value, personalAccessTokenPrefix)
+}
+
+// authenticatePersonalAccessToken looks up a personal access token, records its use and returns the principal.
+func authenticatePersonalAccessToken(database *gorm.DB, value, clientIP string) (*Principal, error) {
+	var token models.PersonalAccessToken
+	if err := database.Where("token_hash = ? AND revoked_at IS NULL", hashToken(value)).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errInvalidPersonalAccessToken
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	if token.ExpiresAt != nil && now.After(*token.ExpiresAt) {
+		return nil, errInvalidPersonalAccessToken
+	}
+
+	var user models.User
+	if err := database.First(&user, token.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errInvalidPersonalAccessToken
+		}
+		return nil, err
+	}
+
+	// Record the use, at most once per interval unless the IP address changed
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > lastUsedUpdateInterval || token.LastUsedIP != clientIP {
+		if err := database.Model(&token).Updates(map[string]interface{}{"last_used_at": now, "last_used_ip": clientIP}).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	// A non-nil scope list keeps the principal restricted even if no scopes were stored
+	scopes := token.ScopeList()
+	if scopes == nil {
+		scopes = []string{}
+	}
+
+	principal := &Principal{
+		User:                  user,
+		IssuedAt:              token.CreatedAt,
+		Scopes:                scopes,
+		PersonalAccessTokenID: token.ID,
+	}
+	if token.ExpiresAt != nil {
+		principal.ExpiresAt = *token.ExpiresAt
+	}
+	return principal, nil
+}
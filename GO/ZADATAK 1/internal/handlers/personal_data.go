@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"zadatak-filip-janjesic/internal/models" // Import models for the user's stored data
+
+	"github.com/go-playground/validator/v10" // Validator for input validation
+	"github.com/gofiber/fiber/v2"            // Fiber for HTTP handling
+	"gorm.io/gorm"                           // Database operations
+)
+
+// defaultAccountDeletionGrace is how long the deletion of an account can still be cancelled unless ACCOUNT_DELETION_GRACE overrides it.
+const defaultAccountDeletionGrace = 30 * 24 * time.Hour
+
+// cacheExport is the representation of a cache entry in the personal-data export.
+type cacheExport struct {
+	ID         uint            `json:"id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+	PageKey    string          `json:"page_key"`
+	ExpiryDate time.Time       `json:"expiry_date"`
+	Notes      json.RawMessage `json:"notes"`
+}
+
+// noteExport is the representation of a note in the personal-data export; deleted_at is set for notes in the trash.
+type noteExport struct {
+	models.Note
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// ExportMe returns a zip archive with everything stored about the authenticated user: the profile, all
+// notes including deleted ones, cached notes, sessions, linked single sign-on accounts, passkeys and the
+// audit trail entries about the account. Each part is a JSON file.
+func ExportMe(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+		userID := principal.UserID()
+
+		var user models.User
+		var notes []models.Note
+		var caches []models.Cache
+		var sessions []models.Session
+		var identities []models.ExternalIdentity
+		var passkeys []models.Passkey
+		var notebooks []models.Notebook
+		var auditLogs []models.AuditLog
+		for _, query := range []*gorm.DB{
+			database.First(&user, userID),
+			database.Unscoped().Where("user_id = ?", userID).Order("id").Preload("Tags", orderTagsByName).Find(&notes),
+			database.Unscoped().Where("user_id = ?", userID).Order("id").Find(&caches),
+			database.Where("user_id = ?", userID).Order("id").Find(&sessions),
+			database.Where("user_id = ?", userID).Order("id").Find(&identities),
+			database.Where("user_id = ?", userID).Order("id").Find(&passkeys),
+			database.Where("user_id = ?", userID).Order("id").Find(&notebooks),
+			database.Where("actor_id = ? OR target_user_id = ?", userID, userID).Order("id").Find(&auditLogs),
+		} {
+			if query.Error != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to export data"})
+			}
+		}
+
+		cacheEntries := make([]cacheExport, 0, len(caches))
+		for _, cache := range caches {
+			entry := cacheExport{ID: cache.ID, CreatedAt: cache.CreatedAt, UpdatedAt: cache.UpdatedAt, PageKey: cache.PageKey, ExpiryDate: cache.ExpiryDate}
+			if json.Valid([]byte(cache.Notes)) {
+				entry.Notes = json.RawMessage(cache.Notes)
+			} else {
+				entry.Notes, _ = json.Marshal(cache.Notes)
+			}
+			cacheEntries = append(cacheEntries, entry)
+		}
+
+		noteEntries := make([]noteExport, 0, len(notes))
+		for _, note := range notes {
+			entry := noteExport{Note: note}
+			if note.DeletedAt.Valid {
+				entry.DeletedAt = &note.DeletedAt.Time
+			}
+			noteEntries = append(noteEntries, entry)
+		}
+
+		archive, err := zipJSONFiles([]exportFile{
+			{"profile.json", newProfileResponse(user)},
+			{"notes.json", noteEntries},
+			{"notebooks.json", notebooks},
+			{"cache.json", cacheEntries},
+			{"sessions.json", sessions},
+			{"linked_accounts.json", identities},
+			{"passkeys.json", passkeys},
+			{"audit_log.json", auditLogs},
+		})
+		if err != nil {
+			log.Printf("Error exporting data of user %d: %v", userID, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to export data"})
+		}
+
+		if err := models.RecordAudit(database, principal.ActorID(), "user.export", &userID, c.IP(), nil); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to export data"})
+		}
+
+		c.Set(fiber.HeaderContentType, "application/zip")
+		c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="export-%s-%s.zip"`, user.Username, time.Now().Format(models.DateLayout)))
+		return c.Send(archive)
+	}
+}
+
+// exportFile is one JSON file of the personal-data export.
+type exportFile struct {
+	Name    string
+	Content interface{}
+}
+
+// zipJSONFiles writes the files as indented JSON into a zip archive.
+func zipJSONFiles(files []exportFile) ([]byte, error) {
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+	now := time.Now()
+	for _, file := range files {
+		data, err := json.MarshalIndent(file.Content, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		writer, err := archive.CreateHeader(&zip.FileHeader{Name: file.Name, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// DeleteMe schedules the deletion of the authenticated user's account, who must confirm the password.
+// The account keeps working during the grace period (ACCOUNT_DELETION_GRACE), so that the user can log in
+// and cancel; afterwards the account and all its data are permanently deleted.
+func DeleteMe(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		var request struct {
+			Password string `json:"password" validate:"required"`
+		}
+
+		// Parse and validate the JSON request body
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+		}
+		if err := validator.New().Struct(request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Validation failed: " + err.Error()})
+		}
+
+		user := principal.User
+		if user.DeletionDueAt != nil {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error":           "The account is already scheduled for deletion",
+				"deletion_due_at": user.DeletionDueAt,
+			})
+		}
+		if wrong, err := verifyCurrentPassword(c, database, user, request.Password); wrong || err != nil {
+			return err
+		}
+
+		dueAt := time.Now().Add(envDuration("ACCOUNT_DELETION_GRACE", defaultAccountDeletionGrace))
+		err := database.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Update("deletion_due_at", dueAt).Error; err != nil {
+				return err
+			}
+			return models.RecordAudit(tx, principal.ActorID(), "user.delete_request", &user.ID, c.IP(), map[string]interface{}{
+				"deletion_due_at": dueAt,
+			})
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to schedule account deletion"})
+		}
+
+		if err := sendSecurityNotification(user.Email, user.Username, "Your account will be deleted",
+			fmt.Sprintf("Your account and all your notes will be permanently deleted on %s.\n"+
+				"To keep your account, log in and send POST /me/deletion/cancel before then.",
+				dueAt.UTC().Format(time.RFC1123))); err != nil {
+			log.Printf("Error sending account deletion notification to user %d: %v", user.ID, err)
+		}
+
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+			"message":         "The account will be deleted at the end of the grace period; send POST /me/deletion/cancel to keep it",
+			"deletion_due_at": dueAt,
+		})
+	}
+}
+
+// CancelAccountDeletion cancels the scheduled deletion of the authenticated user's account.
+func CancelAccountDeletion(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		user := principal.User
+		if user.DeletionDueAt == nil {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "The account is not scheduled for deletion"})
+		}
+
+		err := database.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Update("deletion_due_at", nil).Error; err != nil {
+				return err
+			}
+			return models.RecordAudit(tx, principal.ActorID(), "user.delete_cancel", &user.ID, c.IP(), nil)
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to cancel account deletion"})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Account deletion cancelled"})
+	}
+}
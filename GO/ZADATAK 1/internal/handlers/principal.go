@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"zadatak-filip-janjesic/internal/keys"   // Keyring for verifying tokens
+	"zadatak-filip-janjesic/internal/models" // Import models for user struct
+
+	"github.com/gofiber/fiber/v2"  // Fiber framework for web server
+	"github.com/golang-jwt/jwt/v4" // JWT library for token handling
+)
+
+// principalKey is the key under which AuthMiddleware stores the Principal in the request context.
+const principalKey = "principal"
+
+// Token purposes. Access tokens carry no purpose; every other kind of token is rejected by AuthMiddleware.
+const (
+	purposeAccess = ""    // Access token for the API
+	purposeMFA    = "mfa" // Challenge token exchanged at /login/2fa together with a second factor
+)
+
+// Claims are the claims carried by the tokens this service issues.
+type Claims struct {
+	jwt.RegisteredClaims        // Standard claims: sub, jti, iat and exp
+	UserID               uint   `json:"user_id"`             // ID of the user the token was issued to
+	Purpose              string `json:"purpose,omitempty"`   // Empty for access tokens
+	SessionID            uint   `json:"sid,omitempty"`       // Login session of access tokens
+	Actor                *Actor `json:"act,omitempty"`       // Set on impersonation tokens (RFC 8693)
+	GrantID              uint   `json:"gid,omitempty"`       // OAuth authorization of tokens issued to third-party clients
+	ClientID             string `json:"client_id,omitempty"` // Third-party client the token was issued to
+	Scope                string `json:"scope,omitempty"`     // Space-separated scopes of tokens issued to third-party clients
+}
+
+// Actor identifies the administrator acting on behalf of the token's user.
+type Actor struct {
+	Subject string `json:"sub"` // ID of the administrator
+	UserID  uint   `json:"user_id"`
+}
+
+// Principal is the authenticated caller of a protected route.
+type Principal struct {
+	User                  models.User // The authenticated user, loaded from the database
+	TokenID               string      // jti of the access token used for the request; empty for personal access tokens
+	SessionID             uint        // Login session of the access token, if any
+	IssuedAt              time.Time   // When the token was issued
+	ExpiresAt             time.Time   // When the token expires; zero for personal access tokens without expiry
+	Scopes                []string    // Scopes granted to the token; nil means unrestricted (a login session)
+	PersonalAccessTokenID uint        // ID of the personal access token used for the request, if any
+	ImpersonatorID        uint        // ID of the administrator impersonating the user, if any
+	OAuthGrantID          uint        // ID of the OAuth authorization of a third-party client's token, if any
+	csrfToken             string      // CSRF token of the browser session; empty unless authenticated by session cookie
+}
+
+// UserID returns the ID of the authenticated user.
+func (p *Principal) UserID() uint {
+	return p.User.ID
+}
+
+// ActorID returns the ID of the user actually making the request: the administrator when impersonating.
+func (p *Principal) ActorID() uint {
+	if p.ImpersonatorID != 0 {
+		return p.ImpersonatorID
+	}
+	return p.User.ID
+}
+
+// IsBrowserSession reports whether the request was authenticated with a session cookie.
+func (p *Principal) IsBrowserSession() bool {
+	return p.csrfToken != ""
+}
+
+// HasScope reports whether the principal may act within the given scope.
+func (p *Principal) HasScope(scope string) bool {
+	if p.Scopes == nil {
+		return true // Login sessions are not restricted
+	}
+	for _, granted := range p.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// CurrentPrincipal returns the Principal attached to the request by AuthMiddleware.
+func CurrentPrincipal(c *fiber.Ctx) (*Principal, bool) {
+	principal, ok := c.Locals(principalKey).(*Principal)
+	return principal, ok && principal != nil
+}
+
+// newClaims builds the claims of a new token with the given purpose and lifetime for the user.
+func newClaims(user models.User, purpose, jti string, now time.Time, ttl time.Duration) Claims {
+	return Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			ID:        jti,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+		UserID:  user.ID,
+		Purpose: purpose,
+	}
+}
+
+// signToken signs a new token with the given purpose and lifetime for the user.
+func signToken(user models.User, purpose string, ttl time.Duration) (string, error) {
+	// Every token gets a unique ID (jti) so that it can be revoked individually
+	jti, err := newOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	return keyring.Sign(newClaims(user, purpose, jti, time.Now(), ttl))
+}
+
+// parseAccessToken verifies an access token and returns its claims.
+func parseAccessToken(tokenString string) (*Claims, error) {
+	return parseToken(tokenString, purposeAccess)
+}
+
+// parseToken verifies the signature and standard claims of a token and checks that it was issued
+// for the given purpose. Only the algorithms supported by the keyring are accepted.
+func parseToken(tokenString, purpose string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, keyring.Keyfunc, jwt.WithValidMethods(keys.Algorithms()))
+	if err != nil || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	// Our tokens always carry these claims; a token without them was not issued by us
+	if claims.ID == "" || claims.IssuedAt == nil || claims.ExpiresAt == nil || claims.UserID == 0 {
+		return nil, errors.New("invalid token claims")
+	}
+
+	// A token issued for one purpose can never be used for another
+	if claims.Purpose != purpose {
+		return nil, errors.New("invalid token purpose")
+	}
+	return claims, nil
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"zadatak-filip-janjesic/internal/models" // Import models for the user struct
+
+	"github.com/go-playground/validator/v10" // Validator for input validation
+	"github.com/gofiber/fiber/v2"            // Fiber for HTTP handling
+	"gorm.io/gorm"                           // Database operations
+)
+
+// phoneNumberPattern accepts international and local phone numbers: digits with an optional leading "+",
+// separated by spaces, dashes, dots or parentheses.
+var phoneNumberPattern = regexp.MustCompile(`^\+?[0-9][0-9 ().-]{4,18}[0-9]$`)
+
+// earliestDateOfBirth is the earliest date of birth accepted on a profile.
+var earliestDateOfBirth = time.Date(1900, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// profileResponse is the representation of the authenticated user's own account. It never contains
+// the password hash or other security columns.
+type profileResponse struct {
+	ID               uint        `json:"id"`
+	Username         string      `json:"username"`
+	Email            string      `json:"email"`
+	PendingEmail     string      `json:"pending_email,omitempty"`
+	FirstName        string      `json:"first_name"`
+	LastName         string      `json:"last_name"`
+	PhoneNumber      string      `json:"phone_number,omitempty"`
+	City             string      `json:"city,omitempty"`
+	Country          string      `json:"country,omitempty"`
+	DateOfBirth      models.Date `json:"dateOfBirth"`
+	Role             string      `json:"role"`
+	EmailVerifiedAt  *time.Time  `json:"email_verified_at,omitempty"`
+	TwoFactorEnabled bool        `json:"two_factor_enabled"`
+	DeletionDueAt    *time.Time  `json:"deletion_due_at,omitempty"` // Set while the account is scheduled for deletion
+	CreatedAt        time.Time   `json:"created_at"`
+	UpdatedAt        time.Time   `json:"updated_at"`
+}
+
+// newProfileResponse converts a user into the representation of their own profile.
+func newProfileResponse(user models.User) profileResponse {
+	return profileResponse{
+		ID:               user.ID,
+		Username:         user.Username,
+		Email:            user.Email,
+		PendingEmail:     user.PendingEmail,
+		FirstName:        user.FirstName,
+		LastName:         user.LastName,
+		PhoneNumber:      user.PhoneNumber,
+		City:             user.City,
+		Country:          user.Country,
+		DateOfBirth:      user.DateOfBirth,
+		Role:             user.Role,
+		EmailVerifiedAt:  user.EmailVerifiedAt,
+		TwoFactorEnabled: user.TOTPEnabledAt != nil,
+		DeletionDueAt:    user.DeletionDueAt,
+		CreatedAt:        user.CreatedAt,
+		UpdatedAt:        user.UpdatedAt,
+	}
+}
+
+// profileUpdate holds the fields of a PATCH /me request. Fields left out of the request are nil and
+// keep their value; optional fields are cleared with an empty string or null.
+type profileUpdate struct {
+	FirstName   *string      `json:"first_name" validate:"omitempty,min=1,max=100"`
+	LastName    *string      `json:"last_name" validate:"omitempty,min=1,max=100"`
+	PhoneNumber *string      `json:"phone_number" validate:"omitempty,max=20"`
+	City        *string      `json:"city" validate:"omitempty,max=100"`
+	Country     *string      `json:"country" validate:"omitempty,max=100"`
+	DateOfBirth *models.Date `json:"dateOfBirth" validate:"-"`
+}
+
+// profileUpdateFields are the JSON fields a user may change on their own profile.
+var profileUpdateFields = []string{"first_name", "last_name", "phone_number", "city", "country", "dateOfBirth"}
+
+// UpdateMe changes profile fields of the authenticated user. Only the fields present in the request are
+// changed; the username cannot be changed, and the password and email address only through their own
+// endpoints. It returns the updated profile.
+func UpdateMe(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		// Refuse fields that cannot be changed here instead of silently ignoring them
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(c.Body(), &fields); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+		}
+		var refused []string
+		for field := range fields {
+			if !containsAll(profileUpdateFields, []string{field}) {
+				refused = append(refused, field)
+			}
+		}
+		if len(refused) > 0 {
+			sort.Strings(refused)
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":          "These fields cannot be changed through PATCH /me: " + strings.Join(refused, ", "),
+				"allowed_fields": profileUpdateFields,
+			})
+		}
+
+		var update profileUpdate
+		if err := json.Unmarshal(c.Body(), &update); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input: " + err.Error()})
+		}
+		trimFields(update.FirstName, update.LastName, update.PhoneNumber, update.City, update.Country)
+		if err := validator.New().Struct(update); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Validation failed: " + err.Error()})
+		}
+
+		changes := map[string]interface{}{}
+		if update.FirstName != nil {
+			changes["first_name"] = *update.FirstName
+		}
+		if update.LastName != nil {
+			changes["last_name"] = *update.LastName
+		}
+		if update.PhoneNumber != nil {
+			if *update.PhoneNumber != "" && !phoneNumberPattern.MatchString(*update.PhoneNumber) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid phone number"})
+			}
+			changes["phone_number"] = *update.PhoneNumber
+		}
+		if update.City != nil {
+			changes["city"] = *update.City
+		}
+		if update.Country != nil {
+			changes["country"] = *update.Country
+		}
+		if _, present := fields["dateOfBirth"]; present {
+			// null, like an empty string, clears the date; the pointer is nil for both null and a missing field
+			date := models.Date{}
+			if update.DateOfBirth != nil {
+				date = *update.DateOfBirth
+			}
+			if !date.IsZero() && (date.After(time.Now()) || date.Before(earliestDateOfBirth)) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "dateOfBirth must be between 1900-01-01 and today"})
+			}
+			changes["date_of_birth"] = date
+		}
+
+		if len(changes) > 0 {
+			if err := database.Model(&models.User{}).Where("id = ?", principal.UserID()).Updates(changes).Error; err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to update profile"})
+			}
+		}
+
+		var user models.User
+		if err := database.First(&user, principal.UserID()).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to retrieve profile"})
+		}
+		return c.JSON(newProfileResponse(user))
+	}
+}
+
+// trimFields removes surrounding whitespace from the given optional strings.
+func trimFields(values ...*string) {
+	for _, value := range values {
+		if value != nil {
+			*value = strings.TrimSpace(*value)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+
+	"zadatak-filip-janjesic/internal/models" // Import models for the role and audit structs
+
+	"github.com/gofiber/fiber/v2" // Fiber for HTTP handling
+	"gorm.io/gorm"                // Database operations
+)
+
+// RequirePermission only lets the request through if the role of the authenticated user grants the permission.
+// Personal access tokens and impersonation sessions never act with a role's permissions.
+func RequirePermission(database *gorm.DB, permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+		if !principal.HasScope(ScopeAccount) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Permission denied"})
+		}
+
+		var role models.Role
+		if err := database.Where("name = ?", principal.User.Role).First(&role).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Permission denied"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error checking permissions"})
+		}
+		if !role.HasPermission(permission) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Permission denied: " + permission + " required"})
+		}
+
+		return c.Next()
+	}
+}
+
+// recordAudit appends an administrative action of the current principal to the audit trail.
+func recordAudit(database *gorm.DB, c *fiber.Ctx, action string, targetUserID *uint, details fiber.Map) error {
+	principal, ok := CurrentPrincipal(c)
+	if !ok {
+		return errors.New("no authenticated principal")
+	}
+	return models.RecordAudit(database, principal.ActorID(), action, targetUserID, c.IP(), details)
+}
+
+// ListAuditLog returns the audit trail, newest first, optionally filtered by actor, target user and action.
+func ListAuditLog(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		query := database.Model(&models.AuditLog{})
+		if actorID := c.Query("actor_id"); actorID != "" {
+			query = query.Where("actor_id = ?", actorID)
+		}
+		if targetUserID := c.Query("target_user_id"); targetUserID != "" {
+			query = query.Where("target_user_id = ?", targetUserID)
+		}
+		if action := c.Query("action"); action != "" {
+			query = query.Where("action = ?", action)
+		}
+
+		var total int64
+		if err := query.Count(&total).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to read audit log"})
+		}
+
+		limit, offset := pageParams(c)
+		var entries []models.AuditLog
+		if err := query.Order("id DESC").Limit(limit).Offset(offset).Find(&entries).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to read audit log"})
+		}
+
+		return c.JSON(fiber.Map{"entries": entries, "total": total})
+	}
+}
+
+// Page sizes of the admin listings.
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// pageParams reads the limit and offset query parameters of a listing.
+func pageParams(c *fiber.Ctx) (int, int) {
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	offset, err := strconv.Atoi(c.Query("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
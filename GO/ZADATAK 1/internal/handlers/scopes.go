@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2" // Fiber for HTTP handling
+)
+
+// Scopes that restrict what a token may do. Login sessions hold every scope; personal access
+// tokens only hold the scopes chosen when they were created.
+const (
+	ScopeNotesRead   = "notes:read"   // Read notes
+	ScopeNotesWrite  = "notes:write"  // Create, update and delete notes
+	ScopeProfileRead = "profile:read" // Read the profile on /me
+	ScopeAccount     = "account"      // Manage the account (tokens, 2FA, logout); never granted to personal access tokens
+)
+
+// grantableScopes are the scopes a personal access token or a third-party app may be granted.
+var grantableScopes = []string{ScopeNotesRead, ScopeNotesWrite, ScopeProfileRead}
+
+// RequireScope rejects requests whose principal does not hold the scope. It must run after AuthMiddleware.
+func RequireScope(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+		if !principal.HasScope(scope) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Token lacks the required scope: " + scope})
+		}
+		return c.Next()
+	}
+}
+
+// isGrantableScope reports whether a personal access token or a third-party app may be granted the scope.
+func isGrantableScope(scope string) bool {
+	for _, grantable := range grantableScopes {
+		if grantable == scope {
+			return true
+		}
+	}
+	return false
+}
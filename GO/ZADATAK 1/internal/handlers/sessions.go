@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"zadatak-filip-janjesic/internal/models" // Import models for the session struct
+
+	"github.com/gofiber/fiber/v2" // Fiber for HTTP handling
+	"gorm.io/gorm"                // Database operations
+)
+
+// maxDeviceNameLength limits the device name stored for a session.
+const maxDeviceNameLength = 100
+
+// sessionInfo describes the device a login comes from.
+type sessionInfo struct {
+	DeviceName string
+	UserAgent  string
+	IP         string
+}
+
+// sessionResponse is the JSON representation of a session.
+type sessionResponse struct {
+	ID         uint      `json:"id"`
+	DeviceName string    `json:"device_name"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	LastSeenIP string    `json:"last_seen_ip"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Browser    bool      `json:"browser"` // Whether the session is a browser session identified by a cookie
+	Current    bool      `json:"current"` // Whether the request was made with this session
+}
+
+// newSessionInfo describes the device of the request. Without a device name from the client,
+// a name such as "Firefox on Linux" is derived from the user agent.
+func newSessionInfo(c *fiber.Ctx, deviceName string) sessionInfo {
+	userAgent := c.Get(fiber.HeaderUserAgent)
+	deviceName = strings.TrimSpace(deviceName)
+	if deviceName == "" {
+		deviceName = deviceNameFromUserAgent(userAgent)
+	}
+	if len(deviceName) > maxDeviceNameLength {
+		deviceName = deviceName[:maxDeviceNameLength]
+	}
+	return sessionInfo{DeviceName: deviceName, UserAgent: userAgent, IP: c.IP()}
+}
+
+// deviceNameFromUserAgent derives a readable device name from a User-Agent header.
+func deviceNameFromUserAgent(userAgent string) string {
+	// Order matters: most browsers also claim to be the ones before them
+	browser := ""
+	for _, candidate := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(userAgent, candidate.token) {
+			browser = candidate.name
+			break
+		}
+	}
+
+	system := ""
+	for _, candidate := range []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, candidate.token) {
+			system = candidate.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	default:
+		return "Unknown device"
+	}
+}
+
+// touchSession reports whether the session is still active and records that it was seen, at most
+// once per interval unless the IP address changed.
+func touchSession(database *gorm.DB, sessionID uint, clientIP string) (bool, error) {
+	var session models.Session
+	if err := database.Where("id = ? AND revoked_at IS NULL", sessionID).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) > lastUsedUpdateInterval || session.LastSeenIP != clientIP {
+		if err := database.Model(&session).Updates(map[string]interface{}{"last_seen_at": now, "last_seen_ip": clientIP}).Error; err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// ListSessions returns the active sessions of the authenticated user, most recently seen first.
+func ListSessions(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		var sessions []models.Session
+		if err := database.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", principal.UserID(), time.Now()).
+			Order("last_seen_at DESC").Find(&sessions).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to list sessions"})
+		}
+
+		response := make([]sessionResponse, 0, len(sessions))
+		for _, session := range sessions {
+			response = append(response, sessionResponse{
+				ID:         session.ID,
+				DeviceName: session.DeviceName,
+				UserAgent:  session.UserAgent,
+				IP:         session.IP,
+				CreatedAt:  session.CreatedAt,
+				LastSeenAt: session.LastSeenAt,
+				LastSeenIP: session.LastSeenIP,
+				ExpiresAt:  session.ExpiresAt,
+				Browser:    session.IsBrowserSession(),
+				Current:    session.ID == principal.SessionID,
+			})
+		}
+		return c.JSON(response)
+	}
+}
+
+// RevokeSession ends one of the authenticated user's sessions: its refresh tokens are revoked and its
+// access tokens are rejected from the next request on.
+func RevokeSession(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		sessionID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid session ID"})
+		}
+
+		var session models.Session
+		if err := database.Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, principal.UserID()).First(&session).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Session not found"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to revoke session"})
+		}
+
+		if err := database.Transaction(func(tx *gorm.DB) error {
+			return models.RevokeRefreshTokenFamily(tx, session.FamilyID)
+		}); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to revoke session"})
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
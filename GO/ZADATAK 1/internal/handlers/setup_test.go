@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"zadatak-filip-janjesic/internal/db"
+	"zadatak-filip-janjesic/internal/keys"
+	"zadatak-filip-janjesic/internal/mail"
+	"zadatak-filip-janjesic/internal/models"
+	"zadatak-filip-janjesic/internal/password"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// newTestDB returns a new database on a temporary file, migrated like the one the server opens.
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	database, err := db.OpenGormDB(filepath.Join(t.TempDir(), "notes.db"))
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	return database
+}
+
+// useTestKeyring generates a signing key in the database and signs the tokens of the test with it.
+func useTestKeyring(t *testing.T, database *gorm.DB) {
+	t.Helper()
+	if _, err := keys.Generate(database, keys.AlgorithmEdDSA); err != nil {
+		t.Fatalf("generating signing key: %v", err)
+	}
+	testKeyring, err := keys.NewKeyring(database)
+	if err != nil {
+		t.Fatalf("loading keyring: %v", err)
+	}
+	previous := keyring
+	SetKeyring(testKeyring)
+	t.Cleanup(func() { SetKeyring(previous) })
+}
+
+// useTestPasswordHasher hashes the passwords of the test with the cheapest bcrypt cost.
+func useTestPasswordHasher(t *testing.T) {
+	t.Helper()
+	previous := passwordHasher
+	SetPasswordHasher(&password.Bcrypt{Cost: bcrypt.MinCost})
+	t.Cleanup(func() { SetPasswordHasher(previous) })
+}
+
+// testMailer records the emails the handlers send.
+type testMailer struct {
+	mu       sync.Mutex
+	messages []mail.Message
+}
+
+// Send records the message.
+func (m *testMailer) Send(msg mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// sent returns the messages sent so far.
+func (m *testMailer) sent() []mail.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]mail.Message(nil), m.messages...)
+}
+
+// useTestMailer records the emails of the test instead of sending them.
+func useTestMailer(t *testing.T) *testMailer {
+	t.Helper()
+	recorder := &testMailer{}
+	previous := mailer
+	SetMailer(recorder)
+	t.Cleanup(func() { SetMailer(previous) })
+	return recorder
+}
+
+// createTestUser stores a user with the given username; the address is derived from it.
+func createTestUser(t *testing.T, database *gorm.DB, username string) models.User {
+	t.Helper()
+	user := models.User{
+		Username:  username,
+		Password:  "not a hash",
+		FirstName: "Test",
+		LastName:  "User",
+		Email:     username + "@example.com",
+		Role:      models.RoleUser,
+	}
+	if err := database.Create(&user).Error; err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+	return user
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+
+	"zadatak-filip-janjesic/internal/models" // Import models for the note and tag structs
+
+	"github.com/go-playground/validator/v10" // Validator for input validation
+	"github.com/gofiber/fiber/v2"            // Fiber for HTTP handling
+	"gorm.io/gorm"                           // Database operations
+)
+
+// tagResponse is the JSON representation of a tag in the tag list.
+type tagResponse struct {
+	ID        uint   `json:"id"`
+	Name      string `json:"name"`
+	NoteCount int64  `json:"note_count"` // Number of non-deleted notes with the tag
+}
+
+// ListTags returns the authenticated user's tags with the number of notes using each, sorted by name.
+func ListTags(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := currentUserID(c)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		tags, err := tagUsage(database, uint(userID), 0)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to list tags"})
+		}
+		return c.JSON(tags)
+	}
+}
+
+// RenameTag renames one of the authenticated user's tags on all of their notes. Renaming to the name of
+// another tag is refused; MergeTags combines two tags instead.
+func RenameTag(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := currentUserID(c)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		var request struct {
+			Name string `json:"name" validate:"required,max=50"`
+		}
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+		}
+		request.Name = models.NormalizeTagName(request.Name)
+		if err := validator.New().Struct(request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Validation failed: " + err.Error()})
+		}
+
+		tag, err := findUserTag(database, userID, c.Params("id"))
+		if err != nil {
+			return tagLookupError(c, err)
+		}
+
+		if request.Name != tag.Name {
+			var count int64
+			if err := database.Model(&models.Tag{}).Where("user_id = ? AND name = ?", userID, request.Name).Count(&count).Error; err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to rename tag"})
+			}
+			if count > 0 {
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "A tag with this name already exists; merge the tags instead"})
+			}
+			if err := database.Model(&tag).Update("name", request.Name).Error; err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to rename tag"})
+			}
+
+			// Cached notes carry the old name
+			models.DeleteUserNotesCache(database, userID)
+		}
+
+		return respondWithTag(c, database, tag)
+	}
+}
+
+// MergeTags moves all notes of one of the authenticated user's tags to another of their tags, and deletes
+// the first tag, in one transaction.
+func MergeTags(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := currentUserID(c)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		var request struct {
+			Into uint `json:"into" validate:"required"` // ID of the tag that is kept
+		}
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+		}
+		if err := validator.New().Struct(request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Validation failed: " + err.Error()})
+		}
+
+		source, err := findUserTag(database, userID, c.Params("id"))
+		if err != nil {
+			return tagLookupError(c, err)
+		}
+		target, err := findUserTag(database, userID, strconv.FormatUint(uint64(request.Into), 10))
+		if err != nil {
+			return tagLookupError(c, err)
+		}
+		if source.ID == target.ID {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "A tag cannot be merged into itself"})
+		}
+
+		err = database.Transaction(func(tx *gorm.DB) error {
+			// Notes that already have both tags keep a single link
+			if err := tx.Exec(`INSERT INTO note_tags (note_id, tag_id)
+				SELECT note_id, ? FROM note_tags
+				WHERE tag_id = ? AND note_id NOT IN (SELECT note_id FROM note_tags WHERE tag_id = ?)`,
+				target.ID, source.ID, target.ID).Error; err != nil {
+				return err
+			}
+			if err := tx.Exec("DELETE FROM note_tags WHERE tag_id = ?", source.ID).Error; err != nil {
+				return err
+			}
+			return tx.Unscoped().Delete(&source).Error
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to merge tags"})
+		}
+
+		// Cached notes carry the merged tag
+		models.DeleteUserNotesCache(database, userID)
+
+		return respondWithTag(c, database, target)
+	}
+}
+
+// setNoteTags replaces the tags of a note with the owner's tags of the given names, creating missing tags.
+func setNoteTags(tx *gorm.DB, note *models.Note, names []string) error {
+	tags, err := models.FindOrCreateTags(tx, uint(note.UserID), names)
+	if err != nil {
+		return err
+	}
+	if err := tx.Model(note).Association("Tags").Replace(tags); err != nil {
+		return err
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+	note.Tags = tags
+	return nil
+}
+
+// orderTagsByName sorts preloaded tags by name.
+func orderTagsByName(db *gorm.DB) *gorm.DB {
+	return db.Order("tags.name")
+}
+
+// findUserTag returns the tag with the ID given as a path parameter, if it belongs to the user.
+func findUserTag(database *gorm.DB, userID int, id string) (models.Tag, error) {
+	var tag models.Tag
+	tagID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return tag, gorm.ErrRecordNotFound
+	}
+	err = database.Where("id = ? AND user_id = ?", tagID, userID).First(&tag).Error
+	return tag, err
+}
+
+// tagLookupError answers a failed findUserTag.
+func tagLookupError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Tag not found"})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error retrieving tag"})
+}
+
+// respondWithTag answers with the current name and usage of a tag.
+func respondWithTag(c *fiber.Ctx, database *gorm.DB, tag models.Tag) error {
+	tags, err := tagUsage(database, tag.UserID, tag.ID)
+	if err != nil || len(tags) == 0 {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error retrieving tag"})
+	}
+	return c.JSON(tags[0])
+}
+
+// tagUsage returns the user's tags, or only the tag with the given ID if it is not 0, with the number of
+// non-deleted notes using each.
+func tagUsage(database *gorm.DB, userID, tagID uint) ([]tagResponse, error) {
+	query := database.Table("tags").
+		Select("tags.id, tags.name, COUNT(notes.id) AS note_count").
+		Joins("LEFT JOIN note_tags ON note_tags.tag_id = tags.id").
+		Joins("LEFT JOIN notes ON notes.id = note_tags.note_id AND notes.deleted_at IS NULL").
+		Where("tags.user_id = ? AND tags.deleted_at IS NULL", userID).
+		Group("tags.id").
+		Order("tags.name")
+	if tagID != 0 {
+		query = query.Where("tags.id = ?", tagID)
+	}
+
+	tags := []tagResponse{}
+	err := query.Scan(&tags).Error
+	return tags, err
+}
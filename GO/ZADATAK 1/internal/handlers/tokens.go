@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"zadatak-filip-janjesic/internal/models" // Import models for user and refresh token structs
+
+	"github.com/gofiber/fiber/v2" // Fiber framework for web server
+	"gorm.io/gorm"                // GORM for ORM and database operations
+)
+
+// Default lifetimes of the two halves of a token pair, used when the environment does not override them.
+const (
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// errInvalidRefreshToken is returned for every refresh token that cannot be exchanged.
+var errInvalidRefreshToken = errors.New("invalid refresh token")
+
+// errRefreshTokenReused is returned inside the rotation transaction when the token was used concurrently.
+var errRefreshTokenReused = errors.New("refresh token already used")
+
+// accessTokenTTL returns the lifetime of access tokens (ACCESS_TOKEN_TTL, e.g. "15m").
+func accessTokenTTL() time.Duration {
+	return envDuration("ACCESS_TOKEN_TTL", defaultAccessTokenTTL)
+}
+
+// refreshTokenTTL returns the lifetime of refresh tokens (REFRESH_TOKEN_TTL, e.g. "720h").
+func refreshTokenTTL() time.Duration {
+	return envDuration("REFRESH_TOKEN_TTL", defaultRefreshTokenTTL)
+}
+
+// envDuration reads a duration from the environment, falling back to the default when it is missing or invalid.
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		log.Printf("Invalid duration %q for %s, using %s", value, key, fallback)
+		return fallback
+	}
+	return duration
+}
+
+// envInt reads a positive integer from the environment, falling back to the default if unset or invalid.
+func envInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number <= 0 {
+		log.Printf("Invalid number %q for %s, using %d", value, key, fallback)
+		return fallback
+	}
+	return number
+}
+
+// TokenPair is the response returned whenever a client is issued new credentials.
+type TokenPair struct {
+	Token        string `json:"token"`         // Short-lived access token (JWT)
+	RefreshToken string `json:"refresh_token"` // Opaque, single-use refresh token
+	TokenType    string `json:"token_type"`    // Always "Bearer"
+	ExpiresIn    int64  `json:"expires_in"`    // Lifetime of the access token in seconds
+}
+
+// RefreshTokenHandler exchanges a valid refresh token for a new token pair.
+// Every refresh token can be used once; presenting it a second time revokes its whole family.
+func RefreshTokenHandler(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var request struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+
+		// Parse JSON request body into the request struct
+		if err := c.BodyParser(&request); err != nil || request.RefreshToken == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Refresh token required"})
+		}
+
+		pair, err := rotateRefreshToken(database, request.RefreshToken)
+		if err != nil {
+			if errors.Is(err, errInvalidRefreshToken) {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid refresh token"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error refreshing token"})
+		}
+
+		// Send the new token pair in the response
+		return c.Status(fiber.StatusOK).JSON(pair)
+	}
+}
+
+// issueTokenPair starts a session for a successful login on the described device and creates its first
+// access token and refresh token, which start a new token family.
+func issueTokenPair(database *gorm.DB, user models.User, device sessionInfo) (TokenPair, error) {
+	familyID, err := newOpaqueToken()
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	var pair TokenPair
+	err = database.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		session := models.Session{
+			UserID:     user.ID,
+			FamilyID:   familyID,
+			DeviceName: device.DeviceName,
+			UserAgent:  device.UserAgent,
+			IP:         device.IP,
+			LastSeenAt: now,
+			LastSeenIP: device.IP,
+			ExpiresAt:  now.Add(refreshTokenTTL()),
+		}
+		if err := tx.Create(&session).Error; err != nil {
+			return fmt.Errorf("error storing session: %w", err)
+		}
+
+		issued, err := issueTokenPairInSession(tx, user, session)
+		pair = issued
+		return err
+	})
+	return pair, err
+}
+
+// issueTokenPairInSession creates an access token and a refresh token belonging to the session and its family.
+func issueTokenPairInSession(database *gorm.DB, user models.User, session models.Session) (TokenPair, error) {
+	accessToken, err := generateToken(user, session.ID)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	refreshToken, err := newOpaqueToken()
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	// Store only the hash of the refresh token
+	record := models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  session.FamilyID,
+		TokenHash: hashToken(refreshToken),
+		ExpiresAt: time.Now().Add(refreshTokenTTL()),
+	}
+	if err := database.Create(&record).Error; err != nil {
+		return TokenPair{}, fmt.Errorf("error storing refresh token: %w", err)
+	}
+
+	// The session lasts as long as its newest refresh token
+	if err := database.Model(&models.Session{}).Where("id = ?", session.ID).Update("expires_at", record.ExpiresAt).Error; err != nil {
+		return TokenPair{}, fmt.Errorf("error extending session: %w", err)
+	}
+
+	return TokenPair{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(accessTokenTTL().Seconds()),
+	}, nil
+}
+
+// rotateRefreshToken marks the presented refresh token as used and issues a new pair in the same family.
+// A token that was already used is treated as stolen, and its entire family is revoked.
+func rotateRefreshToken(database *gorm.DB, presented string) (TokenPair, error) {
+	var stored models.RefreshToken
+	if err := database.Where("token_hash = ?", hashToken(presented)).First(&stored).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return TokenPair{}, errInvalidRefreshToken
+		}
+		return TokenPair{}, err
+	}
+
+	if stored.RevokedAt != nil {
+		return TokenPair{}, errInvalidRefreshToken
+	}
+
+	if stored.UsedAt != nil {
+		return TokenPair{}, revokeReusedFamily(database, stored)
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		return TokenPair{}, errInvalidRefreshToken
+	}
+
+	// Mark the token as used and issue the new pair in one transaction, so a failure to issue does not
+	// use up the token; the condition on used_at makes concurrent exchanges of the same token lose
+	var pair TokenPair
+	err := database.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND used_at IS NULL", stored.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errRefreshTokenReused
+		}
+
+		// Make sure the user still exists before issuing new credentials
+		var user models.User
+		if err := tx.First(&user, stored.UserID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errInvalidRefreshToken
+			}
+			return err
+		}
+		if user.DisabledAt != nil {
+			return errInvalidRefreshToken
+		}
+
+		// The family only continues while its session has not been revoked
+		var session models.Session
+		if err := tx.Where("family_id = ? AND revoked_at IS NULL", stored.FamilyID).First(&session).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errInvalidRefreshToken
+			}
+			return err
+		}
+
+		issued, err := issueTokenPairInSession(tx, user, session)
+		pair = issued
+		return err
+	})
+	if errors.Is(err, errRefreshTokenReused) {
+		// The family is revoked outside the transaction, which has been rolled back
+		return TokenPair{}, revokeReusedFamily(database, stored)
+	}
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return pair, nil
+}
+
+// revokeReusedFamily revokes the family of a refresh token that was presented after it had been used.
+func revokeReusedFamily(database *gorm.DB, stored models.RefreshToken) error {
+	log.Printf("Refresh token reuse detected for user %d, revoking family %s", stored.UserID, stored.FamilyID)
+	if err := models.RevokeRefreshTokenFamily(database, stored.FamilyID); err != nil {
+		return fmt.Errorf("error revoking refresh token family: %w", err)
+	}
+	return errInvalidRefreshToken
+}
+
+// newOpaqueToken generates a random, URL-safe token value.
+func newOpaqueToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("error generating token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken returns the hex-encoded SHA-256 hash under which an opaque token is stored.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"zadatak-filip-janjesic/internal/keys"
+	"zadatak-filip-janjesic/internal/models"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestParseToken(t *testing.T) {
+	database := newTestDB(t)
+	useTestKeyring(t, database)
+	user := createTestUser(t, database, "john")
+	now := time.Now()
+
+	// signClaims signs claims that start from those of a valid access token
+	signClaims := func(change func(*Claims)) string {
+		claims := newClaims(user, purposeAccess, "token-id", now, time.Minute)
+		change(&claims)
+		token, err := keyring.Sign(claims)
+		if err != nil {
+			t.Fatalf("signing token: %v", err)
+		}
+		return token
+	}
+	signed := func(purpose string) string {
+		token, err := signToken(user, purpose, time.Minute)
+		if err != nil {
+			t.Fatalf("signToken: %v", err)
+		}
+		return token
+	}
+
+	// A keyring of another database signs with a key this service does not know
+	otherDB, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "other.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := otherDB.AutoMigrate(&models.SigningKey{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Generate(otherDB, keys.AlgorithmEdDSA); err != nil {
+		t.Fatal(err)
+	}
+	otherKeyring, err := keys.NewKeyring(otherDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign, err := otherKeyring.Sign(newClaims(user, purposeAccess, "token-id", now, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		purpose string
+		wantErr bool
+	}{
+		{"access token", signed(purposeAccess), purposeAccess, false},
+		{"MFA challenge token", signed(purposeMFA), purposeMFA, false},
+		{"MFA challenge token used as access token", signed(purposeMFA), purposeAccess, true},
+		{"access token used as MFA challenge token", signed(purposeAccess), purposeMFA, true},
+		{"expired", signClaims(func(c *Claims) { c.ExpiresAt.Time = now.Add(-time.Minute) }), purposeAccess, true},
+		{"without token ID", signClaims(func(c *Claims) { c.ID = "" }), purposeAccess, true},
+		{"without issue time", signClaims(func(c *Claims) { c.IssuedAt = nil }), purposeAccess, true},
+		{"without expiry", signClaims(func(c *Claims) { c.ExpiresAt = nil }), purposeAccess, true},
+		{"without user ID", signClaims(func(c *Claims) { c.UserID = 0 }), purposeAccess, true},
+		{"signed by another keyring", foreign, purposeAccess, true},
+		{"not a token", "not.a.token", purposeAccess, true},
+		{"empty", "", purposeAccess, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims, err := parseToken(test.token, test.purpose)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseToken = %v, want error: %v", err, test.wantErr)
+			}
+			if err == nil && (claims.UserID != user.ID || claims.Subject != "1" || claims.Purpose != test.purpose) {
+				t.Errorf("claims = %+v, want user %d with purpose %q", claims, user.ID, test.purpose)
+			}
+		})
+	}
+}
+
+func TestOpaqueTokens(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		token, err := newOpaqueToken()
+		if err != nil {
+			t.Fatalf("newOpaqueToken: %v", err)
+		}
+		raw, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil || len(raw) != 32 {
+			t.Fatalf("token %q is not 32 bytes of unpadded base64url: %v", token, err)
+		}
+		if seen[token] {
+			t.Fatalf("token %q was generated twice", token)
+		}
+		seen[token] = true
+	}
+
+	// The stored form is the hex SHA-256 digest of the token
+	if got := hashToken("abc"); got != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Errorf("hashToken(abc) = %s", got)
+	}
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	database := newTestDB(t)
+	useTestKeyring(t, database)
+	user := createTestUser(t, database, "john")
+
+	first, err := issueTokenPair(database, user, sessionInfo{DeviceName: "Laptop", IP: "127.0.0.1"})
+	if err != nil {
+		t.Fatalf("issueTokenPair: %v", err)
+	}
+	if _, err := parseAccessToken(first.Token); err != nil {
+		t.Fatalf("access token of the first pair does not verify: %v", err)
+	}
+	second, err := rotateRefreshToken(database, first.RefreshToken)
+	if err != nil {
+		t.Fatalf("rotateRefreshToken: %v", err)
+	}
+	if second.RefreshToken == first.RefreshToken {
+		t.Fatal("rotation returned the same refresh token")
+	}
+
+	// Presenting the used token again revokes the family, including the token it was exchanged for
+	tests := []struct {
+		name      string
+		presented string
+	}{
+		{"unknown token", "unknown"},
+		{"used token", first.RefreshToken},
+		{"token of the revoked family", second.RefreshToken},
+	}
+	for _, test := range tests {
+		if _, err := rotateRefreshToken(database, test.presented); !errors.Is(err, errInvalidRefreshToken) {
+			t.Errorf("%s: rotateRefreshToken = %v, want errInvalidRefreshToken", test.name, err)
+		}
+	}
+
+	var session models.Session
+	if err := database.Where("user_id = ?", user.ID).First(&session).Error; err != nil {
+		t.Fatal(err)
+	}
+	if session.RevokedAt == nil {
+		t.Error("the session of the reused family was not revoked")
+	}
+}
+
+func TestRotateRefreshTokenRollsBackOnFailure(t *testing.T) {
+	database := newTestDB(t)
+	useTestKeyring(t, database)
+	user := createTestUser(t, database, "john")
+
+	pair, err := issueTokenPair(database, user, sessionInfo{DeviceName: "Laptop", IP: "127.0.0.1"})
+	if err != nil {
+		t.Fatalf("issueTokenPair: %v", err)
+	}
+
+	// No new pair is issued while the account is disabled, and the token is not used up by the attempt
+	if err := database.Model(&user).Update("disabled_at", time.Now()).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rotateRefreshToken(database, pair.RefreshToken); !errors.Is(err, errInvalidRefreshToken) {
+		t.Fatalf("rotateRefreshToken of a disabled account = %v, want errInvalidRefreshToken", err)
+	}
+	var stored models.RefreshToken
+	if err := database.Where("token_hash = ?", hashToken(pair.RefreshToken)).First(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.UsedAt != nil {
+		t.Error("the refresh token was marked as used although no pair was issued")
+	}
+
+	if err := database.Model(&user).Update("disabled_at", nil).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rotateRefreshToken(database, pair.RefreshToken); err != nil {
+		t.Errorf("rotateRefreshToken after enabling the account: %v", err)
+	}
+}
+
+func TestEnvSettings(t *testing.T) {
+	durations := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", time.Hour},
+		{"15m", 15 * time.Minute},
+		{"90s", 90 * time.Second},
+		{"0s", time.Hour},
+		{"-5m", time.Hour},
+		{"soon", time.Hour},
+	}
+	for _, test := range durations {
+		t.Setenv("TEST_DURATION", test.value)
+		if got := envDuration("TEST_DURATION", time.Hour); got != test.want {
+			t.Errorf("envDuration(%q) = %s, want %s", test.value, got, test.want)
+		}
+	}
+
+	numbers := []struct {
+		value string
+		want  int
+	}{
+		{"", 5},
+		{"12", 12},
+		{"0", 5},
+		{"-3", 5},
+		{"ten", 5},
+	}
+	for _, test := range numbers {
+		t.Setenv("TEST_NUMBER", test.value)
+		if got := envInt("TEST_NUMBER", 5); got != test.want {
+			t.Errorf("envInt(%q) = %d, want %d", test.value, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"zadatak-filip-janjesic/internal/models"   // Import models for user and recovery code structs
+	"zadatak-filip-janjesic/internal/totp"     // TOTP codes for two-factor authentication
+	"zadatak-filip-janjesic/internal/webauthn" // Passkey assertions as an alternative second factor
+
+	"github.com/gofiber/fiber/v2" // Fiber for HTTP handling
+	"gorm.io/gorm"                // Database operations
+)
+
+// mfaTokenTTL is how long a user has to enter the second factor after a successful password check.
+const mfaTokenTTL = 5 * time.Minute
+
+// recoveryCodeCount is the number of recovery codes generated when two-factor authentication is enabled.
+const recoveryCodeCount = 10
+
+// errInvalidSecondFactor is returned when neither the TOTP code nor the recovery code is valid.
+var errInvalidSecondFactor = errors.New("invalid two-factor code")
+
+// secondFactorRequest is the body accepted wherever a second factor is required.
+// Exactly one of Code (from the authenticator app) and RecoveryCode must be set.
+type secondFactorRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// EnrollTwoFactor generates a new TOTP secret for the user. The secret stays pending, and logins are not
+// affected, until the user proves with ConfirmTwoFactor that their authenticator app produces valid codes.
+func EnrollTwoFactor(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+		if principal.User.TOTPEnabledAt != nil {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Two-factor authentication is already enabled"})
+		}
+
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to generate secret"})
+		}
+		if err := database.Model(&models.User{}).Where("id = ?", principal.UserID()).Update("totp_secret", secret).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to store secret"})
+		}
+
+		// The URI is what authenticator apps expect inside the QR code
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"secret":      secret,
+			"otpauth_uri": totp.URI(totpIssuer(), principal.User.Username, secret),
+		})
+	}
+}
+
+// ConfirmTwoFactor enables two-factor authentication once the user sends a valid code for the pending
+// secret, and returns the recovery codes. They are shown only this once.
+func ConfirmTwoFactor(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		var request secondFactorRequest
+		if err := c.BodyParser(&request); err != nil || request.Code == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Code required"})
+		}
+
+		user := principal.User
+		if user.TOTPEnabledAt != nil {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Two-factor authentication is already enabled"})
+		}
+		if user.TOTPSecret == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Start the enrolment with POST /me/2fa/enroll first"})
+		}
+
+		counter, valid := totp.Validate(user.TOTPSecret, request.Code, time.Now())
+		if !valid {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid code"})
+		}
+
+		var codes []string
+		err := database.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+				"totp_enabled_at":   time.Now(),
+				"totp_last_counter": counter,
+			}).Error; err != nil {
+				return err
+			}
+
+			var err error
+			codes, err = replaceRecoveryCodes(tx, user.ID)
+			return err
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to enable two-factor authentication"})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"message":        "Two-factor authentication enabled; store the recovery codes in a safe place",
+			"recovery_codes": codes,
+		})
+	}
+}
+
+// DisableTwoFactor turns two-factor authentication off. It requires the password and a second factor,
+// so that a stolen access token alone cannot remove the protection.
+func DisableTwoFactor(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		var request struct {
+			Password string `json:"password"`
+			secondFactorRequest
+		}
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+		}
+
+		user := principal.User
+		if user.TOTPEnabledAt == nil {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Two-factor authentication is not enabled"})
+		}
+		valid, err := checkPassword(database, user, request.Password)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error checking password"})
+		}
+		if !valid {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid password"})
+		}
+		if err := verifySecondFactor(database, user, request.secondFactorRequest); err != nil {
+			if errors.Is(err, errInvalidSecondFactor) {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid two-factor code"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error checking two-factor code"})
+		}
+
+		err = database.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+				"totp_secret":       "",
+				"totp_enabled_at":   nil,
+				"totp_last_counter": 0,
+			}).Error; err != nil {
+				return err
+			}
+			return tx.Unscoped().Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to disable two-factor authentication"})
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// respondWithMFAChallenge answers a login of a user with two-factor authentication with a challenge
+// token instead of the token pair.
+func respondWithMFAChallenge(c *fiber.Ctx, user models.User) error {
+	mfaToken, err := signToken(user, purposeMFA, mfaTokenTTL)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error generating token"})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"mfa_required": true,
+		"mfa_token":    mfaToken,
+		"expires_in":   int64(mfaTokenTTL.Seconds()),
+	})
+}
+
+// CompleteTwoFactorLogin exchanges the challenge token returned by Login, together with a TOTP code, a
+// recovery code or a passkey assertion, for the real token pair or a browser session. Each challenge token
+// can be exchanged only once.
+func CompleteTwoFactorLogin(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var request struct {
+			MFAToken    string `json:"mfa_token"`
+			DeviceName  string `json:"device_name"`  // Optional name of the device, shown in the session list
+			SessionMode string `json:"session_mode"` // "token" (default) or "cookie" for a browser session
+			secondFactorRequest
+			Passkey *webauthn.AssertionResponse `json:"passkey"` // Alternative to the codes, see BeginPasskeySecondFactor
+		}
+		if err := c.BodyParser(&request); err != nil || request.MFAToken == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "mfa_token required"})
+		}
+		if !isValidSessionMode(request.SessionMode) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "session_mode must be \"token\" or \"cookie\""})
+		}
+
+		// Verify the challenge token and make sure it has not been exchanged yet
+		claims, err := parseToken(request.MFAToken, purposeMFA)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired mfa_token"})
+		}
+		revoked, err := models.IsTokenRevoked(database, claims.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error checking token"})
+		}
+		if revoked {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired mfa_token"})
+		}
+
+		var user models.User
+		if err := database.First(&user, claims.UserID).Error; err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "User not found"})
+		}
+
+		// The account may have been disabled or flagged since the first factor was checked
+		if err := checkLoginAllowedState(user); err != nil {
+			return refuseLogin(c, err)
+		}
+
+		// Failed codes count as failed logins, so guessing codes backs off and eventually locks the account
+		throttleKeys := loginThrottleKeys(user.Username, c.IP())
+		wait, err := checkLoginAllowed(database, throttleKeys, &user)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error checking login attempts"})
+		}
+		if wait > 0 {
+			return tooManyLoginAttempts(c, wait)
+		}
+
+		verify := func() error { return verifySecondFactor(database, user, request.secondFactorRequest) }
+		if request.Passkey != nil {
+			verify = func() error { return verifyPasskeySecondFactor(database, user, *request.Passkey) }
+		}
+		if err := verify(); err != nil {
+			if errors.Is(err, errInvalidSecondFactor) || errors.Is(err, errInvalidPasskey) || errors.Is(err, errClonedPasskey) {
+				if err := handleLoginFailure(database, throttleKeys, &user); err != nil {
+					return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error recording login attempt"})
+				}
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid two-factor code"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error checking two-factor code"})
+		}
+		if err := models.ClearLoginThrottle(database, models.UsernameThrottleKey(user.Username)); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error recording login attempt"})
+		}
+
+		// Burn the challenge token so that it cannot be exchanged again
+		if err := models.RevokeToken(database, claims.ID, user.ID, claims.ExpiresAt.Time); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error generating token"})
+		}
+
+		return respondWithLogin(c, database, user, newSessionInfo(c, request.DeviceName), request.SessionMode)
+	}
+}
+
+// verifySecondFactor checks a TOTP code or a recovery code for a user with two-factor authentication.
+// A TOTP code is accepted only for a time step after the last accepted one, and a recovery code only once.
+func verifySecondFactor(database *gorm.DB, user models.User, request secondFactorRequest) error {
+	if user.TOTPEnabledAt == nil {
+		return errInvalidSecondFactor
+	}
+
+	switch {
+	case request.Code != "":
+		counter, valid := totp.Validate(user.TOTPSecret, request.Code, time.Now())
+		if !valid {
+			return errInvalidSecondFactor
+		}
+
+		// Store the time step; the condition makes a replayed (or concurrently used) code fail
+		result := database.Model(&models.User{}).
+			Where("id = ? AND totp_last_counter < ?", user.ID, counter).
+			Update("totp_last_counter", counter)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errInvalidSecondFactor
+		}
+		return nil
+
+	case request.RecoveryCode != "":
+		result := database.Model(&models.RecoveryCode{}).
+			Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashToken(normalizeRecoveryCode(request.RecoveryCode))).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errInvalidSecondFactor
+		}
+		return nil
+
+	default:
+		return errInvalidSecondFactor
+	}
+}
+
+// replaceRecoveryCodes deletes the user's recovery codes and stores a fresh set, returning the plaintext codes.
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		// 10 base32 characters (50 bits), shown as two groups of five
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		encoded := strings.ToLower(base32.StdEncoding.EncodeToString(raw))[:10]
+		code := encoded[:5] + "-" + encoded[5:]
+
+		if err := tx.Create(&models.RecoveryCode{UserID: userID, CodeHash: hashToken(normalizeRecoveryCode(code))}).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode makes recovery codes case-insensitive and ignores separators and spaces.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// totpIssuer returns the issuer name shown in authenticator apps (TOTP_ISSUER).
+func totpIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "Notes"
+}
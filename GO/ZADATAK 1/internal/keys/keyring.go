@@ -0,0 +1,324 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"zadatak-filip-janjesic/internal/models" // Import models for the signing key struct
+
+	"github.com/golang-jwt/jwt/v4" // JWT library for token handling
+	"gorm.io/gorm"                 // GORM for ORM and database operations
+)
+
+// Supported signing algorithms.
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// rsaKeyBits is the size of newly generated RSA keys.
+const rsaKeyBits = 2048
+
+// ErrNoSigningKey is returned when the keyring has no key that may sign new tokens.
+var ErrNoSigningKey = errors.New("no active signing key")
+
+// key is a parsed signing key held in memory.
+type key struct {
+	kid       string
+	algorithm string
+	method    jwt.SigningMethod
+	private   crypto.Signer
+	public    crypto.PublicKey
+}
+
+// Keyring holds the keys used to sign and verify JWTs, loaded from the signing_keys table.
+type Keyring struct {
+	db      *gorm.DB
+	mu      sync.RWMutex
+	signing *key            // Key used for new tokens
+	verify  map[string]*key // Keys accepted for verification, indexed by kid
+}
+
+// NewKeyring creates a keyring and loads the keys from the database.
+func NewKeyring(db *gorm.DB) (*Keyring, error) {
+	keyring := &Keyring{db: db}
+	if err := keyring.Reload(); err != nil {
+		return nil, err
+	}
+	return keyring, nil
+}
+
+// Reload re-reads the keys from the database, picking up keys generated or retired by the admin command.
+func (k *Keyring) Reload() error {
+	var records []models.SigningKey
+	// Keys are only ever added, so the ID orders them by age whatever offset their timestamps were stored with
+	if err := k.db.Where("expires_at IS NULL OR expires_at > ?", time.Now()).Order("id DESC").Find(&records).Error; err != nil {
+		return fmt.Errorf("error loading signing keys: %w", err)
+	}
+
+	var signing *key
+	verify := make(map[string]*key, len(records))
+	for _, record := range records {
+		parsed, err := parseKey(record)
+		if err != nil {
+			return err
+		}
+		verify[parsed.kid] = parsed
+
+		// Records are ordered newest first, so the first key that is not retired signs
+		if signing == nil && record.RetiredAt == nil {
+			signing = parsed
+		}
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.signing = signing
+	k.verify = verify
+	return nil
+}
+
+// HasSigningKey reports whether the keyring can sign new tokens.
+func (k *Keyring) HasSigningKey() bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.signing != nil
+}
+
+// Sign signs the claims with the current signing key and sets the "kid" header.
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	k.mu.RLock()
+	signing := k.signing
+	k.mu.RUnlock()
+
+	if signing == nil {
+		return "", ErrNoSigningKey
+	}
+
+	token := jwt.NewWithClaims(signing.method, claims)
+	token.Header["kid"] = signing.kid
+	return token.SignedString(signing.private)
+}
+
+// Keyfunc returns the verification key for a token, selected by its "kid" header. The token's
+// algorithm must match the algorithm of the key, so a key can never be used with another algorithm.
+func (k *Keyring) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, errors.New("token has no key ID")
+	}
+
+	k.mu.RLock()
+	verifying, found := k.verify[kid]
+	k.mu.RUnlock()
+
+	if !found {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	if token.Method.Alg() != verifying.algorithm {
+		return nil, fmt.Errorf("unexpected signing method %q for key %q", token.Method.Alg(), kid)
+	}
+	return verifying.public, nil
+}
+
+// Algorithms returns the algorithms supported by the keyring, for pinning in the JWT parser.
+func Algorithms() []string {
+	return []string{AlgorithmRS256, AlgorithmEdDSA}
+}
+
+// JWK is the JSON Web Key representation of a public verification key.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	N         string `json:"n,omitempty"`   // RSA modulus
+	E         string `json:"e,omitempty"`   // RSA public exponent
+	Curve     string `json:"crv,omitempty"` // OKP or EC curve name
+	X         string `json:"x,omitempty"`   // OKP public key, or EC x coordinate
+	Y         string `json:"y,omitempty"`   // EC y coordinate
+}
+
+// PublicKey decodes the public key of an RSA, EC (P-256) or OKP (Ed25519) JWK.
+func (j JWK) PublicKey() (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch j.KeyType {
+	case "RSA":
+		n, err := decode(j.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus: %w", err)
+		}
+		e, err := decode(j.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if j.Curve != "P-256" {
+			return nil, fmt.Errorf("unsupported EC curve %q", j.Curve)
+		}
+		x, errX := decode(j.X)
+		y, errY := decode(j.Y)
+		if errX != nil || errY != nil {
+			return nil, fmt.Errorf("invalid EC coordinates")
+		}
+		public := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !public.Curve.IsOnCurve(public.X, public.Y) {
+			return nil, fmt.Errorf("EC point is not on the curve")
+		}
+		return public, nil
+	case "OKP":
+		x, err := decode(j.X)
+		if j.Curve != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", j.KeyType)
+	}
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public parts of all verification keys.
+func (k *Keyring) JWKS() JWKS {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	set := JWKS{Keys: make([]JWK, 0, len(k.verify))}
+	for _, verifying := range k.verify {
+		jwk := JWK{KeyID: verifying.kid, Algorithm: verifying.algorithm, Use: "sig"}
+		switch public := verifying.public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// Generate creates a new key pair for the algorithm and stores it. Being the newest key,
+// it becomes the signing key on the next reload, while older keys keep verifying.
+func Generate(db *gorm.DB, algorithm string) (models.SigningKey, error) {
+	var private crypto.Signer
+	var err error
+	switch algorithm {
+	case AlgorithmRS256:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case AlgorithmEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return models.SigningKey{}, fmt.Errorf("unsupported algorithm %q", algorithm)
+	}
+	if err != nil {
+		return models.SigningKey{}, fmt.Errorf("error generating key: %w", err)
+	}
+
+	// Encode the private key as PKCS #8 PEM
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return models.SigningKey{}, fmt.Errorf("error encoding key: %w", err)
+	}
+	encoded := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	// Use a random key ID so that it reveals nothing about the key
+	kid := make([]byte, 8)
+	if _, err := rand.Read(kid); err != nil {
+		return models.SigningKey{}, fmt.Errorf("error generating key ID: %w", err)
+	}
+
+	record := models.SigningKey{
+		KID:        hex.EncodeToString(kid),
+		Algorithm:  algorithm,
+		PrivateKey: string(encoded),
+	}
+	if err := db.Create(&record).Error; err != nil {
+		return models.SigningKey{}, fmt.Errorf("error storing key: %w", err)
+	}
+	return record, nil
+}
+
+// Retire stops the key from signing new tokens. It keeps verifying tokens for the grace period,
+// which should be at least the access token lifetime, and is dropped from the keyring afterwards.
+func Retire(db *gorm.DB, kid string, grace time.Duration) error {
+	// Refuse to retire the last key that can sign, as nobody could log in afterwards
+	var remaining int64
+	if err := db.Model(&models.SigningKey{}).Where("kid <> ? AND retired_at IS NULL", kid).Count(&remaining).Error; err != nil {
+		return fmt.Errorf("error retiring key: %w", err)
+	}
+	if remaining == 0 {
+		return errors.New("cannot retire the last active key; generate a new key first")
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(grace)
+	result := db.Model(&models.SigningKey{}).
+		Where("kid = ? AND retired_at IS NULL", kid).
+		Updates(map[string]interface{}{"retired_at": now, "expires_at": expiresAt})
+	if result.Error != nil {
+		return fmt.Errorf("error retiring key: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("no active key with ID %q", kid)
+	}
+	return nil
+}
+
+// parseKey decodes a stored key and derives its public part.
+func parseKey(record models.SigningKey) (*key, error) {
+	block, _ := pem.Decode([]byte(record.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("key %s: invalid PEM data", record.KID)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", record.KID, err)
+	}
+
+	var method jwt.SigningMethod
+	switch record.Algorithm {
+	case AlgorithmRS256:
+		method = jwt.SigningMethodRS256
+		if _, ok := parsed.(*rsa.PrivateKey); !ok {
+			return nil, fmt.Errorf("key %s: not an RSA key", record.KID)
+		}
+	case AlgorithmEdDSA:
+		method = jwt.SigningMethodEdDSA
+		if _, ok := parsed.(ed25519.PrivateKey); !ok {
+			return nil, fmt.Errorf("key %s: not an Ed25519 key", record.KID)
+		}
+	default:
+		return nil, fmt.Errorf("key %s: unsupported algorithm %q", record.KID, record.Algorithm)
+	}
+
+	signer := parsed.(crypto.Signer)
+	return &key{
+		kid:       record.KID,
+		algorithm: record.Algorithm,
+		method:    method,
+		private:   signer,
+		public:    signer.Public(),
+	}, nil
+}
@@ -0,0 +1,307 @@
+package keys
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"zadatak-filip-janjesic/internal/models"
+
+	"github.com/golang-jwt/jwt/v4"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// openKeysTestDB returns a new database with the signing_keys table.
+func openKeysTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	database, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "keys.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	if err := database.AutoMigrate(&models.SigningKey{}); err != nil {
+		t.Fatalf("migrating database: %v", err)
+	}
+	return database
+}
+
+// mustGenerate generates a key or fails the test.
+func mustGenerate(t *testing.T, database *gorm.DB, algorithm string) models.SigningKey {
+	t.Helper()
+	record, err := Generate(database, algorithm)
+	if err != nil {
+		t.Fatalf("Generate(%s): %v", algorithm, err)
+	}
+	return record
+}
+
+// sign signs a token for the subject with the keyring and returns it.
+func sign(t *testing.T, keyring *Keyring, subject string) string {
+	t.Helper()
+	token, err := keyring.Sign(jwt.RegisteredClaims{Subject: subject, ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))})
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	return token
+}
+
+// verify parses a token the way the handlers do, with the algorithms pinned.
+func verify(keyring *Keyring, token string) (*jwt.RegisteredClaims, error) {
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, keyring.Keyfunc, jwt.WithValidMethods(Algorithms()))
+	return claims, err
+}
+
+// tokenKID returns the "kid" header of a token.
+func tokenKID(t *testing.T, token string) string {
+	t.Helper()
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatalf("parsing token: %v", err)
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
+
+func TestSignAndVerify(t *testing.T) {
+	for _, algorithm := range Algorithms() {
+		t.Run(algorithm, func(t *testing.T) {
+			database := openKeysTestDB(t)
+			record := mustGenerate(t, database, algorithm)
+			keyring, err := NewKeyring(database)
+			if err != nil {
+				t.Fatalf("NewKeyring: %v", err)
+			}
+			if !keyring.HasSigningKey() {
+				t.Fatal("keyring has no signing key")
+			}
+
+			token := sign(t, keyring, "42")
+			if kid := tokenKID(t, token); kid != record.KID {
+				t.Errorf("kid = %q, want %q", kid, record.KID)
+			}
+			claims, err := verify(keyring, token)
+			if err != nil {
+				t.Fatalf("verifying token: %v", err)
+			}
+			if claims.Subject != "42" {
+				t.Errorf("subject = %q, want 42", claims.Subject)
+			}
+
+			// Any change to the signed part invalidates the signature
+			parts := strings.Split(token, ".")
+			forged := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"1"}`)) + "." + parts[2]
+			if _, err := verify(keyring, forged); err == nil {
+				t.Error("a token with changed claims was accepted")
+			}
+		})
+	}
+}
+
+func TestEmptyKeyringCannotSign(t *testing.T) {
+	keyring, err := NewKeyring(openKeysTestDB(t))
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	if keyring.HasSigningKey() {
+		t.Error("empty keyring claims to have a signing key")
+	}
+	if _, err := keyring.Sign(jwt.RegisteredClaims{}); err != ErrNoSigningKey {
+		t.Errorf("Sign = %v, want ErrNoSigningKey", err)
+	}
+}
+
+func TestGenerateRejectsUnknownAlgorithm(t *testing.T) {
+	for _, algorithm := range []string{"HS256", "ES256", "none", ""} {
+		if _, err := Generate(openKeysTestDB(t), algorithm); err == nil {
+			t.Errorf("Generate(%q) succeeded", algorithm)
+		}
+	}
+}
+
+func TestRotation(t *testing.T) {
+	database := openKeysTestDB(t)
+	first := mustGenerate(t, database, AlgorithmRS256)
+	keyring, err := NewKeyring(database)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	oldToken := sign(t, keyring, "1")
+
+	// A new key signs after the next reload; tokens of the old key still verify
+	second := mustGenerate(t, database, AlgorithmEdDSA)
+	if err := keyring.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	newToken := sign(t, keyring, "1")
+	if kid := tokenKID(t, newToken); kid != second.KID {
+		t.Errorf("new token signed with %q, want the newest key %q", kid, second.KID)
+	}
+
+	tests := []struct {
+		step      string
+		apply     func() error
+		wantOld   bool
+		wantNew   bool
+		wantError bool
+	}{
+		{"both keys active", func() error { return nil }, true, true, false},
+		{"unknown key cannot be retired", func() error { return Retire(database, "0000000000000000", time.Hour) }, true, true, true},
+		{"old key retired with a grace period", func() error { return Retire(database, first.KID, time.Hour) }, true, true, false},
+		{"last active key cannot be retired", func() error { return Retire(database, second.KID, time.Hour) }, true, true, true},
+		{"old key past its grace period", func() error {
+			return database.Model(&models.SigningKey{}).Where("kid = ?", first.KID).Update("expires_at", time.Now().Add(-time.Minute)).Error
+		}, false, true, false},
+	}
+	for _, test := range tests {
+		err := test.apply()
+		if (err != nil) != test.wantError {
+			t.Fatalf("%s: error = %v, want error: %v", test.step, err, test.wantError)
+		}
+		if err := keyring.Reload(); err != nil {
+			t.Fatalf("%s: Reload: %v", test.step, err)
+		}
+		if _, err := verify(keyring, oldToken); (err == nil) != test.wantOld {
+			t.Errorf("%s: old token verifies: %v, want %v", test.step, err == nil, test.wantOld)
+		}
+		if _, err := verify(keyring, newToken); (err == nil) != test.wantNew {
+			t.Errorf("%s: new token verifies: %v, want %v", test.step, err == nil, test.wantNew)
+		}
+		if kid := tokenKID(t, sign(t, keyring, "1")); kid != second.KID {
+			t.Errorf("%s: signing with %q, want %q", test.step, kid, second.KID)
+		}
+	}
+}
+
+func TestKeyfuncRejects(t *testing.T) {
+	database := openKeysTestDB(t)
+	rsaKey := mustGenerate(t, database, AlgorithmRS256)
+	keyring, err := NewKeyring(database)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signWith := func(method jwt.SigningMethod, kid interface{}, key interface{}) string {
+		token := jwt.NewWithClaims(method, jwt.RegisteredClaims{Subject: "1"})
+		if kid != nil {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("signing token: %v", err)
+		}
+		return signed
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"no key ID", signWith(jwt.SigningMethodEdDSA, nil, edPrivate)},
+		{"key ID not a string", signWith(jwt.SigningMethodEdDSA, 7, edPrivate)},
+		{"unknown key ID", signWith(jwt.SigningMethodEdDSA, "ffffffffffffffff", edPrivate)},
+		{"other algorithm than the key's", signWith(jwt.SigningMethodEdDSA, rsaKey.KID, edPrivate)},
+		{"HMAC with the public key", signWith(jwt.SigningMethodHS256, rsaKey.KID, []byte("secret"))},
+		{"unsigned", signWith(jwt.SigningMethodNone, rsaKey.KID, jwt.UnsafeAllowNoneSignatureType)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := verify(keyring, test.token); err == nil {
+				t.Error("token was accepted")
+			}
+		})
+	}
+}
+
+func TestJWKSRoundTrip(t *testing.T) {
+	database := openKeysTestDB(t)
+	mustGenerate(t, database, AlgorithmRS256)
+	mustGenerate(t, database, AlgorithmEdDSA)
+	keyring, err := NewKeyring(database)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	token := sign(t, keyring, "1")
+
+	set := keyring.JWKS()
+	if len(set.Keys) != 2 {
+		t.Fatalf("JWKS has %d keys, want 2", len(set.Keys))
+	}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "sig" || jwk.KeyID == "" {
+			t.Errorf("key %+v lacks use or kid", jwk)
+		}
+		public, err := jwk.PublicKey()
+		if err != nil {
+			t.Fatalf("PublicKey of %s key: %v", jwk.KeyType, err)
+		}
+		// The published key verifies the tokens signed with the private key of the same kid
+		if jwk.KeyID == tokenKID(t, token) {
+			_, err := jwt.Parse(token, func(*jwt.Token) (interface{}, error) { return public, nil }, jwt.WithValidMethods([]string{jwk.Algorithm}))
+			if err != nil {
+				t.Errorf("token does not verify with the published key: %v", err)
+			}
+		}
+	}
+}
+
+func TestJWKPublicKey(t *testing.T) {
+	encode := base64.RawURLEncoding.EncodeToString
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecX, ecY := encode(ecKey.X.Bytes()), encode(ecKey.Y.Bytes())
+
+	tests := []struct {
+		name    string
+		jwk     JWK
+		wantErr bool
+	}{
+		{"RSA", JWK{KeyType: "RSA", N: encode(rsaKey.N.Bytes()), E: "AQAB"}, false},
+		{"RSA without exponent", JWK{KeyType: "RSA", N: encode(rsaKey.N.Bytes())}, true},
+		{"RSA with a bad modulus", JWK{KeyType: "RSA", N: "!!", E: "AQAB"}, true},
+		{"EC P-256", JWK{KeyType: "EC", Curve: "P-256", X: ecX, Y: ecY}, false},
+		{"EC on another curve", JWK{KeyType: "EC", Curve: "P-384", X: ecX, Y: ecY}, true},
+		{"EC point off the curve", JWK{KeyType: "EC", Curve: "P-256", X: ecX, Y: ecX}, true},
+		{"Ed25519", JWK{KeyType: "OKP", Curve: "Ed25519", X: encode(edPublic)}, false},
+		{"Ed25519 too short", JWK{KeyType: "OKP", Curve: "Ed25519", X: encode(edPublic[:16])}, true},
+		{"OKP on another curve", JWK{KeyType: "OKP", Curve: "X25519", X: encode(edPublic)}, true},
+		{"symmetric key", JWK{KeyType: "oct"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			public, err := test.jwk.PublicKey()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("PublicKey = %v, %v; want error: %v", public, err, test.wantErr)
+			}
+		})
+	}
+
+	public, err := JWK{KeyType: "RSA", N: encode(rsaKey.N.Bytes()), E: "AQAB"}.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rsaKey.PublicKey.Equal(public) {
+		t.Error("decoded RSA key differs from the original")
+	}
+}
@@ -0,0 +1,40 @@
+package mail
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// OutboxMailer writes every message as an .eml file to a local directory instead of sending it.
+// It is meant for development, where the files can be opened with any mail client.
+type OutboxMailer struct {
+	Dir  string // Directory the messages are written to
+	From string // Sender address
+}
+
+// NewOutboxMailer creates an OutboxMailer, creating the directory if needed.
+func NewOutboxMailer(dir, from string) (*OutboxMailer, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("error creating outbox directory: %w", err)
+	}
+	return &OutboxMailer{Dir: dir, From: from}, nil
+}
+
+// Send writes the message to a new file in the outbox directory.
+func (m *OutboxMailer) Send(msg Message) error {
+	// Name files by time so that they sort in the order they were sent
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return fmt.Errorf("error naming outbox message: %w", err)
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), hex.EncodeToString(suffix))
+
+	if err := os.WriteFile(filepath.Join(m.Dir, name), msg.format(m.From), 0o640); err != nil {
+		return fmt.Errorf("error writing outbox message: %w", err)
+	}
+	return nil
+}
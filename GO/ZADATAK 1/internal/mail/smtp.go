@@ -0,0 +1,31 @@
+package mail
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+)
+
+// SMTPMailer delivers messages through an SMTP server. STARTTLS is used when the server offers it;
+// credentials are only sent over TLS or to a server on localhost.
+type SMTPMailer struct {
+	Host     string // SMTP server host name
+	Port     string // SMTP server port
+	Username string // Optional user name for PLAIN authentication
+	Password string // Password for PLAIN authentication
+	From     string // Sender address
+}
+
+// Send delivers the message to the SMTP server.
+func (m *SMTPMailer) Send(msg Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	addr := net.JoinHostPort(m.Host, m.Port)
+	if err := smtp.SendMail(addr, auth, m.From, []string{msg.To}, msg.format(m.From)); err != nil {
+		return fmt.Errorf("error sending mail to %s: %w", msg.To, err)
+	}
+	return nil
+}
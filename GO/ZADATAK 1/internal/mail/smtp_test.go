@@ -0,0 +1,217 @@
+package mail
+
+import (
+	"encoding/base64"
+	"io"
+	"mime"
+	"net"
+	netmail "net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTP is an SMTP server on a local listener that records the envelope, credentials and data of
+// the messages it receives.
+type fakeSMTP struct {
+	listener    net.Listener
+	auth        bool   // Whether AUTH PLAIN is offered
+	rejectRcpt  bool   // Whether every recipient is refused
+	credentials string // Decoded AUTH PLAIN response
+	from        string
+	to          []string
+	data        string
+	done        chan struct{}
+}
+
+// newFakeSMTP starts a server that accepts a single session.
+func newFakeSMTP(t *testing.T, auth, rejectRcpt bool) *fakeSMTP {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeSMTP{listener: listener, auth: auth, rejectRcpt: rejectRcpt, done: make(chan struct{})}
+	t.Cleanup(func() { listener.Close() })
+	go server.serve(t)
+	return server
+}
+
+// mailer returns a mailer delivering to the server.
+func (s *fakeSMTP) mailer(username, password string) *SMTPMailer {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return &SMTPMailer{Host: host, Port: port, Username: username, Password: password, From: "notes@example.com"}
+}
+
+func (s *fakeSMTP) serve(t *testing.T) {
+	defer close(s.done)
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	text := textproto.NewConn(conn)
+	reply := func(code int, lines ...string) {
+		for i, line := range lines {
+			separator := "-"
+			if i == len(lines)-1 {
+				separator = " "
+			}
+			if err := text.PrintfLine("%d%s%s", code, separator, line); err != nil {
+				t.Errorf("writing reply: %v", err)
+			}
+		}
+	}
+
+	reply(220, "localhost ESMTP fake")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command, argument, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(command) {
+		case "EHLO":
+			if s.auth {
+				reply(250, "localhost", "AUTH PLAIN")
+			} else {
+				reply(250, "localhost")
+			}
+		case "AUTH":
+			mechanism, initial, _ := strings.Cut(argument, " ")
+			decoded, err := base64.StdEncoding.DecodeString(initial)
+			if mechanism != "PLAIN" || err != nil {
+				reply(504, "unsupported")
+				continue
+			}
+			s.credentials = string(decoded)
+			reply(235, "authenticated")
+		case "MAIL":
+			s.from = strings.TrimSuffix(strings.TrimPrefix(argument, "FROM:<"), ">")
+			reply(250, "ok")
+		case "RCPT":
+			if s.rejectRcpt {
+				reply(550, "no such user")
+				continue
+			}
+			s.to = append(s.to, strings.TrimSuffix(strings.TrimPrefix(argument, "TO:<"), ">"))
+			reply(250, "ok")
+		case "DATA":
+			reply(354, "go ahead")
+			data, err := io.ReadAll(text.DotReader())
+			if err != nil {
+				t.Errorf("reading data: %v", err)
+				return
+			}
+			s.data = string(data)
+			reply(250, "queued")
+		case "RSET", "NOOP":
+			reply(250, "ok")
+		case "QUIT":
+			reply(221, "bye")
+			return
+		default:
+			reply(502, "not implemented")
+		}
+	}
+}
+
+// wait waits until the session has ended.
+func (s *fakeSMTP) wait(t *testing.T) {
+	t.Helper()
+	select {
+	case <-s.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SMTP session did not end")
+	}
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	tests := []struct {
+		name            string
+		auth            bool
+		username        string
+		wantCredentials string
+		subject         string
+		body            string
+	}{
+		{"without authentication", false, "", "", "Verify your email address", "Open the link to verify your address.\n"},
+		{"with PLAIN authentication", true, "notes", "\x00notes\x00secret", "Reset your password", "Open the link to choose a new password.\n.\nA line of a single dot survives.\n"},
+		{"non-ASCII subject", false, "", "", "Potvrdite adresu, Đuro", "Pozdrav!\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeSMTP(t, test.auth, false)
+			msg := Message{To: "john@example.com", Subject: test.subject, Body: test.body}
+			if err := server.mailer(test.username, "secret").Send(msg); err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			server.wait(t)
+
+			if server.credentials != test.wantCredentials {
+				t.Errorf("credentials = %q, want %q", server.credentials, test.wantCredentials)
+			}
+			if server.from != "notes@example.com" || len(server.to) != 1 || server.to[0] != "john@example.com" {
+				t.Errorf("envelope from %q to %v", server.from, server.to)
+			}
+
+			received, err := netmail.ReadMessage(strings.NewReader(server.data))
+			if err != nil {
+				t.Fatalf("reading message: %v", err)
+			}
+			subject, err := new(mime.WordDecoder).DecodeHeader(received.Header.Get("Subject"))
+			if err != nil {
+				t.Fatalf("decoding subject: %v", err)
+			}
+			for name, want := range map[string]string{
+				"From":         "notes@example.com",
+				"To":           "john@example.com",
+				"Subject":      test.subject,
+				"MIME-Version": "1.0",
+				"Content-Type": "text/plain; charset=utf-8",
+			} {
+				got := received.Header.Get(name)
+				if name == "Subject" {
+					got = subject
+				}
+				if got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+			if date, err := received.Header.Date(); err != nil || time.Since(date) > time.Minute {
+				t.Errorf("Date = %q, %v", received.Header.Get("Date"), err)
+			}
+			// The DATA reader turns line endings into "\n" and removes the dot stuffing
+			body, err := io.ReadAll(received.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != test.body {
+				t.Errorf("body = %q, want %q", body, test.body)
+			}
+		})
+	}
+}
+
+func TestSMTPMailerErrors(t *testing.T) {
+	server := newFakeSMTP(t, false, true)
+	err := server.mailer("", "").Send(Message{To: "nobody@example.com", Subject: "Hello", Body: "Hello"})
+	if err == nil || !strings.Contains(err.Error(), "nobody@example.com") || !strings.Contains(err.Error(), "550") {
+		t.Errorf("Send to a refused recipient = %v, want the 550 reply for nobody@example.com", err)
+	}
+	server.wait(t)
+
+	// Nothing listens on a closed listener's port
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, _ := net.SplitHostPort(closed.Addr().String())
+	closed.Close()
+	if err := (&SMTPMailer{Host: host, Port: port, From: "notes@example.com"}).Send(Message{To: "john@example.com"}); err == nil {
+		t.Error("Send without a server succeeded")
+	}
+}
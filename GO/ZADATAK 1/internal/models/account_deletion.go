@@ -0,0 +1,75 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DeleteUserData permanently deletes a user together with everything stored about them: notes, including
+// soft-deleted ones, their tags and notebooks, cached notes, tokens, sessions, linked identities and the
+// OAuth clients they registered. The audit trail keeps its entries, which refer to the user by ID only.
+func DeleteUserData(db *gorm.DB, user User) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		userID := int(user.ID)
+		if err := DeleteUserTags(tx, user.ID); err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&Note{}).Error; err != nil {
+			return err
+		}
+		if err := DeleteUserNotebooks(tx, user.ID); err != nil {
+			return err
+		}
+		if err := DeleteUserNotesCache(tx, userID); err != nil {
+			return err
+		}
+
+		// Authorizations the user gave to apps, and authorizations of the apps the user registered
+		clients := tx.Model(&OAuthClient{}).Select("id").Where("owner_id = ?", user.ID)
+		grants := tx.Unscoped().Model(&OAuthGrant{}).Select("id").Where("user_id = ? OR client_id IN (?)", user.ID, clients)
+		if err := tx.Unscoped().Where("grant_id IN (?)", grants).Delete(&OAuthAuthorizationCode{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("grant_id IN (?)", grants).Delete(&OAuthRefreshToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("user_id = ? OR client_id IN (?)", user.ID, clients).Delete(&OAuthGrant{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("owner_id = ?", user.ID).Delete(&OAuthClient{}).Error; err != nil {
+			return err
+		}
+
+		for _, model := range []interface{}{
+			&RefreshToken{}, &RevokedToken{}, &Session{}, &OneTimeToken{}, &MagicLink{}, &RecoveryCode{}, &PersonalAccessToken{}, &ExternalIdentity{}, &Passkey{}, &WebAuthnChallenge{},
+		} {
+			if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		if err := ClearLoginThrottle(tx, UsernameThrottleKey(user.Username)); err != nil {
+			return err
+		}
+
+		if err := tx.Unscoped().Delete(&User{}, user.ID).Error; err != nil {
+			return err
+		}
+		return RecordAudit(tx, user.ID, "user.delete", &user.ID, "", nil)
+	})
+}
+
+// DeleteDueAccounts permanently deletes the accounts whose deletion grace period has ended, and returns
+// how many were deleted.
+func DeleteDueAccounts(db *gorm.DB) (int, error) {
+	var users []User
+	if err := db.Where("deletion_due_at IS NOT NULL AND deletion_due_at <= ?", time.Now()).Find(&users).Error; err != nil {
+		return 0, err
+	}
+	for i, user := range users {
+		if err := DeleteUserData(db, user); err != nil {
+			return i, err
+		}
+	}
+	return len(users), nil
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"encoding/json"
+
+	"gorm.io/gorm"
+)
+
+// AuditLog records an administrative action: who did what to whom, and from where.
+// Entries are only ever appended.
+type AuditLog struct {
+	gorm.Model          // Embeds ID, CreatedAt, UpdatedAt, and DeletedAt fields
+	ActorID      uint   `json:"actor_id" gorm:"not null;index"`        // User who performed the action
+	Action       string `json:"action" gorm:"not null;index"`          // What was done, such as "user.disable"
+	TargetUserID *uint  `json:"target_user_id,omitempty" gorm:"index"` // User the action was performed on, if any
+	IP           string `json:"ip"`                                    // Client IP address of the request
+	Details      string `json:"details,omitempty"`                     // Additional details as a JSON object
+}
+
+// RecordAudit appends an entry to the audit trail. The details are stored as a JSON object.
+func RecordAudit(db *gorm.DB, actorID uint, action string, targetUserID *uint, ip string, details map[string]interface{}) error {
+	entry := AuditLog{ActorID: actorID, Action: action, TargetUserID: targetUserID, IP: ip}
+	if len(details) > 0 {
+		encoded, err := json.Marshal(details)
+		if err != nil {
+			return err
+		}
+		entry.Details = string(encoded)
+	}
+	return db.Create(&entry).Error
+}
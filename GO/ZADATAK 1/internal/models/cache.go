@@ -11,16 +11,30 @@ import (
 	"gorm.io/gorm"
 )
 
-// Cache represents a cached entry in the database for user notes.
+// Cache represents a cached page of a user's notes in the database. Each distinct listing (sort order,
+// filters, cursor and page size) is cached under its own page key.
 type Cache struct {
 	gorm.Model           // Embeds ID, CreatedAt, UpdatedAt, and DeletedAt fields
-	UserID     int       `json:"user_id" gorm:"not null;index"` // The user ID associated with the cache
-	Notes      string    `json:"notes" gorm:"not null"`         // Cached notes data stored as JSON string
-	ExpiryDate time.Time `json:"expiry_date" gorm:"type:date"`  // Expiry date for the cache entry
+	UserID     int       `json:"user_id" gorm:"not null;index"`             // The user ID associated with the cache
+	PageKey    string    `json:"page_key" gorm:"not null;default:'';index"` // Normalized query of the cached page of notes
+	Notes      string    `json:"notes" gorm:"not null"`                     // Cached notes data stored as JSON string
+	ExpiryDate time.Time `json:"expiry_date" gorm:"type:date"`              // Expiry date for the cache entry
 }
 
-// In-memory cache map for user notes, indexed by user ID.
-var notesCache = make(map[int][]Note)
+// cacheTTL is how long a cached page of notes is used before it is read from the notes table again.
+const cacheTTL = 24 * time.Hour
+
+// maxCachedPages limits the pages cached per user; when it is reached, the user's cache starts over.
+const maxCachedPages = 100
+
+// cachedPage is a page of notes in the in-memory cache, used until it expires like its database entry.
+type cachedPage struct {
+	notes     []Note
+	expiresAt time.Time
+}
+
+// In-memory cache of pages of user notes, indexed by user ID and then by page key.
+var notesCache = make(map[int]map[string]cachedPage)
 
 // Mutex to ensure thread-safe access to the in-memory cache.
 var cacheMutex = sync.RWMutex{}
@@ -30,14 +44,20 @@ func AutoMigrateCache(db *gorm.DB) error {
 	return db.AutoMigrate(&Cache{})
 }
 
-// SaveNotes saves the provided notes to both the in-memory cache and the database.
-func SaveNotes(db *gorm.DB, userID int, notes []Note) error {
+// SaveNotes saves a page of notes to both the in-memory cache and the database.
+func SaveNotes(db *gorm.DB, userID int, pageKey string, notes []Note) error {
 	// Lock for writing to ensure thread-safe cache access
 	cacheMutex.Lock()
 	defer cacheMutex.Unlock()
 
-	// Save notes to in-memory cache
-	notesCache[userID] = notes
+	// Save notes to in-memory cache, starting over for users who browsed too many pages
+	pages := notesCache[userID]
+	if pages == nil || len(pages) >= maxCachedPages {
+		pages = make(map[string]cachedPage)
+		notesCache[userID] = pages
+	}
+	expiresAt := time.Now().UTC().Add(cacheTTL) // Stored in UTC so that the purge can compare it as text
+	pages[pageKey] = cachedPage{notes: notes, expiresAt: expiresAt}
 
 	// Marshal notes to JSON for database storage
 	notesData, err := json.Marshal(notes)
@@ -48,17 +68,28 @@ func SaveNotes(db *gorm.DB, userID int, notes []Note) error {
 	// Use a transaction to create or update the cache in the database
 	return db.Transaction(func(tx *gorm.DB) error {
 		var cache Cache
-		if err := tx.Where("user_id = ?", userID).First(&cache).Error; err == nil {
+		if err := tx.Where("user_id = ? AND page_key = ?", userID, pageKey).First(&cache).Error; err == nil {
 			// Update existing cache entry
 			cache.Notes = string(notesData)
-			cache.ExpiryDate = time.Now().Add(24 * time.Hour)
+			cache.ExpiryDate = expiresAt
 			return tx.Save(&cache).Error
 		} else if errors.Is(err, gorm.ErrRecordNotFound) {
+			var count int64
+			if err := tx.Model(&Cache{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count >= maxCachedPages {
+				if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&Cache{}).Error; err != nil {
+					return err
+				}
+			}
+
 			// Create new cache entry if none exists
 			cache = Cache{
 				UserID:     userID,
+				PageKey:    pageKey,
 				Notes:      string(notesData),
-				ExpiryDate: time.Now().Add(24 * time.Hour),
+				ExpiryDate: expiresAt,
 			}
 			return tx.Create(&cache).Error
 		} else {
@@ -67,22 +98,26 @@ func SaveNotes(db *gorm.DB, userID int, notes []Note) error {
 	})
 }
 
-// LoadNotes retrieves notes from in-memory cache or the database if not found.
-func LoadNotes(db *gorm.DB, userID int) ([]Note, error) {
+// LoadNotes retrieves a page of notes from in-memory cache or the database if not found.
+func LoadNotes(db *gorm.DB, userID int, pageKey string) ([]Note, error) {
 	// Lock for reading to safely access the cache
 	cacheMutex.RLock()
-	defer cacheMutex.RUnlock()
+	page, found := notesCache[userID][pageKey]
+	cacheMutex.RUnlock()
 
 	// Check the in-memory cache
-	if notes, found := notesCache[userID]; found {
-		return notes, nil
+	if found && time.Now().Before(page.expiresAt) {
+		return page.notes, nil
 	}
 
 	// Load notes from the database cache if not in memory
 	var cache Cache
-	if err := db.Where("user_id = ?", userID).First(&cache).Error; err != nil {
+	if err := db.Where("user_id = ? AND page_key = ?", userID, pageKey).First(&cache).Error; err != nil {
 		return nil, fmt.Errorf("error loading notes from database cache: %w", err)
 	}
+	if time.Now().After(cache.ExpiryDate) {
+		return nil, errors.New("cached notes have expired")
+	}
 
 	// Unmarshal JSON data from the cache
 	var notes []Note
@@ -90,8 +125,13 @@ func LoadNotes(db *gorm.DB, userID int) ([]Note, error) {
 		return nil, fmt.Errorf("error unmarshalling notes from cache: %w", err)
 	}
 
-	// Store the notes in-memory cache for future use
-	notesCache[userID] = notes
+	// Store the notes in-memory cache for future use; the write lock is needed to modify the map
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	if notesCache[userID] == nil {
+		notesCache[userID] = make(map[string]cachedPage)
+	}
+	notesCache[userID][pageKey] = cachedPage{notes: notes, expiresAt: cache.ExpiryDate}
 	return notes, nil
 }
 
@@ -101,12 +141,43 @@ func ClearNotesCache(db *gorm.DB) error {
 	defer cacheMutex.Unlock()
 
 	// Clear in-memory cache
-	notesCache = make(map[int][]Note)
+	notesCache = make(map[int]map[string]cachedPage)
 
 	// Delete all entries from the database cache
-	if err := db.Delete(&Cache{}).Error; err != nil {
+	if err := db.Session(&gorm.Session{AllowGlobalUpdate: true}).Unscoped().Delete(&Cache{}).Error; err != nil {
 		log.Printf("error clearing cache entries from database: %v", err)
 		return err
 	}
 	return nil
 }
+
+// DeleteUserNotesCache removes the cached notes of one user from memory and permanently deletes their cache entries.
+func DeleteUserNotesCache(db *gorm.DB, userID int) error {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+
+	delete(notesCache, userID)
+	return db.Unscoped().Where("user_id = ?", userID).Delete(&Cache{}).Error
+}
+
+// PurgeExpiredNotesCache drops the expired pages from the in-memory cache and permanently deletes the
+// expired cache entries, and returns how many entries were deleted.
+func PurgeExpiredNotesCache(db *gorm.DB) (int64, error) {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+
+	now := time.Now()
+	for userID, pages := range notesCache {
+		for pageKey, page := range pages {
+			if now.After(page.expiresAt) {
+				delete(pages, pageKey)
+			}
+		}
+		if len(pages) == 0 {
+			delete(notesCache, userID)
+		}
+	}
+
+	result := db.Unscoped().Where("expiry_date < ?", now.UTC()).Delete(&Cache{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// DateLayout is the format of dates in JSON (ISO 8601 calendar date).
+const DateLayout = "2006-01-02"
+
+// Date is a calendar date without a time of day, such as a date of birth. It is written as "2006-01-02"
+// in JSON and stored as midnight UTC; the zero Date is written as null and stored as NULL.
+type Date struct {
+	time.Time
+}
+
+// ParseDate parses a date in the "2006-01-02" format.
+func ParseDate(value string) (Date, error) {
+	parsed, err := time.Parse(DateLayout, value)
+	if err != nil {
+		return Date{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", value)
+	}
+	return Date{parsed}, nil
+}
+
+// String returns the date in the "2006-01-02" format, or an empty string for the zero Date.
+func (d Date) String() string {
+	if d.IsZero() {
+		return ""
+	}
+	return d.Format(DateLayout)
+}
+
+// MarshalJSON writes the date as "2006-01-02", or null for the zero Date.
+func (d Date) MarshalJSON() ([]byte, error) {
+	if d.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(d.Format(DateLayout))
+}
+
+// UnmarshalJSON reads a date in the "2006-01-02" format; null and "" give the zero Date.
+func (d *Date) UnmarshalJSON(data []byte) error {
+	var value *string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("invalid date, expected YYYY-MM-DD")
+	}
+	if value == nil || *value == "" {
+		*d = Date{}
+		return nil
+	}
+	parsed, err := ParseDate(*value)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// Value stores the date as midnight UTC, or NULL for the zero Date.
+func (d Date) Value() (driver.Value, error) {
+	if d.IsZero() {
+		return nil, nil
+	}
+	year, month, day := d.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC), nil
+}
+
+// Scan reads a stored date. Values written before dates had their own type may carry a time of day,
+// which is dropped.
+func (d *Date) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*d = Date{}
+	case time.Time:
+		*d = dateOf(v)
+	case string:
+		return d.scanString(v)
+	case []byte:
+		return d.scanString(string(v))
+	default:
+		return fmt.Errorf("cannot scan %T into a date", value)
+	}
+	return nil
+}
+
+// scanString reads a date stored as text.
+func (d *Date) scanString(value string) error {
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999-07:00", time.RFC3339Nano, "2006-01-02 15:04:05", DateLayout} {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			*d = dateOf(parsed)
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot scan %q into a date", value)
+}
+
+// GormDataType keeps dates in the datetime column they always had.
+func (Date) GormDataType() string {
+	return "datetime"
+}
+
+// dateOf returns the calendar date of a time; the zero time gives the zero Date.
+func dateOf(t time.Time) Date {
+	if t.IsZero() {
+		return Date{}
+	}
+	year, month, day := t.Date()
+	return Date{time.Date(year, month, day, 0, 0, 0, 0, time.UTC)}
+}
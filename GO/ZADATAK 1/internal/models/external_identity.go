@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ExternalIdentity links a user to an account at an external OpenID Connect provider,
+// identified by the provider's issuer and the subject it uses for the account.
+type ExternalIdentity struct {
+	gorm.Model            // Embeds ID, CreatedAt, UpdatedAt, and DeletedAt fields
+	UserID      uint      `json:"user_id" gorm:"not null;index"`                            // Linked local user
+	Issuer      string    `json:"issuer" gorm:"not null;uniqueIndex:idx_external_subject"`  // Issuer URL of the provider
+	Subject     string    `json:"subject" gorm:"not null;uniqueIndex:idx_external_subject"` // Stable account ID at the provider
+	Email       string    `json:"email"`                                                    // Email address reported by the provider
+	LastLoginAt time.Time `json:"last_login_at"`                                            // Most recent login through the provider
+}
+
+// OIDCLoginState holds what a login started at the provider needs when it comes back to the callback.
+// It is looked up by the hash of the state parameter and deleted when used.
+type OIDCLoginState struct {
+	gorm.Model             // Embeds ID, CreatedAt, UpdatedAt, and DeletedAt fields
+	StateHash    string    `json:"-" gorm:"not null;uniqueIndex"` // SHA-256 hash of the state parameter
+	Nonce        string    `json:"-" gorm:"not null"`             // Expected nonce claim of the ID token
+	CodeVerifier string    `json:"-" gorm:"not null"`             // PKCE verifier for the token exchange
+	SessionMode  string    `json:"-"`                             // Session mode the login was started with
+	ExpiresAt    time.Time `json:"expires_at" gorm:"not null;index"`
+}
+
+// PurgeExpiredOIDCLoginStates permanently deletes login states of logins that were never completed.
+func PurgeExpiredOIDCLoginStates(db *gorm.DB) (int64, error) {
+	result := db.Unscoped().Where("expires_at < ?", time.Now()).Delete(&OIDCLoginState{})
+	return result.RowsAffected, result.Error
+}
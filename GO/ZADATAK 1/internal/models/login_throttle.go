@@ -0,0 +1,52 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// LoginThrottle counts the recent failed login attempts for one username or one client IP address.
+// Once the free attempts are used up, further attempts are refused until BlockedUntil; the delay
+// doubles with every failure. Entries are stored so that they survive restarts.
+type LoginThrottle struct {
+	gorm.Model               // Embeds ID, CreatedAt, UpdatedAt, and DeletedAt fields
+	Key           string     `json:"key" gorm:"column:throttle_key;not null;uniqueIndex"` // "user:<username>" or "ip:<address>"
+	Failures      int        `json:"failures" gorm:"not null"`                            // Consecutive failures within the attempt window
+	LastFailureAt time.Time  `json:"last_failure_at" gorm:"index"`                        // Moment of the most recent failure
+	BlockedUntil  *time.Time `json:"blocked_until,omitempty"`                             // Attempts are refused until this moment
+}
+
+// UsernameThrottleKey returns the throttle key for attempts on the given username.
+func UsernameThrottleKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+// IPThrottleKey returns the throttle key for attempts from the given client IP address.
+func IPThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// ClearLoginThrottle forgets the failed attempts recorded under the given key.
+func ClearLoginThrottle(db *gorm.DB, key string) error {
+	return db.Unscoped().Where("throttle_key = ?", key).Delete(&LoginThrottle{}).Error
+}
+
+// UnlockUser lifts a lockout of the user and forgets the failed attempts on their username.
+func UnlockUser(db *gorm.DB, user User) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&User{}).Where("id = ?", user.ID).Update("locked_until", nil).Error; err != nil {
+			return err
+		}
+		return ClearLoginThrottle(tx, UsernameThrottleKey(user.Username))
+	})
+}
+
+// PurgeStaleLoginThrottles permanently deletes entries without failures since the given moment that no longer block.
+func PurgeStaleLoginThrottles(db *gorm.DB, before time.Time) (int64, error) {
+	result := db.Unscoped().
+		Where("last_failure_at < ? AND (blocked_until IS NULL OR blocked_until < ?)", before, time.Now()).
+		Delete(&LoginThrottle{})
+	return result.RowsAffected, result.Error
+}
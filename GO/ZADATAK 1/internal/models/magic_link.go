@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MagicLink is a passwordless login requested by email. The emailed link only works together with the
+// secret handed to the device that asked for it, so a link opened on another device cannot be used.
+// Only the hashes of the link token and of the device secret are stored.
+type MagicLink struct {
+	gorm.Model             // Embeds ID, CreatedAt, UpdatedAt, and DeletedAt fields
+	UserID      uint       `json:"user_id" gorm:"not null;index"`    // User the link logs in
+	TokenHash   string     `json:"-" gorm:"not null;uniqueIndex"`    // SHA-256 hash of the token in the emailed link
+	DeviceHash  string     `json:"-" gorm:"not null"`                // SHA-256 hash of the secret of the requesting device
+	DeviceName  string     `json:"device_name"`                      // Device name given with the request, shown in the session list
+	SessionMode string     `json:"-"`                                // Session mode the login was requested with
+	IP          string     `json:"ip"`                               // Client IP address of the request
+	ExpiresAt   time.Time  `json:"expires_at" gorm:"not null;index"` // Moment after which the link can no longer be used
+	UsedAt      *time.Time `json:"used_at,omitempty"`                // Set when the link has been used or superseded
+}
+
+// PurgeMagicLinks permanently deletes links requested before the given moment. Recent links are kept even
+// once used, because they count towards the rate limit of their address.
+func PurgeMagicLinks(db *gorm.DB, before time.Time) (int64, error) {
+	result := db.Unscoped().Where("created_at < ? AND expires_at < ?", before.UTC(), time.Now()).Delete(&MagicLink{})
+	return result.RowsAffected, result.Error
+}
@@ -7,12 +7,19 @@ import (
 )
 
 // Note represents a single note in the system with fields for tracking ownership,
-// content, creation and update times, and an optional soft delete timestamp.
+// content, creation and update times, and a soft delete timestamp. Deleting a note moves it to the
+// trash: GORM sets DeletedAt and leaves the note out of every query that is not Unscoped, until the
+// note is restored or permanently deleted. The fields of gorm.Model are declared here instead of
+// embedded, so that DeletedAt stays out of note responses; the trash shows it as deleted_at.
 type Note struct {
-	gorm.Model            // Embeds ID, CreatedAt, UpdatedAt, and DeletedAt (for soft delete support)
-	UserID     int        `json:"user_id" gorm:"not null;index" validate:"required"`               // Foreign key for user
-	User       User       `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"` // Foreign key reference with cascading delete and update
-	Title      string     `json:"title" gorm:"not null" validate:"required"`                       // Title of the note
-	Body       string     `json:"body" gorm:"not null" validate:"required"`                        // Content of the note
-	DeletedAt  *time.Time `json:"deleted_at,omitempty" gorm:"index" validate:"omitempty"`          // Nullable timestamp for soft delete; indexed for performance
+	ID         uint           `gorm:"primarykey"` // Primary key
+	CreatedAt  time.Time      // Set by GORM when the note is created
+	UpdatedAt  time.Time      // Set by GORM whenever the note is saved
+	DeletedAt  gorm.DeletedAt `json:"-" gorm:"index"`                                                                        // Soft delete timestamp, set when the note is moved to the trash
+	UserID     int            `json:"user_id" gorm:"not null;index"`                                                         // Foreign key for user; set from the authenticated principal
+	User       User           `json:"-" gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" validate:"-"` // Foreign key reference with cascading delete and update; never serialized
+	Title      string         `json:"title" gorm:"not null" validate:"required"`                                             // Title of the note
+	Body       string         `json:"body" gorm:"not null" validate:"required"`                                              // Content of the note
+	Tags       []Tag          `json:"tags" gorm:"many2many:note_tags;" validate:"max=20,dive"`                               // Tags of the note; on update, leaving tags out keeps them
+	NotebookID *uint          `json:"notebook_id" gorm:"index" validate:"-"`                                                 // Notebook the note is in; nil if unfiled. On update, leaving it out keeps it
 }
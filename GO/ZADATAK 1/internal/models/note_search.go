@@ -0,0 +1,158 @@
+package models
+
+import (
+	"errors"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ErrNoteSearchUnavailable is returned when SQLite was built without FTS5; build with -tags sqlite_fts5.
+var ErrNoteSearchUnavailable = errors.New("full-text search is not available; build with -tags sqlite_fts5")
+
+// ErrInvalidSearchQuery is returned for queries that are not valid FTS5 query syntax.
+var ErrInvalidSearchQuery = errors.New("invalid search query")
+
+// Weights of the title and body columns in the BM25 ranking; a match in the title counts more.
+const (
+	searchTitleWeight = 10.0
+	searchBodyWeight  = 1.0
+)
+
+// noteSearchTable is the FTS5 index over the titles and bodies of notes. It is an external-content table:
+// the text lives only in notes, and the triggers keep the index in sync with every insert, update and
+// delete, whichever code path makes them. Soft-deleted notes stay indexed and are filtered out by
+// SearchNotes, so that restoring a note needs no reindexing.
+const noteSearchTable = `CREATE VIRTUAL TABLE IF NOT EXISTS notes_fts USING fts5(title, body, content='notes', content_rowid='id', tokenize='unicode61 remove_diacritics 2')`
+
+// noteSearchTriggers keep notes_fts in sync with notes, by name.
+var noteSearchTriggers = []struct{ name, statement string }{
+	{"notes_fts_insert", `CREATE TRIGGER notes_fts_insert AFTER INSERT ON notes BEGIN
+		INSERT INTO notes_fts(rowid, title, body) VALUES (new.id, new.title, new.body);
+	END`},
+	{"notes_fts_delete", `CREATE TRIGGER notes_fts_delete AFTER DELETE ON notes BEGIN
+		INSERT INTO notes_fts(notes_fts, rowid, title, body) VALUES ('delete', old.id, old.title, old.body);
+	END`},
+	{"notes_fts_update", `CREATE TRIGGER notes_fts_update AFTER UPDATE OF title, body ON notes BEGIN
+		INSERT INTO notes_fts(notes_fts, rowid, title, body) VALUES ('delete', old.id, old.title, old.body);
+		INSERT INTO notes_fts(rowid, title, body) VALUES (new.id, new.title, new.body);
+	END`},
+}
+
+// NoteSearchResult is a note found by SearchNotes, with its rank and the matches marked.
+type NoteSearchResult struct {
+	Note
+	Rank           float64 `json:"rank"`            // BM25 score; lower is a better match
+	TitleHighlight string  `json:"title_highlight"` // Title with the matches between the markers
+	Snippet        string  `json:"snippet"`         // Excerpt of the body around the matches, between the markers
+}
+
+// SetupNoteSearch creates the full-text index of notes and its triggers if they do not exist yet, and
+// indexes the notes that are already stored. Without FTS5 it drops the triggers, which would make every
+// change to a note fail, and returns ErrNoteSearchUnavailable; the index is rebuilt once FTS5 is back.
+func SetupNoteSearch(db *gorm.DB) error {
+	var fts5 bool
+	if err := db.Raw("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5).Error; err != nil {
+		return err
+	}
+	if !fts5 {
+		for _, trigger := range noteSearchTriggers {
+			if err := db.Exec("DROP TRIGGER IF EXISTS " + trigger.name).Error; err != nil {
+				return err
+			}
+		}
+		return ErrNoteSearchUnavailable
+	}
+
+	names := []string{"notes_fts"}
+	for _, trigger := range noteSearchTriggers {
+		names = append(names, trigger.name)
+	}
+	var count int64
+	if err := db.Raw("SELECT COUNT(*) FROM sqlite_master WHERE name IN ?", names).Scan(&count).Error; err != nil {
+		return err
+	}
+	if count == int64(len(names)) {
+		return nil
+	}
+
+	// Missing triggers mean that notes may have changed without being indexed
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(noteSearchTable).Error; err != nil {
+			return err
+		}
+		for _, trigger := range noteSearchTriggers {
+			if err := tx.Exec("DROP TRIGGER IF EXISTS " + trigger.name).Error; err != nil {
+				return err
+			}
+			if err := tx.Exec(trigger.statement).Error; err != nil {
+				return err
+			}
+		}
+		return RebuildNoteSearchIndex(tx)
+	})
+	return searchError(err)
+}
+
+// RebuildNoteSearchIndex reindexes all notes, for example after the index was damaged or notes were
+// changed with triggers disabled.
+func RebuildNoteSearchIndex(db *gorm.DB) error {
+	return searchError(db.Exec("INSERT INTO notes_fts(notes_fts) VALUES ('rebuild')").Error)
+}
+
+// SearchNotes runs an FTS5 query over the user's non-deleted notes and returns the best matches first.
+// The query supports "phrases", prefix* terms, AND, OR, NOT, parentheses and column filters such as title:word.
+// Matches are put between the open and close markers, which are inserted into the text as they are.
+func SearchNotes(db *gorm.DB, userID int, query, open, close string, limit, offset int) ([]NoteSearchResult, error) {
+	results := []NoteSearchResult{}
+	err := db.Raw(`SELECT notes.*,
+			bm25(notes_fts, ?, ?) AS rank,
+			highlight(notes_fts, 0, ?, ?) AS title_highlight,
+			snippet(notes_fts, 1, ?, ?, '…', 24) AS snippet
+		FROM notes_fts JOIN notes ON notes.id = notes_fts.rowid
+		WHERE notes_fts MATCH ? AND notes.user_id = ? AND notes.deleted_at IS NULL
+		ORDER BY rank, notes.id
+		LIMIT ? OFFSET ?`,
+		searchTitleWeight, searchBodyWeight, open, close, open, close, query, userID, limit, offset).
+		Scan(&results).Error
+	if err != nil || len(results) == 0 {
+		return results, searchError(err)
+	}
+
+	// Raw queries do not preload associations, so the tags are loaded separately
+	ids := make([]uint, len(results))
+	for i := range results {
+		ids[i] = results[i].ID
+	}
+	var notes []Note
+	if err := db.Preload("Tags", func(tx *gorm.DB) *gorm.DB { return tx.Order("tags.name") }).Find(&notes, ids).Error; err != nil {
+		return nil, err
+	}
+	tags := make(map[uint][]Tag, len(notes))
+	for _, note := range notes {
+		tags[note.ID] = note.Tags
+	}
+	for i := range results {
+		results[i].Tags = tags[results[i].ID]
+		if results[i].Tags == nil {
+			results[i].Tags = []Tag{}
+		}
+	}
+	return results, nil
+}
+
+// searchError translates the SQLite errors of missing FTS5 support and of malformed queries.
+func searchError(err error) error {
+	if err == nil {
+		return nil
+	}
+	message := err.Error()
+	switch {
+	case strings.Contains(message, "no such module: fts5"), strings.Contains(message, "no such table: notes_fts"):
+		return ErrNoteSearchUnavailable
+	case strings.Contains(message, "fts5:"), strings.Contains(message, "unterminated string"), strings.Contains(message, "no such column"):
+		return ErrInvalidSearchQuery
+	default:
+		return err
+	}
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DeleteNotePermanently deletes a note for good, whether or not it is in the trash, together with its
+// links to tags. The search index follows through its triggers.
+func DeleteNotePermanently(db *gorm.DB, note Note) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM note_tags WHERE note_id = ?", note.ID).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&Note{}, note.ID).Error
+	})
+}
+
+// PurgeTrashedNotes permanently deletes the notes that were moved to the trash before the given moment,
+// and returns how many were deleted.
+func PurgeTrashedNotes(db *gorm.DB, before time.Time) (int64, error) {
+	before = before.UTC() // GORM stores deleted_at in UTC
+	var purged int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		trashed := tx.Unscoped().Model(&Note{}).Select("id").Where("deleted_at IS NOT NULL AND deleted_at < ?", before)
+		if err := tx.Exec("DELETE FROM note_tags WHERE note_id IN (?)", trashed).Error; err != nil {
+			return err
+		}
+		result := tx.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", before).Delete(&Note{})
+		purged = result.RowsAffected
+		return result.Error
+	})
+	return purged, err
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// Notebook is a folder for notes. Notebooks belong to one user and can be nested: a notebook without a
+// parent is at the top level. Notes point to the notebook they are in; notes without one are unfiled.
+type Notebook struct {
+	gorm.Model        // Embeds ID, CreatedAt, UpdatedAt, and DeletedAt fields
+	UserID     uint   `json:"-" gorm:"not null;index"`                          // Owner of the notebook
+	ParentID   *uint  `json:"parent_id" gorm:"index"`                           // Notebook this one is nested in; nil at the top level
+	Name       string `json:"name" gorm:"not null" validate:"required,max=100"` // Name shown to the user
+}
+
+// NotebookSubtreeIDs returns the ID of the notebook and the IDs of all notebooks nested in it, at any depth.
+func NotebookSubtreeIDs(db *gorm.DB, notebookID uint) ([]uint, error) {
+	ids := []uint{}
+	err := db.Raw(`WITH RECURSIVE subtree(id) AS (
+			SELECT ?
+			UNION
+			SELECT notebooks.id FROM notebooks JOIN subtree ON notebooks.parent_id = subtree.id
+			WHERE notebooks.deleted_at IS NULL
+		)
+		SELECT id FROM subtree`, notebookID).Scan(&ids).Error
+	return ids, err
+}
+
+// DeleteUserNotebooks permanently deletes the notebooks of a user.
+func DeleteUserNotebooks(db *gorm.DB, userID uint) error {
+	return db.Unscoped().Where("user_id = ?", userID).Delete(&Notebook{}).Error
+}
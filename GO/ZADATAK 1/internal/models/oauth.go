@@ -0,0 +1,103 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// OAuthClient is a third-party application registered to access users' notes through OAuth2.
+// Confidential clients authenticate at the token endpoint with a secret, of which only the hash is
+// stored; public clients (single-page and mobile apps) have no secret and rely on PKCE alone.
+type OAuthClient struct {
+	gorm.Model          // Embeds ID, CreatedAt, UpdatedAt, and DeletedAt fields
+	ClientID     string `json:"client_id" gorm:"not null;uniqueIndex"` // Public identifier sent in OAuth requests
+	SecretHash   string `json:"-"`                                     // SHA-256 hash of the client secret; empty for public clients
+	Name         string `json:"name" gorm:"not null"`                  // Name shown to users on the consent screen
+	RedirectURIs string `json:"-" gorm:"not null"`                     // Space-separated list of allowed redirect URIs
+	Scopes       string `json:"-" gorm:"not null"`                     // Space-separated list of scopes the client may request
+	OwnerID      uint   `json:"owner_id" gorm:"not null;index"`        // User who registered the client
+}
+
+// RedirectURIList returns the allowed redirect URIs as a slice.
+func (c OAuthClient) RedirectURIList() []string {
+	return strings.Fields(c.RedirectURIs)
+}
+
+// ScopeList returns the scopes the client may request as a slice.
+func (c OAuthClient) ScopeList() []string {
+	return strings.Fields(c.Scopes)
+}
+
+// IsConfidential reports whether the client authenticates with a secret.
+func (c OAuthClient) IsConfidential() bool {
+	return c.SecretHash != ""
+}
+
+// OAuthGrant records that a user authorized a client for a set of scopes. Access tokens carry the ID
+// of their grant, and every token of the grant stops working when the user revokes it.
+type OAuthGrant struct {
+	gorm.Model            // Embeds ID, CreatedAt, UpdatedAt, and DeletedAt fields
+	UserID     uint       `json:"user_id" gorm:"not null;index"`     // User who authorized the client
+	ClientID   uint       `json:"client_id" gorm:"not null;index"`   // Authorized client
+	Scopes     string     `json:"-" gorm:"not null"`                 // Space-separated list of authorized scopes
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`            // When the client last used one of the grant's tokens
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" gorm:"index"` // Set when the user revoked the authorization
+}
+
+// ScopeList returns the authorized scopes as a slice.
+func (g OAuthGrant) ScopeList() []string {
+	return strings.Fields(g.Scopes)
+}
+
+// OAuthAuthorizationCode is a single-use code handed to a client after the user approved it, which the
+// client redeems at the token endpoint together with the PKCE verifier.
+type OAuthAuthorizationCode struct {
+	gorm.Model               // Embeds ID, CreatedAt, UpdatedAt, and DeletedAt fields
+	CodeHash      string     `json:"-" gorm:"not null;uniqueIndex"` // SHA-256 hash of the code
+	GrantID       uint       `json:"grant_id" gorm:"not null"`      // Grant the code was issued for
+	RedirectURI   string     `json:"-" gorm:"not null"`             // Redirect URI of the authorization request, checked again on redemption
+	Scopes        string     `json:"-" gorm:"not null"`             // Space-separated list of scopes of the tokens to issue
+	CodeChallenge string     `json:"-" gorm:"not null"`             // S256 PKCE challenge
+	ExpiresAt     time.Time  `json:"expires_at" gorm:"not null;index"`
+	UsedAt        *time.Time `json:"used_at,omitempty"` // Set when the code was redeemed
+}
+
+// OAuthRefreshToken is a refresh token issued to a client. Like the refresh tokens of logins, each one is
+// used once; presenting a used token again revokes the grant.
+type OAuthRefreshToken struct {
+	gorm.Model            // Embeds ID, CreatedAt, UpdatedAt, and DeletedAt fields
+	GrantID    uint       `json:"grant_id" gorm:"not null;index"`    // Grant the token belongs to
+	TokenHash  string     `json:"-" gorm:"not null;uniqueIndex"`     // SHA-256 hash of the token value
+	Scopes     string     `json:"-" gorm:"not null"`                 // Space-separated list of scopes of the tokens to issue
+	ExpiresAt  time.Time  `json:"expires_at" gorm:"not null;index"`  // Moment after which the token can no longer be used
+	UsedAt     *time.Time `json:"used_at,omitempty"`                 // Set when the token has been exchanged
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" gorm:"index"` // Set when the grant has been revoked
+}
+
+// RevokeOAuthGrant revokes an authorization together with its refresh tokens. Its access tokens are
+// rejected from the next request on.
+func RevokeOAuthGrant(db *gorm.DB, grantID uint) error {
+	now := time.Now()
+	if err := db.Model(&OAuthRefreshToken{}).
+		Where("grant_id = ? AND revoked_at IS NULL", grantID).
+		Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+	return db.Model(&OAuthGrant{}).
+		Where("id = ? AND revoked_at IS NULL", grantID).
+		Update("revoked_at", now).Error
+}
+
+// PurgeEndedOAuthTokens permanently deletes authorization codes and refresh tokens that can no longer be used.
+// Used codes and tokens are kept until they expire, so that presenting them again is still recognised.
+func PurgeEndedOAuthTokens(db *gorm.DB) (int64, error) {
+	now := time.Now()
+	codes := db.Unscoped().Where("expires_at < ?", now).Delete(&OAuthAuthorizationCode{})
+	if codes.Error != nil {
+		return 0, codes.Error
+	}
+	tokens := db.Unscoped().Where("expires_at < ? OR revoked_at IS NOT NULL", now).Delete(&OAuthRefreshToken{})
+	return codes.RowsAffected + tokens.RowsAffected, tokens.Error
+}
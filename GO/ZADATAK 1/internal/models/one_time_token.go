@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Purposes of one-time tokens. A token can only be redeemed for the purpose it was issued for.
+const (
+	PurposePasswordReset     = "password_reset"
+	PurposeEmailVerification = "email_verification"
+	PurposeAccountUnlock     = "account_unlock"
+	PurposeEmailChange       = "email_change"
+)
+
+// OneTimeToken is a single-use, time-limited token sent to a user by email, such as a password reset link.
+// Only the hash of the token is stored.
+type OneTimeToken struct {
+	gorm.Model            // Embeds ID, CreatedAt, UpdatedAt, and DeletedAt fields
+	UserID     uint       `json:"user_id" gorm:"not null;index"` // User the token was issued to
+	Purpose    string     `json:"purpose" gorm:"not null;index"` // What the token may be used for
+	TokenHash  string     `json:"-" gorm:"not null;uniqueIndex"` // SHA-256 hash of the token value
+	ExpiresAt  time.Time  `json:"expires_at" gorm:"not null"`    // Moment after which the token can no longer be used
+	UsedAt     *time.Time `json:"used_at,omitempty"`             // Set when the token has been redeemed or superseded
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Passkey is a WebAuthn credential registered by a user. A user can register several, one per
+// authenticator, and use them either to log in without a password or as a second factor.
+type Passkey struct {
+	gorm.Model              // Embeds ID, CreatedAt, UpdatedAt, and DeletedAt fields
+	UserID       uint       `json:"-" gorm:"not null;index"`                   // Owner of the credential
+	CredentialID string     `json:"credential_id" gorm:"not null;uniqueIndex"` // Base64url credential ID chosen by the authenticator
+	PublicKey    []byte     `json:"-" gorm:"not null"`                         // COSE-encoded public key of the credential
+	Algorithm    int        `json:"algorithm"`                                 // COSE algorithm of the public key, e.g. -7 for ES256
+	SignCount    uint32     `json:"-"`                                         // Last signature counter reported, used to detect cloned authenticators
+	AAGUID       string     `json:"aaguid,omitempty"`                          // Hex-encoded model of the authenticator, if disclosed
+	Transports   string     `json:"-"`                                         // Space-separated transports reported at registration
+	Name         string     `json:"name" gorm:"not null"`                      // Name chosen by the user, e.g. "work laptop"
+	BackedUp     bool       `json:"backed_up"`                                 // Whether the credential is synced to other devices
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty"`                    // When the passkey was last used to log in
+}
+
+// TransportList returns the reported transports as a slice.
+func (p Passkey) TransportList() []string {
+	return strings.Fields(p.Transports)
+}
+
+// WebAuthnChallenge is an outstanding challenge of a WebAuthn ceremony. It is deleted when the ceremony
+// finishes, so that every challenge can be answered only once. Only its hash is stored.
+type WebAuthnChallenge struct {
+	gorm.Model              // Embeds ID, CreatedAt, UpdatedAt, and DeletedAt fields
+	UserID        uint      `gorm:"not null;index"`       // User of the ceremony; 0 for a login that does not know the user yet
+	Purpose       string    `gorm:"not null"`             // "registration", "login" or "second_factor"
+	ChallengeHash string    `gorm:"not null;uniqueIndex"` // SHA-256 hash of the challenge
+	ExpiresAt     time.Time `gorm:"not null;index"`       // Moment after which the challenge can no longer be answered
+}
+
+// PurgeExpiredWebAuthnChallenges permanently deletes challenges that can no longer be answered.
+func PurgeExpiredWebAuthnChallenges(db *gorm.DB) (int64, error) {
+	result := db.Unscoped().Where("expires_at < ?", time.Now()).Delete(&WebAuthnChallenge{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PersonalAccessToken is a long-lived, scoped token that scripts and integrations use instead of a password.
+// Only the hash of the token is stored; the prefix is kept so that users can recognise their tokens.
+type PersonalAccessToken struct {
+	gorm.Model            // Embeds ID, CreatedAt, UpdatedAt, and DeletedAt fields
+	UserID     uint       `json:"user_id" gorm:"not null;index"`     // Owner of the token
+	Name       string     `json:"name" gorm:"not null"`              // Name chosen by the user, e.g. "backup script"
+	Prefix     string     `json:"prefix" gorm:"not null"`            // First characters of the token, for display
+	TokenHash  string     `json:"-" gorm:"not null;uniqueIndex"`     // SHA-256 hash of the token value
+	Scopes     string     `json:"-" gorm:"not null"`                 // Space-separated list of granted scopes
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`              // Optional expiry; nil means the token does not expire
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`            // When the token was last used
+	LastUsedIP string     `json:"last_used_ip,omitempty"`            // IP address the token was last used from
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" gorm:"index"` // Set when the token has been revoked
+}
+
+// ScopeList returns the granted scopes as a slice.
+func (t PersonalAccessToken) ScopeList() []string {
+	return strings.Fields(t.Scopes)
+}
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RecoveryCode is a one-time code that replaces a TOTP code when the user has lost their authenticator.
+// Only the hash of the code is stored.
+type RecoveryCode struct {
+	gorm.Model            // Embeds ID, CreatedAt, UpdatedAt, and DeletedAt fields
+	UserID     uint       `json:"user_id" gorm:"not null;index"` // Owner of the code
+	CodeHash   string     `json:"-" gorm:"not null;index"`       // SHA-256 hash of the normalised code
+	UsedAt     *time.Time `json:"used_at,omitempty"`             // Set when the code has been used
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RefreshToken represents a single refresh token handed out to a client. Only the hash of the
+// token is stored; the plaintext value is returned to the client exactly once.
+// Every token belongs to a family that starts at login and is carried over on each rotation,
+// so that the whole chain can be revoked when an already-used token is presented again.
+type RefreshToken struct {
+	gorm.Model            // Embeds ID, CreatedAt, UpdatedAt, and DeletedAt fields
+	UserID     uint       `json:"user_id" gorm:"not null;index"`     // Owner of the token
+	FamilyID   string     `json:"family_id" gorm:"not null;index"`   // Identifier shared by all rotations of one login
+	TokenHash  string     `json:"-" gorm:"not null;uniqueIndex"`     // SHA-256 hash of the token value
+	ExpiresAt  time.Time  `json:"expires_at" gorm:"not null"`        // Moment after which the token can no longer be used
+	UsedAt     *time.Time `json:"used_at,omitempty"`                 // Set when the token has been exchanged for a new pair
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" gorm:"index"` // Set when the token (or its family) has been revoked
+}
+
+// RevokeRefreshTokenFamily revokes every token that belongs to the given family and ends its session.
+func RevokeRefreshTokenFamily(db *gorm.DB, familyID string) error {
+	now := time.Now()
+	if err := db.Model(&RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+	return db.Model(&Session{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", now).Error
+}
+
+// RevokeOtherSessions ends every session of the user except the given one, together with their refresh tokens.
+func RevokeOtherSessions(db *gorm.DB, userID, keepSessionID uint) error {
+	now := time.Now()
+	others := db.Model(&Session{}).Select("family_id").Where("user_id = ? AND id <> ?", userID, keepSessionID)
+	if err := db.Model(&RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL AND family_id IN (?)", userID, others).
+		Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+	return db.Model(&Session{}).
+		Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, keepSessionID).
+		Update("revoked_at", now).Error
+}
+
+// RevokeUserRefreshTokens revokes every refresh token issued to the given user and ends all their sessions.
+func RevokeUserRefreshTokens(db *gorm.DB, userID uint) error {
+	now := time.Now()
+	if err := db.Model(&RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+	return db.Model(&Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", now).Error
+}
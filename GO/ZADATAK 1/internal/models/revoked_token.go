@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RevokedToken records an access token that was revoked before its expiry, identified by its jti claim.
+// Entries are only needed until the token would have expired anyway, after which they can be purged.
+type RevokedToken struct {
+	gorm.Model           // Embeds ID, CreatedAt, UpdatedAt, and DeletedAt fields
+	JTI        string    `json:"jti" gorm:"not null;uniqueIndex"`  // Unique identifier of the revoked token
+	UserID     uint      `json:"user_id" gorm:"not null;index"`    // Owner of the revoked token
+	ExpiresAt  time.Time `json:"expires_at" gorm:"not null;index"` // Expiry of the revoked token; the entry is purged afterwards
+}
+
+// RevokeToken adds the token with the given jti to the revocation store. Revoking a token twice is a no-op.
+func RevokeToken(db *gorm.DB, jti string, userID uint, expiresAt time.Time) error {
+	revoked := RevokedToken{JTI: jti, UserID: userID, ExpiresAt: expiresAt}
+	return db.Where(RevokedToken{JTI: jti}).FirstOrCreate(&revoked).Error
+}
+
+// IsTokenRevoked reports whether the token with the given jti has been revoked.
+func IsTokenRevoked(db *gorm.DB, jti string) (bool, error) {
+	var count int64
+	if err := db.Model(&RevokedToken{}).Where("jti = ?", jti).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// PurgeExpiredRevokedTokens permanently deletes revocation entries for tokens that have already expired.
+func PurgeExpiredRevokedTokens(db *gorm.DB) (int64, error) {
+	result := db.Unscoped().Where("expires_at < ?", time.Now()).Delete(&RevokedToken{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Names of the built-in roles. Every new user gets RoleUser; RoleAdmin holds every permission.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Permissions that can be granted to a role.
+const (
+	PermissionUsersRead        = "users:read"        // List, search and view users
+	PermissionUsersManage      = "users:manage"      // Disable and enable accounts, force password resets
+	PermissionUsersImpersonate = "users:impersonate" // Act as another user for support
+	PermissionRolesManage      = "roles:manage"      // Create, change and assign roles
+	PermissionAuditRead        = "audit:read"        // Read the audit trail
+	PermissionAll              = "*"                 // Every permission, including ones added later
+)
+
+// Permissions lists every permission that can be granted individually.
+var Permissions = []string{
+	PermissionUsersRead,
+	PermissionUsersManage,
+	PermissionUsersImpersonate,
+	PermissionRolesManage,
+	PermissionAuditRead,
+}
+
+// Role is a named set of permissions. Users reference their role by name.
+type Role struct {
+	gorm.Model         // Embeds ID, CreatedAt, UpdatedAt, and DeletedAt fields
+	Name        string `json:"name" gorm:"not null;uniqueIndex"` // Unique name, stored on the user
+	Description string `json:"description"`                      // What the role is for
+	Permissions string `json:"-" gorm:"not null"`                // Space-separated permissions
+	BuiltIn     bool   `json:"built_in"`                         // Built-in roles cannot be deleted or renamed
+}
+
+// PermissionList returns the permissions of the role.
+func (r Role) PermissionList() []string {
+	return strings.Fields(r.Permissions)
+}
+
+// HasPermission reports whether the role grants the permission.
+func (r Role) HasPermission(permission string) bool {
+	for _, granted := range r.PermissionList() {
+		if granted == permission || granted == PermissionAll {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPermission reports whether the value is a known permission.
+func IsPermission(value string) bool {
+	if value == PermissionAll {
+		return true
+	}
+	for _, permission := range Permissions {
+		if permission == value {
+			return true
+		}
+	}
+	return false
+}
+
+// EnsureBuiltInRoles creates the built-in roles if they do not exist yet.
+func EnsureBuiltInRoles(db *gorm.DB) error {
+	builtIn := []Role{
+		{Name: RoleUser, Description: "Regular user", Permissions: "", BuiltIn: true},
+		{Name: RoleAdmin, Description: "Administrator with every permission", Permissions: PermissionAll, BuiltIn: true},
+	}
+	for _, role := range builtIn {
+		if err := db.Where(Role{Name: role.Name}).FirstOrCreate(&role).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Session is one login of a user on a device. It lives as long as its refresh token family: every
+// refresh extends it, and revoking the family ends it. Access tokens carry the session ID in their
+// "sid" claim, so they stop working as soon as the session is revoked.
+//
+// Browser sessions are identified by a session cookie instead of tokens. They have a family without
+// refresh tokens and are extended by every request instead of by refreshes.
+type Session struct {
+	gorm.Model            // Embeds ID, CreatedAt, UpdatedAt, and DeletedAt fields
+	UserID     uint       `json:"user_id" gorm:"not null;index"`     // Owner of the session
+	FamilyID   string     `json:"-" gorm:"not null;uniqueIndex"`     // Refresh token family of the login
+	DeviceName string     `json:"device_name"`                       // Name given at login, or derived from the user agent
+	UserAgent  string     `json:"user_agent"`                        // User-Agent header at login
+	IP         string     `json:"ip"`                                // Client IP address at login
+	LastSeenAt time.Time  `json:"last_seen_at"`                      // Last request made with the session, updated at most once a minute
+	LastSeenIP string     `json:"last_seen_ip"`                      // Client IP address of that request
+	ExpiresAt  time.Time  `json:"expires_at" gorm:"not null;index"`  // Expiry of the newest refresh token of the session
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" gorm:"index"` // Set when the session was ended
+	CookieHash string     `json:"-" gorm:"index"`                    // SHA-256 hash of the session cookie; empty for token sessions
+	CSRFToken  string     `json:"-"`                                 // Token that state-changing requests of a browser session must send
+}
+
+// IsBrowserSession reports whether the session is identified by a session cookie.
+func (s Session) IsBrowserSession() bool {
+	return s.CookieHash != ""
+}
+
+// PurgeEndedSessions permanently deletes sessions that have expired or were revoked.
+func PurgeEndedSessions(db *gorm.DB) (int64, error) {
+	result := db.Unscoped().Where("expires_at < ? OR revoked_at IS NOT NULL", time.Now()).Delete(&Session{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SigningKey represents an asymmetric key pair used to sign and verify JWTs.
+// The newest key that has not been retired signs new tokens; every key that has not yet
+// expired is still accepted for verification, which lets old tokens live through a rotation.
+type SigningKey struct {
+	gorm.Model            // Embeds ID, CreatedAt, UpdatedAt, and DeletedAt fields
+	KID        string     `json:"kid" gorm:"column:kid;not null;uniqueIndex"` // Key ID placed in the "kid" header of signed tokens
+	Algorithm  string     `json:"alg" gorm:"not null"`                        // JWS algorithm of the key ("RS256" or "EdDSA")
+	PrivateKey string     `json:"-" gorm:"not null"`                          // PKCS #8 private key in PEM format
+	RetiredAt  *time.Time `json:"retired_at,omitempty"`                       // Set when the key stopped signing new tokens
+	ExpiresAt  *time.Time `json:"expires_at,omitempty" gorm:"index"`          // Set to the moment the key stops verifying tokens
+}
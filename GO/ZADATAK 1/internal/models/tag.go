@@ -0,0 +1,74 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Tag is a label a user puts on their notes. Tags belong to one user, and their names are unique per
+// user. In JSON a tag is just its name, so notes carry "tags": ["work", "ideas"].
+type Tag struct {
+	gorm.Model        // Embeds ID, CreatedAt, UpdatedAt, and DeletedAt fields
+	UserID     uint   `gorm:"not null;uniqueIndex:idx_tags_user_name"`                            // Owner of the tag
+	Name       string `gorm:"not null;uniqueIndex:idx_tags_user_name" validate:"required,max=50"` // Normalized name, see NormalizeTagName
+}
+
+// NormalizeTagName trims a tag name, collapses inner whitespace and lowercases it, so that "Work" and
+// " work " are the same tag.
+func NormalizeTagName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// MarshalJSON encodes a tag as its name.
+func (t Tag) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.Name)
+}
+
+// UnmarshalJSON decodes a tag from its name, normalized.
+func (t *Tag) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	*t = Tag{Name: NormalizeTagName(name)}
+	return nil
+}
+
+// TagNames returns the names of the tags.
+func TagNames(tags []Tag) []string {
+	names := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		names = append(names, tag.Name)
+	}
+	return names
+}
+
+// FindOrCreateTags returns the user's tags with the given normalized names, creating the missing ones.
+// Duplicate names are returned once.
+func FindOrCreateTags(db *gorm.DB, userID uint, names []string) ([]Tag, error) {
+	tags := make([]Tag, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		tag := Tag{UserID: userID, Name: name}
+		if err := db.Where(Tag{UserID: userID, Name: name}).FirstOrCreate(&tag).Error; err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// DeleteUserTags permanently deletes the tags of a user and their links to notes.
+func DeleteUserTags(db *gorm.DB, userID uint) error {
+	if err := db.Exec("DELETE FROM note_tags WHERE tag_id IN (SELECT id FROM tags WHERE user_id = ?)", userID).Error; err != nil {
+		return err
+	}
+	return db.Unscoped().Where("user_id = ?", userID).Delete(&Tag{}).Error
+}
@@ -10,16 +10,27 @@ import (
 
 // User represents a user account in the system with detailed personal, address, and account information.
 type User struct {
-	gorm.Model            // Embeds fields like ID, CreatedAt, UpdatedAt, and DeletedAt (for soft delete support)
-	Username    string    `json:"username" gorm:"unique;not null" validate:"required,min=3,max=32"`
-	Password    string    `json:"password" gorm:"not null" validate:"required,min=8"`
-	FirstName   string    `json:"first_name" gorm:"not null" validate:"required"`
-	LastName    string    `json:"last_name" gorm:"not null" validate:"required"`
-	Email       string    `json:"email" gorm:"unique;not null" validate:"required,email"`
-	PhoneNumber string    `json:"phone_number,omitempty" gorm:"type:varchar(20)" validate:"omitempty"` // Changed: removed "gorm:\"-\""
-	City        string    `json:"city,omitempty" gorm:"type:varchar(100)" validate:"omitempty"`        // Changed: removed "gorm:\"-\""
-	Country     string    `json:"country,omitempty" gorm:"type:varchar(100)" validate:"omitempty"`     // Changed: removed "gorm:\"-\""
-	DateOfBirth time.Time `json:"dateOfBirth" validate:"omitempty,datetime=2006-01-02" example:"2006-01-02"`
+	gorm.Model                   // Embeds fields like ID, CreatedAt, UpdatedAt, and DeletedAt (for soft delete support)
+	Username          string     `json:"username" gorm:"unique;not null" validate:"required,min=3,max=32"`
+	Password          string     `json:"password" gorm:"not null" validate:"required"`
+	FirstName         string     `json:"first_name" gorm:"not null" validate:"required"`
+	LastName          string     `json:"last_name" gorm:"not null" validate:"required"`
+	Email             string     `json:"email" gorm:"unique;not null" validate:"required,email"`
+	PhoneNumber       string     `json:"phone_number,omitempty" gorm:"type:varchar(20)" validate:"omitempty"` // Changed: removed "gorm:\"-\""
+	City              string     `json:"city,omitempty" gorm:"type:varchar(100)" validate:"omitempty"`        // Changed: removed "gorm:\"-\""
+	Country           string     `json:"country,omitempty" gorm:"type:varchar(100)" validate:"omitempty"`     // Changed: removed "gorm:\"-\""
+	DateOfBirth       Date       `json:"dateOfBirth" validate:"-" example:"2006-01-02"`                       // Calendar date in the YYYY-MM-DD format
+	EmailVerifiedAt   *time.Time `json:"email_verified_at,omitempty" gorm:"column:email_verified_at"`         // Set once the user confirmed the address; nil until then
+	TOTPSecret        string     `json:"-" gorm:"column:totp_secret"`                                         // Base32 TOTP secret; pending until TOTPEnabledAt is set
+	TOTPEnabledAt     *time.Time `json:"-" gorm:"column:totp_enabled_at"`                                     // Set once two-factor authentication was confirmed; shown as two_factor_enabled in the profile
+	TOTPLastCounter   int64      `json:"-" gorm:"column:totp_last_counter"`                                   // Time step of the last accepted code, to prevent replays
+	TokensRevokedAt   *time.Time `json:"-"`                                                                   // Access tokens issued before this moment are no longer accepted
+	LockedUntil       *time.Time `json:"-" gorm:"index"`                                                      // Logins are refused until this moment after too many failed attempts
+	Role              string     `json:"role" gorm:"not null;default:user;index"`                             // Name of the user's role, which decides their permissions
+	DisabledAt        *time.Time `json:"disabled_at,omitempty"`                                               // Set while an administrator has disabled the account
+	MustResetPassword bool       `json:"-"`                                                                   // Set when an administrator forced a password reset
+	PendingEmail      string     `json:"-"`                                                                   // New address waiting for confirmation through POST /me/email; shown as pending_email in the profile
+	DeletionDueAt     *time.Time `json:"deletion_due_at,omitempty" gorm:"index"`                              // The account and its data are permanently deleted at this moment unless cancelled
 }
 
 // ValidateUser validates user data before registration or login.
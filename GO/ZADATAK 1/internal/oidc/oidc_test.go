@@ -0,0 +1,364 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"zadatak-filip-janjesic/internal/keys"
+	"zadatak-filip-janjesic/internal/oidc/oidctest"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestCodeChallengeS256(t *testing.T) {
+	// The example of RFC 7636, appendix B
+	if got := CodeChallengeS256("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"); got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("CodeChallengeS256 = %s", got)
+	}
+
+	verifier, err := NewCodeVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewCodeVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(verifier) != 43 || verifier == other {
+		t.Errorf("verifiers %q and %q are not random 43-character strings", verifier, other)
+	}
+}
+
+// testIssuer serves a discovery document and a key set of EC keys that the test can rotate.
+type testIssuer struct {
+	server *httptest.Server
+
+	mu        sync.Mutex
+	keys      map[string]*ecdsa.PrivateKey
+	discovery map[string]interface{} // Overrides of the discovery document
+	fetches   map[string]int         // Requests per path
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	issuer := &testIssuer{keys: map[string]*ecdsa.PrivateKey{}, discovery: map[string]interface{}{}, fetches: map[string]int{}}
+	issuer.server = httptest.NewServer(http.HandlerFunc(issuer.serve))
+	t.Cleanup(issuer.server.Close)
+	issuer.addKey(t, "first")
+	return issuer
+}
+
+func (i *testIssuer) serve(w http.ResponseWriter, r *http.Request) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.fetches[r.URL.Path]++
+
+	var body interface{}
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		document := map[string]interface{}{
+			"issuer":                 i.server.URL,
+			"authorization_endpoint": i.server.URL + "/authorize",
+			"token_endpoint":         i.server.URL + "/token",
+			"jwks_uri":               i.server.URL + "/jwks",
+		}
+		for name, value := range i.discovery {
+			document[name] = value
+		}
+		body = document
+	case "/jwks":
+		set := keys.JWKS{Keys: []keys.JWK{}}
+		for kid, key := range i.keys {
+			set.Keys = append(set.Keys, keys.JWK{
+				KeyType: "EC", KeyID: kid, Algorithm: "ES256", Use: "sig", Curve: "P-256",
+				X: base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+				Y: base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+			})
+		}
+		body = set
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func (i *testIssuer) addKey(t *testing.T, kid string) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.keys[kid] = key
+	return key
+}
+
+func (i *testIssuer) provider() *Provider {
+	return New(Config{Issuer: i.server.URL, ClientID: "notes", RedirectURL: "http://app/callback", Scopes: []string{"email"}}, nil)
+}
+
+// sign signs the claims with the key of the given ID.
+func (i *testIssuer) sign(t *testing.T, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	i.mu.Lock()
+	key := i.keys[kid]
+	i.mu.Unlock()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	issuer := newTestIssuer(t)
+	now := time.Now()
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss": issuer.server.URL, "sub": "subject", "aud": "notes", "nonce": "nonce",
+			"iat": now.Unix(), "exp": now.Add(5 * time.Minute).Unix(), "email": "john@example.com", "email_verified": true,
+		}
+	}
+	with := func(name string, value interface{}) jwt.MapClaims {
+		claims := valid()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknownSigner := jwt.NewWithClaims(jwt.SigningMethodES256, valid())
+	unknownSigner.Header["kid"] = "first"
+	forged, err := unknownSigner.SignedString(otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hmac, err := jwt.NewWithClaims(jwt.SigningMethodHS256, valid()).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		nonce   string
+		wantErr bool
+	}{
+		{"valid", issuer.sign(t, "first", valid()), "nonce", false},
+		{"several audiences without an authorized party", issuer.sign(t, "first", with("aud", []string{"notes", "other"})), "nonce", true},
+		{"other issuer", issuer.sign(t, "first", with("iss", "https://evil.example")), "nonce", true},
+		{"other audience", issuer.sign(t, "first", with("aud", "other")), "nonce", true},
+		{"expired beyond the leeway", issuer.sign(t, "first", with("exp", now.Add(-2*time.Minute).Unix())), "nonce", true},
+		{"expired within the leeway", issuer.sign(t, "first", with("exp", now.Add(-30*time.Second).Unix())), "nonce", false},
+		{"without expiry", issuer.sign(t, "first", with("exp", nil)), "nonce", true},
+		{"issued in the future", issuer.sign(t, "first", with("iat", now.Add(2*time.Minute).Unix())), "nonce", true},
+		{"without subject", issuer.sign(t, "first", with("sub", nil)), "nonce", true},
+		{"other nonce", issuer.sign(t, "first", valid()), "another", true},
+		{"no nonce expected", issuer.sign(t, "first", with("nonce", "")), "", true},
+		{"signed with another key", forged, "nonce", true},
+		{"HMAC", hmac, "nonce", true},
+	}
+	provider := issuer.provider()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims, err := provider.VerifyIDToken(context.Background(), test.token, test.nonce)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("VerifyIDToken = %v, want error: %v", err, test.wantErr)
+			}
+			if err == nil && (claims.Subject != "subject" || claims.Email != "john@example.com" || !claims.EmailVerified) {
+				t.Errorf("claims = %+v", claims)
+			}
+		})
+	}
+
+	multi := with("aud", []string{"notes", "other"})
+	multi["azp"] = "notes"
+	if _, err := provider.VerifyIDToken(context.Background(), issuer.sign(t, "first", multi), "nonce"); err != nil {
+		t.Errorf("token for several audiences, authorized to this client: %v", err)
+	}
+}
+
+func TestProviderPicksUpRotatedKeys(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := issuer.provider()
+	now := time.Now()
+	claims := jwt.MapClaims{"iss": issuer.server.URL, "sub": "subject", "aud": "notes", "nonce": "n", "iat": now.Unix(), "exp": now.Add(time.Minute).Unix()}
+
+	if _, err := provider.VerifyIDToken(context.Background(), issuer.sign(t, "first", claims), "n"); err != nil {
+		t.Fatalf("token of the first key: %v", err)
+	}
+	issuer.addKey(t, "second")
+	if _, err := provider.VerifyIDToken(context.Background(), issuer.sign(t, "second", claims), "n"); err != nil {
+		t.Fatalf("token of a key added after the first fetch: %v", err)
+	}
+	if _, err := provider.VerifyIDToken(context.Background(), issuer.sign(t, "first", claims), "n"); err != nil {
+		t.Fatalf("token of the first key after the refresh: %v", err)
+	}
+
+	issuer.mu.Lock()
+	defer issuer.mu.Unlock()
+	if issuer.fetches["/jwks"] != 2 || issuer.fetches["/.well-known/openid-configuration"] != 1 {
+		t.Errorf("fetches = %v, want the keys twice and the discovery document once", issuer.fetches)
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides map[string]interface{}
+		wantErr   bool
+	}{
+		{"valid", nil, false},
+		{"other issuer", map[string]interface{}{"issuer": "https://evil.example"}, true},
+		{"without token endpoint", map[string]interface{}{"token_endpoint": ""}, true},
+		{"without keys", map[string]interface{}{"jwks_uri": ""}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			issuer := newTestIssuer(t)
+			issuer.discovery = test.overrides
+			if _, err := issuer.provider().Discover(context.Background()); (err != nil) != test.wantErr {
+				t.Errorf("Discover = %v, want error: %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	issuer := newTestIssuer(t)
+	issuer.discovery = map[string]interface{}{"authorization_endpoint": issuer.server.URL + "/authorize?tenant=1"}
+
+	target, err := issuer.provider().AuthCodeURL(context.Background(), "state", "nonce", "challenge")
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	parsed, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"tenant":                "1",
+		"response_type":         "code",
+		"client_id":             "notes",
+		"redirect_uri":          "http://app/callback",
+		"scope":                 "openid email",
+		"state":                 "state",
+		"nonce":                 "nonce",
+		"code_challenge":        "challenge",
+		"code_challenge_method": "S256",
+	} {
+		if got := parsed.Query().Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestExchangeWithMockProvider(t *testing.T) {
+	mock, err := oidctest.NewProvider("notes", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	mock.SetIdentity(oidctest.Identity{Subject: "alice-1", Email: "alice@example.com", EmailVerified: true, PreferredUsername: "alice"})
+
+	tests := []struct {
+		name         string
+		secret       string
+		exchangeWith func(verifier string) string // Verifier presented at the token endpoint
+		nonce        string
+		wantErr      bool
+	}{
+		{"valid", "secret", func(verifier string) string { return verifier }, "nonce", false},
+		{"wrong verifier", "secret", func(string) string { return "wrong" }, "nonce", true},
+		{"wrong client secret", "guess", func(verifier string) string { return verifier }, "nonce", true},
+		{"other nonce", "secret", func(verifier string) string { return verifier }, "other", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := New(Config{Issuer: mock.Issuer(), ClientID: "notes", ClientSecret: test.secret, RedirectURL: "http://app/callback"}, nil)
+			verifier, err := NewCodeVerifier()
+			if err != nil {
+				t.Fatal(err)
+			}
+			target, err := provider.AuthCodeURL(context.Background(), "state", "nonce", CodeChallengeS256(verifier))
+			if err != nil {
+				t.Fatalf("AuthCodeURL: %v", err)
+			}
+
+			// The mock logs in at once and redirects back with the code and the state
+			client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+			response, err := client.Get(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response.Body.Close()
+			callback, err := url.Parse(response.Header.Get("Location"))
+			if err != nil || !strings.HasPrefix(callback.String(), "http://app/callback?") || callback.Query().Get("state") != "state" {
+				t.Fatalf("redirected to %q", response.Header.Get("Location"))
+			}
+
+			claims, err := provider.Exchange(context.Background(), callback.Query().Get("code"), test.exchangeWith(verifier), test.nonce)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Exchange = %v, want error: %v", err, test.wantErr)
+			}
+			if err == nil && (claims.Subject != "alice-1" || claims.Email != "alice@example.com" || claims.PreferredUsername != "alice") {
+				t.Errorf("claims = %+v", claims)
+			}
+		})
+	}
+}
+
+func TestNewFromEnv(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        map[string]string
+		wantErr    bool
+		wantScopes string
+	}{
+		{"not configured", nil, true, ""},
+		{"without client", map[string]string{"OIDC_ISSUER": "https://id.example"}, true, ""},
+		{"defaults", map[string]string{"OIDC_ISSUER": "https://id.example", "OIDC_CLIENT_ID": "notes"}, false, "openid email profile"},
+		{"custom scopes", map[string]string{"OIDC_ISSUER": "https://id.example", "OIDC_CLIENT_ID": "notes", "OIDC_SCOPES": "openid email"}, false, "openid email"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, key := range []string{"OIDC_ISSUER", "OIDC_CLIENT_ID", "OIDC_CLIENT_SECRET", "OIDC_REDIRECT_URL", "OIDC_SCOPES"} {
+				t.Setenv(key, test.env[key])
+			}
+			provider, err := NewFromEnv("http://app/callback")
+			if (err != nil) != test.wantErr {
+				t.Fatalf("NewFromEnv = %v, want error: %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := strings.Join(provider.config.Scopes, " "); got != test.wantScopes {
+				t.Errorf("scopes = %q, want %q", got, test.wantScopes)
+			}
+			if provider.config.RedirectURL != "http://app/callback" {
+				t.Errorf("redirect URL = %q, want the default", provider.config.RedirectURL)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package password
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// PasswordHasher hashes passwords and verifies them against stored hashes. Hashes are stored in the
+// PHC string format ("$<algorithm>$<parameters>$<salt>$<hash>"), so that every hash records the
+// algorithm and parameters it was made with. Implementations must be safe for concurrent use.
+type PasswordHasher interface {
+	// Hash returns the encoded hash of the password.
+	Hash(password string) (string, error)
+	// Verify reports whether the password matches the encoded hash.
+	Verify(password, encoded string) (bool, error)
+	// Recognizes reports whether the encoded hash was made by this hasher.
+	Recognizes(encoded string) bool
+	// NeedsRehash reports whether the encoded hash should be replaced by a fresh hash with the current settings.
+	NeedsRehash(encoded string) bool
+}
+
+// Multi hashes new passwords with the preferred hasher and still verifies hashes made by the others.
+// Hashes that the preferred hasher did not make, or made with other parameters, need a rehash.
+type Multi struct {
+	Preferred PasswordHasher   // Hashes new passwords
+	Others    []PasswordHasher // Only verify existing hashes
+}
+
+// Hash hashes the password with the preferred hasher.
+func (m *Multi) Hash(password string) (string, error) {
+	return m.Preferred.Hash(password)
+}
+
+// Verify verifies the password with the hasher that made the encoded hash.
+func (m *Multi) Verify(password, encoded string) (bool, error) {
+	hasher := m.hasherFor(encoded)
+	if hasher == nil {
+		return false, fmt.Errorf("unrecognized password hash format")
+	}
+	return hasher.Verify(password, encoded)
+}
+
+// Recognizes reports whether any of the hashers made the encoded hash.
+func (m *Multi) Recognizes(encoded string) bool {
+	return m.hasherFor(encoded) != nil
+}
+
+// NeedsRehash reports whether the encoded hash was not made by the preferred hasher with its current parameters.
+func (m *Multi) NeedsRehash(encoded string) bool {
+	if !m.Preferred.Recognizes(encoded) {
+		return true
+	}
+	return m.Preferred.NeedsRehash(encoded)
+}
+
+// hasherFor returns the hasher that made the encoded hash, or nil.
+func (m *Multi) hasherFor(encoded string) PasswordHasher {
+	if m.Preferred.Recognizes(encoded) {
+		return m.Preferred
+	}
+	for _, hasher := range m.Others {
+		if hasher.Recognizes(encoded) {
+			return hasher
+		}
+	}
+	return nil
+}
+
+// NewFromEnv creates the hasher selected by PASSWORD_HASHER: "argon2id" (default) or "bcrypt".
+// Both algorithms are always accepted for verification, so existing hashes keep working and are
+// rehashed with the selected algorithm on the next successful login.
+// Argon2id is tuned with ARGON2_MEMORY (KiB), ARGON2_ITERATIONS and ARGON2_PARALLELISM, bcrypt with BCRYPT_COST.
+func NewFromEnv() (PasswordHasher, error) {
+	argon := DefaultArgon2id()
+	var err error
+	if argon.Memory, err = envUint32("ARGON2_MEMORY", argon.Memory); err != nil {
+		return nil, err
+	}
+	if argon.Iterations, err = envUint32("ARGON2_ITERATIONS", argon.Iterations); err != nil {
+		return nil, err
+	}
+	parallelism, err := envUint32("ARGON2_PARALLELISM", uint32(argon.Parallelism))
+	if err != nil {
+		return nil, err
+	}
+	if parallelism > 255 {
+		return nil, fmt.Errorf("ARGON2_PARALLELISM must be at most 255")
+	}
+	argon.Parallelism = uint8(parallelism)
+
+	bcryptHasher := DefaultBcrypt()
+	cost, err := envUint32("BCRYPT_COST", uint32(bcryptHasher.Cost))
+	if err != nil {
+		return nil, err
+	}
+	bcryptHasher.Cost = int(cost)
+	if err := bcryptHasher.validate(); err != nil {
+		return nil, err
+	}
+
+	switch algorithm := os.Getenv("PASSWORD_HASHER"); algorithm {
+	case "", "argon2id":
+		return &Multi{Preferred: argon, Others: []PasswordHasher{bcryptHasher}}, nil
+	case "bcrypt":
+		return &Multi{Preferred: bcryptHasher, Others: []PasswordHasher{argon}}, nil
+	default:
+		return nil, fmt.Errorf("unknown password hasher %q", algorithm)
+	}
+}
+
+// envUint32 reads a positive integer from the environment, falling back to the default if unset.
+func envUint32(key string, fallback uint32) (uint32, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	number, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || number == 0 {
+		return 0, fmt.Errorf("invalid value %q for %s", value, key)
+	}
+	return uint32(number), nil
+}
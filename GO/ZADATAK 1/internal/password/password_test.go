@@ -0,0 +1,201 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fastArgon2id is cheap enough for tests; the format is the same as with the defaults.
+func fastArgon2id() *Argon2id {
+	return &Argon2id{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+}
+
+// fastBcrypt uses the lowest cost bcrypt accepts.
+func fastBcrypt() *Bcrypt {
+	return &Bcrypt{Cost: bcrypt.MinCost}
+}
+
+func TestHashersRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		hasher PasswordHasher
+		prefix string
+	}{
+		{"argon2id", fastArgon2id(), "$argon2id$v=19$m=64,t=1,p=1$"},
+		{"bcrypt", fastBcrypt(), "$2a$04$"},
+		{"multi", &Multi{Preferred: fastArgon2id(), Others: []PasswordHasher{fastBcrypt()}}, "$argon2id$"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoded, err := test.hasher.Hash("correct horse battery staple")
+			if err != nil {
+				t.Fatalf("Hash: %v", err)
+			}
+			if !strings.HasPrefix(encoded, test.prefix) {
+				t.Errorf("hash %q does not start with %q", encoded, test.prefix)
+			}
+			if !test.hasher.Recognizes(encoded) {
+				t.Errorf("hasher does not recognize its own hash %q", encoded)
+			}
+			if test.hasher.NeedsRehash(encoded) {
+				t.Errorf("fresh hash %q needs a rehash", encoded)
+			}
+
+			for password, want := range map[string]bool{
+				"correct horse battery staple":  true,
+				"correct horse battery staple ": false,
+				"Correct horse battery staple":  false,
+				"":                              false,
+			} {
+				got, err := test.hasher.Verify(password, encoded)
+				if err != nil {
+					t.Fatalf("Verify(%q): %v", password, err)
+				}
+				if got != want {
+					t.Errorf("Verify(%q) = %v, want %v", password, got, want)
+				}
+			}
+
+			again, err := test.hasher.Hash("correct horse battery staple")
+			if err != nil {
+				t.Fatalf("Hash: %v", err)
+			}
+			if again == encoded {
+				t.Error("two hashes of the same password are equal; the salt is not random")
+			}
+		})
+	}
+}
+
+func TestArgon2idRejectsMalformedHashes(t *testing.T) {
+	valid, err := fastArgon2id().Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(valid, "$")
+
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"empty", ""},
+		{"bcrypt hash", "$2a$04$abcdefghijklmnopqrstuu"},
+		{"missing part", strings.Join(parts[:5], "$")},
+		{"other version", strings.Replace(valid, "v=19", "v=16", 1)},
+		{"bad parameters", strings.Replace(valid, "m=64,t=1,p=1", "m=x,t=1,p=1", 1)},
+		{"bad salt", strings.Join([]string{"", parts[1], parts[2], parts[3], "!!!", parts[5]}, "$")},
+		{"empty hash", strings.Join([]string{"", parts[1], parts[2], parts[3], parts[4], ""}, "$")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if ok, err := fastArgon2id().Verify("password", test.encoded); err == nil || ok {
+				t.Errorf("Verify(%q) = %v, %v; want an error", test.encoded, ok, err)
+			}
+			if !fastArgon2id().NeedsRehash(test.encoded) {
+				t.Errorf("NeedsRehash(%q) = false for a malformed hash", test.encoded)
+			}
+		})
+	}
+}
+
+func TestNeedsRehashOnChangedParameters(t *testing.T) {
+	argon := fastArgon2id()
+	argonHash, err := argon.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcryptHash, err := fastBcrypt().Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		hasher  PasswordHasher
+		encoded string
+		want    bool
+	}{
+		{"same argon2id parameters", fastArgon2id(), argonHash, false},
+		{"more memory", &Argon2id{Memory: 128, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}, argonHash, true},
+		{"more iterations", &Argon2id{Memory: 64, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}, argonHash, true},
+		{"more lanes", &Argon2id{Memory: 64, Iterations: 1, Parallelism: 2, SaltLength: 16, KeyLength: 32}, argonHash, true},
+		{"longer key", &Argon2id{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 64}, argonHash, true},
+		{"same bcrypt cost", fastBcrypt(), bcryptHash, false},
+		{"higher bcrypt cost", &Bcrypt{Cost: bcrypt.MinCost + 1}, bcryptHash, true},
+		{"multi, bcrypt hash with argon2id preferred", &Multi{Preferred: argon, Others: []PasswordHasher{fastBcrypt()}}, bcryptHash, true},
+		{"multi, argon2id hash with argon2id preferred", &Multi{Preferred: argon, Others: []PasswordHasher{fastBcrypt()}}, argonHash, false},
+		{"multi, argon2id hash with bcrypt preferred", &Multi{Preferred: fastBcrypt(), Others: []PasswordHasher{argon}}, argonHash, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.hasher.NeedsRehash(test.encoded); got != test.want {
+				t.Errorf("NeedsRehash = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMultiVerifiesEveryKnownFormat(t *testing.T) {
+	multi := &Multi{Preferred: fastArgon2id(), Others: []PasswordHasher{fastBcrypt()}}
+	for _, hasher := range []PasswordHasher{fastArgon2id(), fastBcrypt()} {
+		encoded, err := hasher.Hash("password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := multi.Verify("password", encoded); err != nil || !ok {
+			t.Errorf("Verify(%q) = %v, %v; want true", encoded, ok, err)
+		}
+	}
+
+	if multi.Recognizes("$scrypt$ln=16,r=8,p=1$c2FsdA$aGFzaA") {
+		t.Error("Multi recognizes an scrypt hash")
+	}
+	if _, err := multi.Verify("password", "$scrypt$ln=16,r=8,p=1$c2FsdA$aGFzaA"); err == nil {
+		t.Error("Multi verified a hash of an unknown format without an error")
+	}
+}
+
+func TestNewFromEnv(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        map[string]string
+		wantErr    bool
+		wantPrefix string
+	}{
+		{"default", nil, false, "$argon2id$v=19$m=65536,t=3,p=2$"},
+		{"argon2id tuned", map[string]string{"ARGON2_MEMORY": "64", "ARGON2_ITERATIONS": "1", "ARGON2_PARALLELISM": "1"}, false, "$argon2id$v=19$m=64,t=1,p=1$"},
+		{"bcrypt", map[string]string{"PASSWORD_HASHER": "bcrypt", "BCRYPT_COST": "4"}, false, "$2a$04$"},
+		{"unknown hasher", map[string]string{"PASSWORD_HASHER": "md5"}, true, ""},
+		{"zero memory", map[string]string{"ARGON2_MEMORY": "0"}, true, ""},
+		{"not a number", map[string]string{"ARGON2_ITERATIONS": "three"}, true, ""},
+		{"too many lanes", map[string]string{"ARGON2_PARALLELISM": "256"}, true, ""},
+		{"bcrypt cost too high", map[string]string{"BCRYPT_COST": "32"}, true, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, key := range []string{"PASSWORD_HASHER", "ARGON2_MEMORY", "ARGON2_ITERATIONS", "ARGON2_PARALLELISM", "BCRYPT_COST"} {
+				t.Setenv(key, test.env[key])
+			}
+
+			hasher, err := NewFromEnv()
+			if test.wantErr {
+				if err == nil {
+					t.Error("NewFromEnv accepted an invalid configuration")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewFromEnv: %v", err)
+			}
+			encoded, err := hasher.Hash("password")
+			if err != nil {
+				t.Fatalf("Hash: %v", err)
+			}
+			if !strings.HasPrefix(encoded, test.wantPrefix) {
+				t.Errorf("hash %q does not start with %q", encoded, test.wantPrefix)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxLength is the longest accepted password in bytes; bcrypt ignores anything beyond it.
+const MaxLength = 72
+
+// DefaultMinLength is the minimum password length unless MIN_PASSWORD_LENGTH overrides it.
+const DefaultMinLength = 8
+
+// ErrBreached is returned for passwords that appear in the breached-password list.
+var ErrBreached = errors.New("password appears in a list of breached passwords; choose another one")
+
+// Policy decides which new passwords are acceptable.
+type Policy struct {
+	MinLength int                 // Minimum length in characters
+	breached  map[string]struct{} // Upper-case SHA-1 hex digests of breached passwords
+}
+
+// PolicyFromEnv creates the policy configured by MIN_PASSWORD_LENGTH and BREACHED_PASSWORDS_FILE.
+func PolicyFromEnv() (*Policy, error) {
+	policy := &Policy{MinLength: DefaultMinLength}
+
+	if value := os.Getenv("MIN_PASSWORD_LENGTH"); value != "" {
+		minLength, err := strconv.Atoi(value)
+		if err != nil || minLength < 1 || minLength > MaxLength {
+			return nil, fmt.Errorf("MIN_PASSWORD_LENGTH must be between 1 and %d", MaxLength)
+		}
+		policy.MinLength = minLength
+	}
+
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		if err := policy.LoadBreachedList(path); err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+// LoadBreachedList reads a breached-password list with one entry per line. An entry is either the
+// password itself or its SHA-1 hex digest, optionally followed by ":<count>" as in the
+// "Have I Been Pwned" downloads. Empty lines and lines starting with "#" are skipped.
+func (p *Policy) LoadBreachedList(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening breached-password list: %w", err)
+	}
+	defer file.Close()
+
+	breached := make(map[string]struct{})
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if digest, ok := sha1Entry(line); ok {
+			breached[digest] = struct{}{}
+		} else {
+			breached[sha1Hex(line)] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading breached-password list: %w", err)
+	}
+
+	p.breached = breached
+	return nil
+}
+
+// Check returns an error describing why the password is not acceptable, or nil.
+func (p *Policy) Check(password string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters long", p.MinLength)
+	}
+	if len(password) > MaxLength {
+		return fmt.Errorf("password must be at most %d bytes long", MaxLength)
+	}
+	if _, found := p.breached[sha1Hex(password)]; found {
+		return ErrBreached
+	}
+	return nil
+}
+
+// sha1Entry returns the digest of a list entry that is a SHA-1 hex digest, with an optional count.
+func sha1Entry(line string) (string, bool) {
+	digest, _, _ := strings.Cut(line, ":")
+	if len(digest) != sha1.Size*2 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(digest); err != nil {
+		return "", false
+	}
+	return strings.ToUpper(digest), true
+}
+
+// sha1Hex returns the upper-case SHA-1 hex digest of the password, the form used by breach lists.
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
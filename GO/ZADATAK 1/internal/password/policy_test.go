@@ -0,0 +1,111 @@
+package password
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeBreachedList writes a breached-password list to a temporary file and returns its path.
+func writeBreachedList(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPolicyCheck(t *testing.T) {
+	policy := &Policy{MinLength: 8}
+	if err := policy.LoadBreachedList(writeBreachedList(t,
+		"# Passwords from a breach",
+		"",
+		"letmein123",
+		"5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:3861493", // "password" as a digest with its count
+		"7c4a8d09ca3762af61e59520943dc26494f8941b",         // "123456", lower-case digest
+	)); err != nil {
+		t.Fatalf("LoadBreachedList: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		wantErr  bool
+		breached bool
+	}{
+		{"acceptable", "a long passphrase", false, false},
+		{"exactly the minimum", "12345678", false, false},
+		{"too short", "1234567", true, false},
+		{"multi-byte characters count as one", "ééééééé", true, false},
+		{"eight multi-byte characters", "éééééééé", false, false},
+		{"at the maximum", strings.Repeat("a", MaxLength), false, false},
+		{"too long", strings.Repeat("a", MaxLength+1), true, false},
+		{"plain entry", "letmein123", true, true},
+		{"digest entry with count", "password", true, true},
+		{"lower-case digest entry", "123456", true, false}, // Too short first
+		{"comment is not an entry", "# Passwords from a breach", false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := policy.Check(test.password)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Check(%q) = %v, want error: %v", test.password, err, test.wantErr)
+			}
+			if errors.Is(err, ErrBreached) != test.breached {
+				t.Errorf("Check(%q) = %v, want breached: %v", test.password, err, test.breached)
+			}
+		})
+	}
+
+	policy.MinLength = 6
+	if err := policy.Check("123456"); !errors.Is(err, ErrBreached) {
+		t.Errorf("Check of a password listed as a lower-case digest = %v, want ErrBreached", err)
+	}
+}
+
+func TestPolicyFromEnv(t *testing.T) {
+	list := writeBreachedList(t, "hunter2hunter2")
+
+	tests := []struct {
+		name          string
+		minLength     string
+		breachedFile  string
+		wantErr       bool
+		wantMinLength int
+	}{
+		{"defaults", "", "", false, DefaultMinLength},
+		{"custom minimum", "12", "", false, 12},
+		{"minimum of one", "1", "", false, 1},
+		{"minimum of zero", "0", "", true, 0},
+		{"minimum above the maximum", "73", "", true, 0},
+		{"minimum not a number", "ten", "", true, 0},
+		{"with a breached list", "", list, false, DefaultMinLength},
+		{"missing breached list", "", filepath.Join(t.TempDir(), "missing.txt"), true, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("MIN_PASSWORD_LENGTH", test.minLength)
+			t.Setenv("BREACHED_PASSWORDS_FILE", test.breachedFile)
+
+			policy, err := PolicyFromEnv()
+			if test.wantErr {
+				if err == nil {
+					t.Error("PolicyFromEnv accepted an invalid configuration")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("PolicyFromEnv: %v", err)
+			}
+			if policy.MinLength != test.wantMinLength {
+				t.Errorf("MinLength = %d, want %d", policy.MinLength, test.wantMinLength)
+			}
+			if breached := errors.Is(policy.Check("hunter2hunter2"), ErrBreached); breached != (test.breachedFile != "") {
+				t.Errorf("breached list loaded: %v, want %v", breached, test.breachedFile != "")
+			}
+		})
+	}
+}
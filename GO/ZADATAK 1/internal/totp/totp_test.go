@@ -0,0 +1,143 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 6238 test vectors, "12345678901234567890", in base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeMatchesRFC6238(t *testing.T) {
+	// The RFC lists eight-digit codes; six-digit codes are their last six digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, test := range tests {
+		got, err := Code(rfcSecret, Counter(time.Unix(test.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code at %d: %v", test.unix, err)
+		}
+		if got != test.want {
+			t.Errorf("Code at %d = %s, want %s", test.unix, got, test.want)
+		}
+	}
+}
+
+func TestCodeAcceptsLowercaseSecret(t *testing.T) {
+	upper, err := Code(rfcSecret, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lower, err := Code(strings.ToLower(rfcSecret), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upper != lower {
+		t.Errorf("codes differ by the case of the secret: %s and %s", upper, lower)
+	}
+}
+
+func TestCodeRejectsInvalidSecret(t *testing.T) {
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("Code accepted a secret that is not base32")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Counter(now)
+	code := func(counter int64) string {
+		value, err := Code(rfcSecret, counter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+
+	tests := []struct {
+		name        string
+		code        string
+		wantOK      bool
+		wantCounter int64
+	}{
+		{"current step", code(current), true, current},
+		{"previous step", code(current - 1), true, current - 1},
+		{"next step", code(current + 1), true, current + 1},
+		{"surrounding spaces", " " + code(current) + " ", true, current},
+		{"two steps behind", code(current - 2), false, 0},
+		{"two steps ahead", code(current + 2), false, 0},
+		{"too short", code(current)[:5], false, 0},
+		{"too long", code(current) + "0", false, 0},
+		{"empty", "", false, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			counter, ok := Validate(rfcSecret, test.code, now)
+			if ok != test.wantOK || counter != test.wantCounter {
+				t.Errorf("Validate(%q) = %d, %v; want %d, %v", test.code, counter, ok, test.wantCounter, test.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	first, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Error("two generated secrets are equal")
+	}
+	key, err := encoding.DecodeString(first)
+	if err != nil {
+		t.Fatalf("generated secret is not base32: %v", err)
+	}
+	if len(key) != secretSize {
+		t.Errorf("generated secret has %d bytes, want %d", len(key), secretSize)
+	}
+	if _, err := Code(first, 1); err != nil {
+		t.Errorf("generated secret cannot be used: %v", err)
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Notes App", "john@example.com", rfcSecret)
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		t.Fatalf("URI is not a URL: %v", err)
+	}
+	if parsed.Scheme != "otpauth" || parsed.Host != "totp" {
+		t.Errorf("URI = %s, want otpauth://totp/...", uri)
+	}
+	if label, _ := url.PathUnescape(strings.TrimPrefix(parsed.EscapedPath(), "/")); label != "Notes App:john@example.com" {
+		t.Errorf("label = %q, want %q", label, "Notes App:john@example.com")
+	}
+
+	query := parsed.Query()
+	for name, want := range map[string]string{
+		"secret":    rfcSecret,
+		"issuer":    "Notes App",
+		"algorithm": "SHA1",
+		"digits":    "6",
+		"period":    "30",
+	} {
+		if got := query.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
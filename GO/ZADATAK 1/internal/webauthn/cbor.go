@@ -0,0 +1,170 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// maxCBORDepth limits the nesting of decoded CBOR items, so that hostile input cannot exhaust the stack.
+const maxCBORDepth = 16
+
+// errCBORTruncated is returned for CBOR input that ends in the middle of an item.
+var errCBORTruncated = errors.New("cbor: unexpected end of input")
+
+// decodeCBOR decodes the first CBOR item (RFC 8949) of data and returns it together with the bytes after it.
+// It supports the subset used by WebAuthn: integers, byte and text strings, arrays, maps, booleans and null.
+// Unsigned integers decode to uint64, negative integers to int64, maps to map[interface{}]interface{}.
+func decodeCBOR(data []byte) (interface{}, []byte, error) {
+	return decodeCBORItem(data, 0)
+}
+
+// decodeCBORItem decodes one item at the given nesting depth.
+func decodeCBORItem(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > maxCBORDepth {
+		return nil, nil, errors.New("cbor: nesting too deep")
+	}
+	if len(data) == 0 {
+		return nil, nil, errCBORTruncated
+	}
+	major, info := data[0]>>5, data[0]&0x1f
+	data = data[1:]
+
+	// Simple values and floats carry no length argument
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, data, nil
+		case 21:
+			return true, data, nil
+		case 22, 23:
+			return nil, data, nil
+		default:
+			return nil, nil, fmt.Errorf("cbor: unsupported simple value %d", info)
+		}
+	}
+
+	argument, data, err := decodeCBORArgument(info, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0: // Unsigned integer
+		return argument, data, nil
+	case 1: // Negative integer, -1 - argument
+		if argument > math.MaxInt64 {
+			return nil, nil, errors.New("cbor: negative integer out of range")
+		}
+		return -1 - int64(argument), data, nil
+	case 2, 3: // Byte string, text string
+		if argument > uint64(len(data)) {
+			return nil, nil, errCBORTruncated
+		}
+		value := data[:argument]
+		if major == 3 {
+			return string(value), data[argument:], nil
+		}
+		return append([]byte(nil), value...), data[argument:], nil
+	case 4: // Array
+		if argument > uint64(len(data)) {
+			return nil, nil, errCBORTruncated // Every element takes at least one byte
+		}
+		items := make([]interface{}, 0, argument)
+		for i := uint64(0); i < argument; i++ {
+			var item interface{}
+			if item, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, data, nil
+	case 5: // Map
+		if argument > uint64(len(data)) {
+			return nil, nil, errCBORTruncated
+		}
+		items := make(map[interface{}]interface{}, argument)
+		for i := uint64(0); i < argument; i++ {
+			var key, value interface{}
+			if key, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case uint64, int64, string:
+			default:
+				return nil, nil, errors.New("cbor: unsupported map key type")
+			}
+			if value, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			if _, duplicate := items[key]; duplicate {
+				return nil, nil, errors.New("cbor: duplicate map key")
+			}
+			items[key] = value
+		}
+		return items, data, nil
+	default: // Tags are not used by WebAuthn
+		return nil, nil, fmt.Errorf("cbor: unsupported major type %d", major)
+	}
+}
+
+// decodeCBORArgument reads the argument of an item header: the value itself for small values, or the
+// 1, 2, 4 or 8 bytes following the header. Indefinite lengths are not supported.
+func decodeCBORArgument(info byte, data []byte) (uint64, []byte, error) {
+	size := 0
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, nil, errors.New("cbor: indefinite lengths are not supported")
+	}
+	if len(data) < size {
+		return 0, nil, errCBORTruncated
+	}
+	var argument uint64
+	switch size {
+	case 1:
+		argument = uint64(data[0])
+	case 2:
+		argument = uint64(binary.BigEndian.Uint16(data))
+	case 4:
+		argument = uint64(binary.BigEndian.Uint32(data))
+	case 8:
+		argument = binary.BigEndian.Uint64(data)
+	}
+	return argument, data[size:], nil
+}
+
+// cborInt returns a decoded CBOR integer as an int64.
+func cborInt(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case uint64:
+		if v > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v), true
+	case int64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+// cborMapInt returns the entry of a decoded CBOR map with an integer key, as used by COSE keys.
+func cborMapInt(m map[interface{}]interface{}, key int64) (interface{}, bool) {
+	if key >= 0 {
+		value, ok := m[uint64(key)]
+		return value, ok
+	}
+	value, ok := m[key]
+	return value, ok
+}
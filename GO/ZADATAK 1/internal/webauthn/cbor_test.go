@@ -0,0 +1,85 @@
+package webauthn
+
+import (
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	// Mostly the examples of RFC 8949, appendix A
+	tests := []struct {
+		input string
+		want  interface{}
+		rest  string
+	}{
+		{"00", uint64(0), ""},
+		{"17", uint64(23), ""},
+		{"1818", uint64(24), ""},
+		{"1903e8", uint64(1000), ""},
+		{"1a000f4240", uint64(1000000), ""},
+		{"1b000000e8d4a51000", uint64(1000000000000), ""},
+		{"20", int64(-1), ""},
+		{"3903e7", int64(-1000), ""},
+		{"4401020304", []byte{1, 2, 3, 4}, ""},
+		{"6449455446", "IETF", ""},
+		{"83010203", []interface{}{uint64(1), uint64(2), uint64(3)}, ""},
+		{"a201020304", map[interface{}]interface{}{uint64(1): uint64(2), uint64(3): uint64(4)}, ""},
+		{"a1616101", map[interface{}]interface{}{"a": uint64(1)}, ""},
+		{"a12001", map[interface{}]interface{}{int64(-1): uint64(1)}, ""},
+		{"f4", false, ""},
+		{"f5", true, ""},
+		{"f6", nil, ""},
+		{"0001", uint64(0), "01"},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			input, err := hex.DecodeString(test.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, rest, err := decodeCBOR(input)
+			if err != nil {
+				t.Fatalf("decodeCBOR: %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("decodeCBOR = %#v, want %#v", got, test.want)
+			}
+			if hex.EncodeToString(rest) != test.rest {
+				t.Errorf("rest = %x, want %s", rest, test.rest)
+			}
+		})
+	}
+}
+
+func TestDecodeCBORRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"empty", ""},
+		{"truncated argument", "19 03"},
+		{"truncated byte string", "44 0102"},
+		{"array longer than the input", "9a ffffffff 00"},
+		{"map longer than the input", "ba ffffffff 00"},
+		{"indefinite length", "5f 41 00 ff"},
+		{"tag", "c0 00"},
+		{"float", "f9 3c00"},
+		{"negative integer out of range", "3b ffffffffffffffff"},
+		{"array as map key", "a1 80 01"},
+		{"duplicate map key", "a2 01 02 01 03"},
+		{"nesting too deep", strings.Repeat("81", maxCBORDepth+1) + "00"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			input, err := hex.DecodeString(strings.ReplaceAll(test.input, " ", ""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _, err := decodeCBOR(input); err == nil {
+				t.Errorf("decodeCBOR(%s) = %#v, want an error", test.input, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers (RFC 9053) of the supported credential keys.
+const (
+	AlgES256 = -7   // ECDSA with P-256 and SHA-256
+	AlgEdDSA = -8   // Ed25519
+	AlgRS256 = -257 // RSASSA-PKCS1-v1_5 with SHA-256
+)
+
+// SupportedAlgorithms are the algorithms offered to authenticators, most preferred first.
+var SupportedAlgorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+// COSE key parameters (RFC 9052, RFC 9053).
+const (
+	coseKeyType   = 1
+	coseAlgorithm = 3
+	coseCurve     = -1 // EC2 and OKP keys
+	coseX         = -2 // EC2 and OKP keys
+	coseY         = -3 // EC2 keys
+	coseRSAN      = -1 // RSA keys
+	coseRSAE      = -2 // RSA keys
+
+	coseKeyTypeOKP = 1
+	coseKeyTypeEC2 = 2
+	coseKeyTypeRSA = 3
+
+	coseCurveP256    = 1
+	coseCurveEd25519 = 6
+)
+
+// errInvalidSignature is returned when an assertion or attestation signature does not verify.
+var errInvalidSignature = errors.New("webauthn: invalid signature")
+
+// ParsePublicKey decodes a COSE_Key as stored for a credential and returns the key with its algorithm.
+func ParsePublicKey(coseKey []byte) (crypto.PublicKey, int, error) {
+	decoded, rest, err := decodeCBOR(coseKey)
+	if err != nil {
+		return nil, 0, fmt.Errorf("webauthn: invalid public key: %w", err)
+	}
+	if len(rest) != 0 {
+		return nil, 0, errors.New("webauthn: trailing data after public key")
+	}
+	key, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, 0, errors.New("webauthn: public key is not a COSE key")
+	}
+	return publicKeyFromCOSE(key)
+}
+
+// publicKeyFromCOSE converts a decoded COSE_Key into a Go public key and returns it with its algorithm.
+func publicKeyFromCOSE(key map[interface{}]interface{}) (crypto.PublicKey, int, error) {
+	keyType, _ := cborMapInt(key, coseKeyType)
+	kty, ok := cborInt(keyType)
+	if !ok {
+		return nil, 0, errors.New("webauthn: public key without key type")
+	}
+	algorithm, _ := cborMapInt(key, coseAlgorithm)
+	alg, ok := cborInt(algorithm)
+	if !ok {
+		return nil, 0, errors.New("webauthn: public key without algorithm")
+	}
+	bytesParam := func(label int64) []byte {
+		value, _ := cborMapInt(key, label)
+		b, _ := value.([]byte)
+		return b
+	}
+	curve, _ := cborMapInt(key, coseCurve)
+	crv, _ := cborInt(curve)
+
+	switch {
+	case alg == AlgES256 && kty == coseKeyTypeEC2 && crv == coseCurveP256:
+		x, y := bytesParam(coseX), bytesParam(coseY)
+		if len(x) != 32 || len(y) != 32 {
+			return nil, 0, errors.New("webauthn: invalid P-256 key")
+		}
+		public := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !public.Curve.IsOnCurve(public.X, public.Y) {
+			return nil, 0, errors.New("webauthn: P-256 key is not on the curve")
+		}
+		return public, AlgES256, nil
+	case alg == AlgEdDSA && kty == coseKeyTypeOKP && crv == coseCurveEd25519:
+		x := bytesParam(coseX)
+		if len(x) != ed25519.PublicKeySize {
+			return nil, 0, errors.New("webauthn: invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), AlgEdDSA, nil
+	case alg == AlgRS256 && kty == coseKeyTypeRSA:
+		n, e := bytesParam(coseRSAN), bytesParam(coseRSAE)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, errors.New("webauthn: invalid RSA key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, AlgRS256, nil
+	default:
+		return nil, 0, fmt.Errorf("webauthn: unsupported key type %d with algorithm %d", kty, alg)
+	}
+}
+
+// verifySignature checks a signature made with the algorithm over data.
+func verifySignature(public crypto.PublicKey, alg int, data, signature []byte) error {
+	switch alg {
+	case AlgES256:
+		key, ok := public.(*ecdsa.PublicKey)
+		digest := sha256.Sum256(data)
+		if ok && ecdsa.VerifyASN1(key, digest[:], signature) {
+			return nil
+		}
+	case AlgEdDSA:
+		key, ok := public.(ed25519.PublicKey)
+		if ok && ed25519.Verify(key, data, signature) {
+			return nil
+		}
+	case AlgRS256:
+		key, ok := public.(*rsa.PublicKey)
+		digest := sha256.Sum256(data)
+		if ok && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil {
+			return nil
+		}
+	}
+	return errInvalidSignature
+}
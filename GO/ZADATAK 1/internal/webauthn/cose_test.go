@@ -0,0 +1,161 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/big"
+	"testing"
+)
+
+// coseEntry is one parameter of a COSE key built by a test.
+type coseEntry struct {
+	label int
+	value interface{} // int or []byte
+}
+
+// encodeCOSEKey encodes the entries as a CBOR map, in their order.
+func encodeCOSEKey(entries ...coseEntry) []byte {
+	item := func(value interface{}) []byte {
+		switch v := value.(type) {
+		case int:
+			if v >= 0 {
+				return cborHeader(0, uint64(v))
+			}
+			return cborHeader(1, uint64(-1-v))
+		case []byte:
+			return append(cborHeader(2, uint64(len(v))), v...)
+		}
+		panic("unsupported value")
+	}
+	out := cborHeader(5, uint64(len(entries)))
+	for _, entry := range entries {
+		out = append(out, item(entry.label)...)
+		out = append(out, item(entry.value)...)
+	}
+	return out
+}
+
+// cborHeader encodes an item header with a one-, two- or four-byte argument where needed.
+func cborHeader(major byte, argument uint64) []byte {
+	switch {
+	case argument < 24:
+		return []byte{major<<5 | byte(argument)}
+	case argument <= 0xff:
+		return []byte{major<<5 | 24, byte(argument)}
+	case argument <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(argument))
+	default:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(argument))
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	smallRSAKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, y := ecKey.X.FillBytes(make([]byte, 32)), ecKey.Y.FillBytes(make([]byte, 32))
+	exponent := big.NewInt(int64(rsaKey.E)).Bytes()
+
+	tests := []struct {
+		name    string
+		key     []byte
+		wantAlg int
+		wantErr bool
+	}{
+		{"ES256", encodeCOSEKey(coseEntry{1, 2}, coseEntry{3, AlgES256}, coseEntry{-1, 1}, coseEntry{-2, x}, coseEntry{-3, y}), AlgES256, false},
+		{"EdDSA", encodeCOSEKey(coseEntry{1, 1}, coseEntry{3, AlgEdDSA}, coseEntry{-1, 6}, coseEntry{-2, []byte(edPublic)}), AlgEdDSA, false},
+		{"RS256", encodeCOSEKey(coseEntry{1, 3}, coseEntry{3, AlgRS256}, coseEntry{-1, rsaKey.N.Bytes()}, coseEntry{-2, exponent}), AlgRS256, false},
+		{"ES256 point off the curve", encodeCOSEKey(coseEntry{1, 2}, coseEntry{3, AlgES256}, coseEntry{-1, 1}, coseEntry{-2, x}, coseEntry{-3, x}), 0, true},
+		{"ES256 short coordinate", encodeCOSEKey(coseEntry{1, 2}, coseEntry{3, AlgES256}, coseEntry{-1, 1}, coseEntry{-2, x[1:]}, coseEntry{-3, y}), 0, true},
+		{"ES256 on another curve", encodeCOSEKey(coseEntry{1, 2}, coseEntry{3, AlgES256}, coseEntry{-1, 2}, coseEntry{-2, x}, coseEntry{-3, y}), 0, true},
+		{"EdDSA short key", encodeCOSEKey(coseEntry{1, 1}, coseEntry{3, AlgEdDSA}, coseEntry{-1, 6}, coseEntry{-2, []byte(edPublic[:31])}), 0, true},
+		{"RS256 key under 2048 bits", encodeCOSEKey(coseEntry{1, 3}, coseEntry{3, AlgRS256}, coseEntry{-1, smallRSAKey.N.Bytes()}, coseEntry{-2, exponent}), 0, true},
+		{"algorithm of another key type", encodeCOSEKey(coseEntry{1, 1}, coseEntry{3, AlgES256}, coseEntry{-1, 6}, coseEntry{-2, []byte(edPublic)}), 0, true},
+		{"unsupported algorithm", encodeCOSEKey(coseEntry{1, 2}, coseEntry{3, -35}, coseEntry{-1, 1}, coseEntry{-2, x}, coseEntry{-3, y}), 0, true},
+		{"without key type", encodeCOSEKey(coseEntry{3, AlgES256}, coseEntry{-1, 1}, coseEntry{-2, x}, coseEntry{-3, y}), 0, true},
+		{"without algorithm", encodeCOSEKey(coseEntry{1, 2}, coseEntry{-1, 1}, coseEntry{-2, x}, coseEntry{-3, y}), 0, true},
+		{"not a map", []byte{0x00}, 0, true},
+		{"trailing data", append(encodeCOSEKey(coseEntry{1, 1}, coseEntry{3, AlgEdDSA}, coseEntry{-1, 6}, coseEntry{-2, []byte(edPublic)}), 0x00), 0, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, alg, err := ParsePublicKey(test.key)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ParsePublicKey = %v, want error: %v", err, test.wantErr)
+			}
+			if err == nil && (alg != test.wantAlg || key == nil) {
+				t.Errorf("ParsePublicKey = %T, %d; want algorithm %d", key, alg, test.wantAlg)
+			}
+		})
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	data := []byte("authenticator data and client data hash")
+	digest := sha256.Sum256(data)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecSignature, err := ecdsa.SignASN1(rand.Reader, ecKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaSignature, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		key       crypto.PublicKey
+		alg       int
+		data      []byte
+		signature []byte
+		wantErr   bool
+	}{
+		{"ES256", &ecKey.PublicKey, AlgES256, data, ecSignature, false},
+		{"EdDSA", edPublic, AlgEdDSA, data, ed25519.Sign(edPrivate, data), false},
+		{"RS256", &rsaKey.PublicKey, AlgRS256, data, rsaSignature, false},
+		{"ES256 over other data", &ecKey.PublicKey, AlgES256, []byte("other"), ecSignature, true},
+		{"EdDSA over other data", edPublic, AlgEdDSA, []byte("other"), ed25519.Sign(edPrivate, data), true},
+		{"RS256 over other data", &rsaKey.PublicKey, AlgRS256, []byte("other"), rsaSignature, true},
+		{"algorithm of another key", edPublic, AlgES256, data, ecSignature, true},
+		{"unsupported algorithm", &ecKey.PublicKey, -35, data, ecSignature, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := verifySignature(test.key, test.alg, test.data, test.signature); (err != nil) != test.wantErr {
+				t.Errorf("verifySignature = %v, want error: %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,534 @@
+// Package webauthn implements the relying party side of the WebAuthn ceremonies (W3C Web Authentication
+// Level 2) for registering passkeys and logging in with them. Options and responses use the JSON encoding
+// of the browser API, with binary values as unpadded base64url.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Values of the userVerification option.
+const (
+	UserVerificationRequired    = "required"
+	UserVerificationPreferred   = "preferred"
+	UserVerificationDiscouraged = "discouraged"
+)
+
+// Flags of the authenticator data.
+const (
+	flagUserPresent            = 0x01
+	flagUserVerified           = 0x04
+	flagBackupEligible         = 0x08
+	flagBackedUp               = 0x10
+	flagAttestedCredentialData = 0x40
+	flagExtensionData          = 0x80
+)
+
+// RelyingParty is this service as seen by authenticators. Credentials are scoped to its ID, and
+// ceremonies are only accepted from its origins.
+type RelyingParty struct {
+	ID      string   // RP ID: the domain credentials are scoped to, such as "notes.example.com"
+	Name    string   // Name shown by the authenticator when creating a credential
+	Origins []string // Origins the browser may report, such as "https://notes.example.com"
+}
+
+// RelyingPartyFromEnv returns the relying party configured by WEBAUTHN_RP_ID, WEBAUTHN_RP_NAME and
+// WEBAUTHN_ORIGINS (space-separated). The ID and origin default to the host and origin of baseURL.
+func RelyingPartyFromEnv(baseURL string) RelyingParty {
+	rp := RelyingParty{
+		ID:      os.Getenv("WEBAUTHN_RP_ID"),
+		Name:    os.Getenv("WEBAUTHN_RP_NAME"),
+		Origins: strings.Fields(os.Getenv("WEBAUTHN_ORIGINS")),
+	}
+	if parsed, err := url.Parse(baseURL); err == nil {
+		if rp.ID == "" {
+			rp.ID = parsed.Hostname()
+		}
+		if len(rp.Origins) == 0 {
+			rp.Origins = []string{parsed.Scheme + "://" + parsed.Host}
+		}
+	}
+	if rp.Name == "" {
+		rp.Name = "Notes"
+	}
+	return rp
+}
+
+// RelyingPartyEntity identifies the relying party in creation options.
+type RelyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// UserEntity identifies the account a credential is created for. ID is the user handle, base64url-encoded;
+// it is returned by discoverable credentials at login and must not contain personal information.
+type UserEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// CredentialParameter is a credential type and algorithm the relying party accepts.
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+// CredentialDescriptor refers to an existing credential, to exclude it from registration or allow it at login.
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+// AuthenticatorSelection states the requirements on the authenticator of a new credential.
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions are the options of navigator.credentials.create() for registering a credential.
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     RelyingPartyEntity     `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"` // Milliseconds
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions are the options of navigator.credentials.get() for logging in with a credential.
+// Without allowed credentials the authenticator offers its discoverable credentials for the RP ID.
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout"` // Milliseconds
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// AttestationResponse is the credential returned by navigator.credentials.create(), as encoded by its toJSON().
+type AttestationResponse struct {
+	ID       string                   `json:"id"`
+	RawID    string                   `json:"rawId"`
+	Type     string                   `json:"type"`
+	Response AuthenticatorAttestation `json:"response"`
+}
+
+// AuthenticatorAttestation is the authenticator's part of an AttestationResponse.
+type AuthenticatorAttestation struct {
+	ClientDataJSON    string   `json:"clientDataJSON"`
+	AttestationObject string   `json:"attestationObject"`
+	Transports        []string `json:"transports,omitempty"`
+}
+
+// AssertionResponse is the credential returned by navigator.credentials.get(), as encoded by its toJSON().
+type AssertionResponse struct {
+	ID       string                 `json:"id"`
+	RawID    string                 `json:"rawId"`
+	Type     string                 `json:"type"`
+	Response AuthenticatorAssertion `json:"response"`
+}
+
+// AuthenticatorAssertion is the authenticator's part of an AssertionResponse.
+type AuthenticatorAssertion struct {
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AuthenticatorData string `json:"authenticatorData"`
+	Signature         string `json:"signature"`
+	UserHandle        string `json:"userHandle,omitempty"`
+}
+
+// ClientData is the client data the browser collects and the authenticator signs.
+type ClientData struct {
+	Type        string `json:"type"` // "webauthn.create" or "webauthn.get"
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin,omitempty"`
+}
+
+// Credential is a credential created by a successful registration.
+type Credential struct {
+	ID             []byte
+	PublicKey      []byte // COSE_Key, as passed to VerifyAssertion
+	Algorithm      int
+	SignCount      uint32
+	AAGUID         []byte // Model of the authenticator; all zeros when not disclosed
+	Transports     []string
+	UserVerified   bool
+	BackupEligible bool // The credential can be synced to other devices
+	BackedUp       bool // The credential is currently synced
+}
+
+// Assertion is the result of a successful login with a credential.
+type Assertion struct {
+	SignCount    uint32
+	UserVerified bool
+	BackedUp     bool
+	UserHandle   []byte
+}
+
+// authenticatorData is the parsed authenticator data of a ceremony.
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+// NewChallenge returns a random challenge for a ceremony, base64url-encoded.
+func NewChallenge() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return EncodeBase64URL(buf), nil
+}
+
+// EncodeBase64URL encodes binary values the way the browser API serializes them.
+func EncodeBase64URL(value []byte) string {
+	return base64.RawURLEncoding.EncodeToString(value)
+}
+
+// DecodeBase64URL decodes a base64url value, with or without padding.
+func DecodeBase64URL(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+// CreationOptions returns the options for registering a credential for the user. Credentials that
+// are already registered are excluded, so that an authenticator is not registered twice.
+func (rp RelyingParty) CreationOptions(challenge string, user UserEntity, exclude []CredentialDescriptor, timeout time.Duration) CreationOptions {
+	params := make([]CredentialParameter, 0, len(SupportedAlgorithms))
+	for _, alg := range SupportedAlgorithms {
+		params = append(params, CredentialParameter{Type: "public-key", Alg: alg})
+	}
+	if exclude == nil {
+		exclude = []CredentialDescriptor{}
+	}
+	return CreationOptions{
+		Challenge:              challenge,
+		RP:                     RelyingPartyEntity{ID: rp.ID, Name: rp.Name},
+		User:                   user,
+		PubKeyCredParams:       params,
+		Timeout:                timeout.Milliseconds(),
+		ExcludeCredentials:     exclude,
+		AuthenticatorSelection: AuthenticatorSelection{ResidentKey: "preferred", UserVerification: UserVerificationPreferred},
+		Attestation:            "none",
+	}
+}
+
+// RequestOptions returns the options for logging in with one of the allowed credentials, or with any
+// discoverable credential if none are given.
+func (rp RelyingParty) RequestOptions(challenge string, allow []CredentialDescriptor, userVerification string, timeout time.Duration) RequestOptions {
+	if allow == nil {
+		allow = []CredentialDescriptor{}
+	}
+	return RequestOptions{
+		Challenge:        challenge,
+		Timeout:          timeout.Milliseconds(),
+		RPID:             rp.ID,
+		AllowCredentials: allow,
+		UserVerification: userVerification,
+	}
+}
+
+// ParseClientData decodes the base64url-encoded client data of a response, so that the challenge
+// it answers can be looked up before the response is verified.
+func ParseClientData(encoded string) (ClientData, error) {
+	raw, err := DecodeBase64URL(encoded)
+	if err != nil {
+		return ClientData{}, errors.New("webauthn: invalid client data encoding")
+	}
+	var clientData ClientData
+	if err := json.Unmarshal(raw, &clientData); err != nil {
+		return ClientData{}, errors.New("webauthn: invalid client data")
+	}
+	return clientData, nil
+}
+
+// CredentialID returns the ID of the credential that made an assertion, base64url-encoded.
+func (r AssertionResponse) CredentialID() (string, error) {
+	id := r.RawID
+	if id == "" {
+		id = r.ID
+	}
+	raw, err := DecodeBase64URL(id)
+	if err != nil || len(raw) == 0 {
+		return "", errors.New("webauthn: invalid credential ID")
+	}
+	return EncodeBase64URL(raw), nil
+}
+
+// VerifyRegistration verifies the response to a registration with the given challenge and returns the
+// new credential. Attestation is not used to decide whether to trust the authenticator: "none" is accepted,
+// and "packed" statements are only checked for consistency.
+func (rp RelyingParty) VerifyRegistration(response AttestationResponse, challenge string, requireUserVerification bool) (*Credential, error) {
+	if response.Type != "public-key" {
+		return nil, errors.New("webauthn: credential type must be public-key")
+	}
+	clientDataJSON, err := rp.verifyClientData(response.Response.ClientDataJSON, "webauthn.create", challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	rawObject, err := DecodeBase64URL(response.Response.AttestationObject)
+	if err != nil {
+		return nil, errors.New("webauthn: invalid attestation object encoding")
+	}
+	decoded, rest, err := decodeCBOR(rawObject)
+	if err != nil || len(rest) != 0 {
+		return nil, errors.New("webauthn: invalid attestation object")
+	}
+	object, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("webauthn: invalid attestation object")
+	}
+	format, _ := object["fmt"].(string)
+	statement, _ := object["attStmt"].(map[interface{}]interface{})
+	rawAuthData, _ := object["authData"].([]byte)
+	if statement == nil || rawAuthData == nil {
+		return nil, errors.New("webauthn: incomplete attestation object")
+	}
+
+	authData, err := rp.verifyAuthenticatorData(rawAuthData, requireUserVerification)
+	if err != nil {
+		return nil, err
+	}
+	if authData.flags&flagAttestedCredentialData == 0 {
+		return nil, errors.New("webauthn: no credential in the authenticator data")
+	}
+	if rawID, err := DecodeBase64URL(response.RawID); err != nil || !bytes.Equal(rawID, authData.credentialID) {
+		return nil, errors.New("webauthn: credential ID does not match the authenticator data")
+	}
+	publicKey, alg, err := ParsePublicKey(authData.publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), rawAuthData...), clientDataHash[:]...)
+	switch format {
+	case "none":
+		if len(statement) != 0 {
+			return nil, errors.New("webauthn: attestation statement of format none is not empty")
+		}
+	case "packed":
+		if err := verifyPackedAttestation(statement, signed, publicKey, alg); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("webauthn: unsupported attestation format %q", format)
+	}
+
+	return &Credential{
+		ID:             authData.credentialID,
+		PublicKey:      authData.publicKey,
+		Algorithm:      alg,
+		SignCount:      authData.signCount,
+		AAGUID:         authData.aaguid,
+		Transports:     response.Response.Transports,
+		UserVerified:   authData.flags&flagUserVerified != 0,
+		BackupEligible: authData.flags&flagBackupEligible != 0,
+		BackedUp:       authData.flags&flagBackedUp != 0,
+	}, nil
+}
+
+// VerifyAssertion verifies the response to a login with the given challenge against the stored public
+// key of the credential. The caller must check the returned sign count with SignCountValid.
+func (rp RelyingParty) VerifyAssertion(response AssertionResponse, challenge string, publicKey []byte, requireUserVerification bool) (*Assertion, error) {
+	if response.Type != "public-key" {
+		return nil, errors.New("webauthn: credential type must be public-key")
+	}
+	clientDataJSON, err := rp.verifyClientData(response.Response.ClientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return nil, err
+	}
+	rawAuthData, err := DecodeBase64URL(response.Response.AuthenticatorData)
+	if err != nil {
+		return nil, errors.New("webauthn: invalid authenticator data encoding")
+	}
+	authData, err := rp.verifyAuthenticatorData(rawAuthData, requireUserVerification)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := DecodeBase64URL(response.Response.Signature)
+	if err != nil {
+		return nil, errors.New("webauthn: invalid signature encoding")
+	}
+	userHandle, err := DecodeBase64URL(response.Response.UserHandle)
+	if err != nil {
+		return nil, errors.New("webauthn: invalid user handle encoding")
+	}
+
+	key, alg, err := ParsePublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	if err := verifySignature(key, alg, append(append([]byte(nil), rawAuthData...), clientDataHash[:]...), signature); err != nil {
+		return nil, err
+	}
+
+	return &Assertion{
+		SignCount:    authData.signCount,
+		UserVerified: authData.flags&flagUserVerified != 0,
+		BackedUp:     authData.flags&flagBackedUp != 0,
+		UserHandle:   userHandle,
+	}, nil
+}
+
+// SignCountValid reports whether the sign count of an assertion may follow the stored one. Counters must
+// grow; a counter that does not points to a cloned authenticator. Authenticators without counters,
+// such as synced passkeys, always report zero.
+func SignCountValid(stored, received uint32) bool {
+	if stored == 0 && received == 0 {
+		return true
+	}
+	return received > stored
+}
+
+// verifyClientData checks the type, challenge and origin of the client data and returns its raw JSON.
+func (rp RelyingParty) verifyClientData(encoded, ceremony, challenge string) ([]byte, error) {
+	raw, err := DecodeBase64URL(encoded)
+	if err != nil {
+		return nil, errors.New("webauthn: invalid client data encoding")
+	}
+	var clientData ClientData
+	if err := json.Unmarshal(raw, &clientData); err != nil {
+		return nil, errors.New("webauthn: invalid client data")
+	}
+	if clientData.Type != ceremony {
+		return nil, fmt.Errorf("webauthn: client data type must be %s", ceremony)
+	}
+	if subtle.ConstantTimeCompare([]byte(clientData.Challenge), []byte(challenge)) != 1 {
+		return nil, errors.New("webauthn: challenge does not match")
+	}
+	if clientData.CrossOrigin {
+		return nil, errors.New("webauthn: cross-origin ceremonies are not accepted")
+	}
+	for _, origin := range rp.Origins {
+		if clientData.Origin == origin {
+			return raw, nil
+		}
+	}
+	return nil, fmt.Errorf("webauthn: origin %q is not allowed", clientData.Origin)
+}
+
+// verifyAuthenticatorData parses the authenticator data and checks the RP ID hash and the user flags.
+func (rp RelyingParty) verifyAuthenticatorData(raw []byte, requireUserVerification bool) (authenticatorData, error) {
+	authData, err := parseAuthenticatorData(raw)
+	if err != nil {
+		return authenticatorData{}, err
+	}
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if subtle.ConstantTimeCompare(authData.rpIDHash, rpIDHash[:]) != 1 {
+		return authenticatorData{}, errors.New("webauthn: credential belongs to another relying party")
+	}
+	if authData.flags&flagUserPresent == 0 {
+		return authenticatorData{}, errors.New("webauthn: user was not present")
+	}
+	if requireUserVerification && authData.flags&flagUserVerified == 0 {
+		return authenticatorData{}, errors.New("webauthn: user was not verified")
+	}
+	return authData, nil
+}
+
+// parseAuthenticatorData splits authenticator data into its fields: the RP ID hash, flags, sign count,
+// the attested credential data if present, and the extensions if present.
+func parseAuthenticatorData(raw []byte) (authenticatorData, error) {
+	if len(raw) < 37 {
+		return authenticatorData{}, errors.New("webauthn: authenticator data too short")
+	}
+	authData := authenticatorData{
+		rpIDHash:  raw[:32],
+		flags:     raw[32],
+		signCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+	rest := raw[37:]
+
+	if authData.flags&flagAttestedCredentialData != 0 {
+		if len(rest) < 18 {
+			return authenticatorData{}, errors.New("webauthn: attested credential data too short")
+		}
+		authData.aaguid = rest[:16]
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLength == 0 || idLength > 1023 || len(rest) < idLength {
+			return authenticatorData{}, errors.New("webauthn: invalid credential ID length")
+		}
+		authData.credentialID = rest[:idLength]
+		rest = rest[idLength:]
+
+		// The public key is a CBOR item of unknown length; it ends where the decoder stops
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return authenticatorData{}, errors.New("webauthn: invalid credential public key")
+		}
+		authData.publicKey = rest[:len(rest)-len(after)]
+		rest = after
+	}
+	if authData.flags&flagExtensionData != 0 {
+		extensions, after, err := decodeCBOR(rest)
+		if err != nil {
+			return authenticatorData{}, errors.New("webauthn: invalid extension data")
+		}
+		if _, ok := extensions.(map[interface{}]interface{}); !ok {
+			return authenticatorData{}, errors.New("webauthn: invalid extension data")
+		}
+		rest = after
+	}
+	if len(rest) != 0 {
+		return authenticatorData{}, errors.New("webauthn: trailing data after authenticator data")
+	}
+	return authData, nil
+}
+
+// verifyPackedAttestation checks a "packed" attestation statement: a signature over the authenticator
+// data and client data hash, made by the credential key itself or by the attestation certificate.
+func verifyPackedAttestation(statement map[interface{}]interface{}, signed []byte, credentialKey interface{}, credentialAlg int) error {
+	alg, ok := cborInt(statement["alg"])
+	if !ok {
+		return errors.New("webauthn: packed attestation without algorithm")
+	}
+	signature, ok := statement["sig"].([]byte)
+	if !ok {
+		return errors.New("webauthn: packed attestation without signature")
+	}
+
+	chain, hasCertificate := statement["x5c"].([]interface{})
+	if !hasCertificate {
+		// Self attestation, signed with the credential key
+		if int(alg) != credentialAlg {
+			return errors.New("webauthn: self attestation algorithm does not match the credential")
+		}
+		return verifySignature(credentialKey, credentialAlg, signed, signature)
+	}
+	if len(chain) == 0 {
+		return errors.New("webauthn: packed attestation with empty certificate chain")
+	}
+	der, ok := chain[0].([]byte)
+	if !ok {
+		return errors.New("webauthn: invalid attestation certificate")
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return errors.New("webauthn: invalid attestation certificate")
+	}
+	return verifySignature(certificate.PublicKey, int(alg), signed, signature)
+}
@@ -0,0 +1,267 @@
+package webauthn_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"zadatak-filip-janjesic/internal/webauthn"
+	"zadatak-filip-janjesic/internal/webauthn/webauthntest"
+)
+
+const origin = "http://localhost:8080"
+
+var rp = webauthn.RelyingParty{ID: "localhost", Name: "Notes", Origins: []string{origin}}
+
+// register creates a credential on the authenticator and returns the response with its challenge.
+func register(t *testing.T, authenticator *webauthntest.Authenticator, rpID string) (webauthn.AttestationResponse, string) {
+	t.Helper()
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := rp.CreationOptions(challenge, webauthn.UserEntity{ID: webauthn.EncodeBase64URL([]byte{1}), Name: "john"}, nil, time.Minute)
+	options.RP.ID = rpID
+	response, err := authenticator.Create(options)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	return response, challenge
+}
+
+// login answers a login request for the credential and returns the response with its challenge.
+func login(t *testing.T, authenticator *webauthntest.Authenticator, credentialID []byte) (webauthn.AssertionResponse, string) {
+	t.Helper()
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	allow := []webauthn.CredentialDescriptor{{Type: "public-key", ID: webauthn.EncodeBase64URL(credentialID)}}
+	response, err := authenticator.Get(rp.RequestOptions(challenge, allow, webauthn.UserVerificationPreferred, time.Minute))
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	return response, challenge
+}
+
+// withClientData replaces one field of the encoded client data.
+func withClientData(t *testing.T, encoded string, change func(*webauthn.ClientData)) string {
+	t.Helper()
+	clientData, err := webauthn.ParseClientData(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	change(&clientData)
+	raw, err := json.Marshal(clientData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return webauthn.EncodeBase64URL(raw)
+}
+
+func TestRegistrationAndLogin(t *testing.T) {
+	authenticator := webauthntest.New(origin)
+	response, challenge := register(t, authenticator, rp.ID)
+
+	credential, err := rp.VerifyRegistration(response, challenge, true)
+	if err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+	if credential.Algorithm != webauthn.AlgES256 || !bytes.Equal(credential.AAGUID, webauthntest.AAGUID) || !credential.UserVerified || credential.SignCount != 0 {
+		t.Errorf("credential = %+v", credential)
+	}
+	if webauthn.EncodeBase64URL(credential.ID) != response.RawID {
+		t.Errorf("credential ID %x does not match the response", credential.ID)
+	}
+
+	stored := credential.SignCount
+	for i := 1; i <= 3; i++ {
+		assertion, challenge := login(t, authenticator, credential.ID)
+		result, err := rp.VerifyAssertion(assertion, challenge, credential.PublicKey, true)
+		if err != nil {
+			t.Fatalf("login %d: VerifyAssertion: %v", i, err)
+		}
+		if !bytes.Equal(result.UserHandle, []byte{1}) || !result.UserVerified {
+			t.Errorf("login %d: assertion = %+v", i, result)
+		}
+		if !webauthn.SignCountValid(stored, result.SignCount) {
+			t.Errorf("login %d: sign count %d does not follow %d", i, result.SignCount, stored)
+		}
+		stored = result.SignCount
+	}
+
+	// A clone of the authenticator reports a counter the server has already seen
+	authenticator.SetSignCount(1)
+	assertion, challenge := login(t, authenticator, credential.ID)
+	result, err := rp.VerifyAssertion(assertion, challenge, credential.PublicKey, true)
+	if err != nil {
+		t.Fatalf("VerifyAssertion: %v", err)
+	}
+	if webauthn.SignCountValid(stored, result.SignCount) {
+		t.Errorf("sign count %d accepted after %d", result.SignCount, stored)
+	}
+}
+
+func TestVerifyRegistrationRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		rpID   string // RP ID the authenticator creates the credential for
+		origin string // Origin the authenticator reports
+		noUV   bool   // The authenticator does not verify the user, while the server requires it
+		change func(*webauthn.AttestationResponse, *string)
+	}{
+		{"other challenge", rp.ID, origin, false, func(_ *webauthn.AttestationResponse, challenge *string) { *challenge = "other" }},
+		{"other origin", rp.ID, "https://evil.example", false, nil},
+		{"credential for another relying party", "evil.example", origin, false, nil},
+		{"user not verified", rp.ID, origin, true, nil},
+		{"login client data", rp.ID, origin, false, func(r *webauthn.AttestationResponse, _ *string) {
+			r.Response.ClientDataJSON = withClientData(t, r.Response.ClientDataJSON, func(c *webauthn.ClientData) { c.Type = "webauthn.get" })
+		}},
+		{"cross-origin", rp.ID, origin, false, func(r *webauthn.AttestationResponse, _ *string) {
+			r.Response.ClientDataJSON = withClientData(t, r.Response.ClientDataJSON, func(c *webauthn.ClientData) { c.CrossOrigin = true })
+		}},
+		{"other credential type", rp.ID, origin, false, func(r *webauthn.AttestationResponse, _ *string) { r.Type = "password" }},
+		{"other raw ID", rp.ID, origin, false, func(r *webauthn.AttestationResponse, _ *string) { r.RawID = webauthn.EncodeBase64URL([]byte("other")) }},
+		{"attestation object not base64url", rp.ID, origin, false, func(r *webauthn.AttestationResponse, _ *string) { r.Response.AttestationObject = "!!" }},
+		{"attestation object not CBOR", rp.ID, origin, false, func(r *webauthn.AttestationResponse, _ *string) {
+			r.Response.AttestationObject = webauthn.EncodeBase64URL([]byte{0xff})
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			authenticator := webauthntest.New(test.origin)
+			authenticator.UserVerified = !test.noUV
+			response, challenge := register(t, authenticator, test.rpID)
+			if test.change != nil {
+				test.change(&response, &challenge)
+			}
+			if _, err := rp.VerifyRegistration(response, challenge, true); err == nil {
+				t.Error("registration was accepted")
+			}
+		})
+	}
+}
+
+func TestVerifyAssertionRejects(t *testing.T) {
+	authenticator := webauthntest.New(origin)
+	response, challenge := register(t, authenticator, rp.ID)
+	credential, err := rp.VerifyRegistration(response, challenge, false)
+	if err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+	other, challenge := register(t, webauthntest.New(origin), rp.ID)
+	otherCredential, err := rp.VerifyRegistration(other, challenge, false)
+	if err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		noUV      bool
+		publicKey []byte
+		change    func(*webauthn.AssertionResponse, *string)
+	}{
+		{"other challenge", false, credential.PublicKey, func(_ *webauthn.AssertionResponse, challenge *string) { *challenge = "other" }},
+		{"public key of another credential", false, otherCredential.PublicKey, nil},
+		{"user not verified", true, credential.PublicKey, nil},
+		{"other origin", false, credential.PublicKey, func(r *webauthn.AssertionResponse, _ *string) {
+			r.Response.ClientDataJSON = withClientData(t, r.Response.ClientDataJSON, func(c *webauthn.ClientData) { c.Origin = "https://evil.example" })
+		}},
+		{"registration client data", false, credential.PublicKey, func(r *webauthn.AssertionResponse, _ *string) {
+			r.Response.ClientDataJSON = withClientData(t, r.Response.ClientDataJSON, func(c *webauthn.ClientData) { c.Type = "webauthn.create" })
+		}},
+		{"changed signature", false, credential.PublicKey, func(r *webauthn.AssertionResponse, _ *string) {
+			signature, _ := webauthn.DecodeBase64URL(r.Response.Signature)
+			signature[len(signature)-1] ^= 1
+			r.Response.Signature = webauthn.EncodeBase64URL(signature)
+		}},
+		{"truncated authenticator data", false, credential.PublicKey, func(r *webauthn.AssertionResponse, _ *string) {
+			r.Response.AuthenticatorData = webauthn.EncodeBase64URL(make([]byte, 36))
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			authenticator.UserVerified = !test.noUV
+			response, challenge := login(t, authenticator, credential.ID)
+			if test.change != nil {
+				test.change(&response, &challenge)
+			}
+			if _, err := rp.VerifyAssertion(response, challenge, test.publicKey, true); err == nil {
+				t.Error("assertion was accepted")
+			}
+		})
+	}
+}
+
+func TestSignCountValid(t *testing.T) {
+	tests := []struct {
+		stored, received uint32
+		want             bool
+	}{
+		{0, 0, true}, // No counter, as with synced passkeys
+		{0, 1, true},
+		{5, 6, true},
+		{5, 100, true},
+		{5, 5, false},
+		{5, 4, false},
+		{5, 0, false},
+	}
+	for _, test := range tests {
+		if got := webauthn.SignCountValid(test.stored, test.received); got != test.want {
+			t.Errorf("SignCountValid(%d, %d) = %v, want %v", test.stored, test.received, got, test.want)
+		}
+	}
+}
+
+func TestCredentialID(t *testing.T) {
+	id := webauthn.EncodeBase64URL([]byte("credential"))
+	tests := []struct {
+		name     string
+		response webauthn.AssertionResponse
+		want     string
+		wantErr  bool
+	}{
+		{"raw ID", webauthn.AssertionResponse{ID: "ignored", RawID: id}, id, false},
+		{"ID only", webauthn.AssertionResponse{ID: id}, id, false},
+		{"padded", webauthn.AssertionResponse{RawID: id + "=="}, id, false},
+		{"empty", webauthn.AssertionResponse{}, "", true},
+		{"not base64url", webauthn.AssertionResponse{RawID: "!!"}, "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.response.CredentialID()
+			if (err != nil) != test.wantErr || got != test.want {
+				t.Errorf("CredentialID = %q, %v; want %q, error: %v", got, err, test.want, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestRelyingPartyFromEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want webauthn.RelyingParty
+	}{
+		{"from the base URL", nil, webauthn.RelyingParty{ID: "notes.example.com", Name: "Notes", Origins: []string{"https://notes.example.com:8443"}}},
+		{"configured", map[string]string{"WEBAUTHN_RP_ID": "example.com", "WEBAUTHN_RP_NAME": "My notes", "WEBAUTHN_ORIGINS": "https://a.example.com https://b.example.com"},
+			webauthn.RelyingParty{ID: "example.com", Name: "My notes", Origins: []string{"https://a.example.com", "https://b.example.com"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, key := range []string{"WEBAUTHN_RP_ID", "WEBAUTHN_RP_NAME", "WEBAUTHN_ORIGINS"} {
+				t.Setenv(key, test.env[key])
+			}
+			got := webauthn.RelyingPartyFromEnv("https://notes.example.com:8443/api")
+			if got.ID != test.want.ID || got.Name != test.want.Name || len(got.Origins) != len(test.want.Origins) {
+				t.Fatalf("RelyingPartyFromEnv = %+v, want %+v", got, test.want)
+			}
+			for i := range got.Origins {
+				if got.Origins[i] != test.want.Origins[i] {
+					t.Errorf("origins = %v, want %v", got.Origins, test.want.Origins)
+				}
+			}
+		})
+	}
+}
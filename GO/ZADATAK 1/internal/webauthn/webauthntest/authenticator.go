@@ -0,0 +1,213 @@
+// Package webauthntest provides a software authenticator for exercising passkey registration and login
+// without hardware or a browser, in the spirit of net/http/httptest. It plays both the authenticator and
+// the browser: it builds the client data for an origin and answers the options returned by the server.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+
+	"zadatak-filip-janjesic/internal/webauthn" // Option and response types of the ceremonies
+)
+
+// AAGUID is the authenticator model reported by the virtual authenticator.
+var AAGUID = []byte("webauthntest-aag")
+
+// Authenticator is a virtual authenticator holding ES256 discoverable credentials.
+type Authenticator struct {
+	Origin       string // Origin reported in the client data, such as "http://localhost:8080"
+	UserVerified bool   // Whether ceremonies report user verification (a PIN or biometric check)
+	Counter      bool   // Whether credentials keep a sign counter; synced passkeys typically do not
+
+	mu          sync.Mutex
+	credentials map[string]*credential // Indexed by base64url credential ID
+}
+
+// credential is a key pair created for one relying party and user.
+type credential struct {
+	id         []byte
+	key        *ecdsa.PrivateKey
+	rpID       string
+	userHandle []byte
+	signCount  uint32
+}
+
+// New returns an authenticator for ceremonies from the given origin, with user verification and counters.
+func New(origin string) *Authenticator {
+	return &Authenticator{Origin: origin, UserVerified: true, Counter: true, credentials: make(map[string]*credential)}
+}
+
+// Create registers a new credential for the options of a registration, like navigator.credentials.create().
+func (a *Authenticator) Create(options webauthn.CreationOptions) (webauthn.AttestationResponse, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	supported := false
+	for _, param := range options.PubKeyCredParams {
+		supported = supported || param.Alg == webauthn.AlgES256
+	}
+	if !supported {
+		return webauthn.AttestationResponse{}, errors.New("webauthntest: ES256 not offered")
+	}
+	for _, excluded := range options.ExcludeCredentials {
+		if _, exists := a.credentials[excluded.ID]; exists {
+			return webauthn.AttestationResponse{}, errors.New("webauthntest: credential already registered")
+		}
+	}
+	userHandle, err := webauthn.DecodeBase64URL(options.User.ID)
+	if err != nil {
+		return webauthn.AttestationResponse{}, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return webauthn.AttestationResponse{}, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return webauthn.AttestationResponse{}, err
+	}
+	cred := &credential{id: id, key: key, rpID: options.RP.ID, userHandle: userHandle}
+
+	clientDataJSON, err := a.clientData("webauthn.create", options.Challenge)
+	if err != nil {
+		return webauthn.AttestationResponse{}, err
+	}
+
+	// Attested credential data: AAGUID, credential ID length and ID, and the COSE public key
+	attested := append([]byte(nil), AAGUID...)
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(id)))
+	attested = append(attested, id...)
+	attested = append(attested, encodeCOSEKey(&key.PublicKey)...)
+	authData := a.authenticatorData(cred, 0x40, attested)
+
+	attestationObject := encodeMap([]pair{
+		{"fmt", "none"},
+		{"attStmt", []pair{}},
+		{"authData", authData},
+	})
+	a.credentials[webauthn.EncodeBase64URL(id)] = cred
+
+	return webauthn.AttestationResponse{
+		ID:    webauthn.EncodeBase64URL(id),
+		RawID: webauthn.EncodeBase64URL(id),
+		Type:  "public-key",
+		Response: webauthn.AuthenticatorAttestation{
+			ClientDataJSON:    webauthn.EncodeBase64URL(clientDataJSON),
+			AttestationObject: webauthn.EncodeBase64URL(attestationObject),
+			Transports:        []string{"internal"},
+		},
+	}, nil
+}
+
+// Get answers the options of a login with one of the allowed credentials, or with any credential for the
+// RP ID if none are listed, like navigator.credentials.get().
+func (a *Authenticator) Get(options webauthn.RequestOptions) (webauthn.AssertionResponse, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	cred := a.find(options)
+	if cred == nil {
+		return webauthn.AssertionResponse{}, errors.New("webauthntest: no matching credential")
+	}
+	clientDataJSON, err := a.clientData("webauthn.get", options.Challenge)
+	if err != nil {
+		return webauthn.AssertionResponse{}, err
+	}
+	if a.Counter {
+		cred.signCount++
+	}
+	authData := a.authenticatorData(cred, 0, nil)
+
+	digest := sha256.Sum256(clientDataJSON)
+	signed := sha256.Sum256(append(append([]byte(nil), authData...), digest[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, cred.key, signed[:])
+	if err != nil {
+		return webauthn.AssertionResponse{}, err
+	}
+
+	return webauthn.AssertionResponse{
+		ID:    webauthn.EncodeBase64URL(cred.id),
+		RawID: webauthn.EncodeBase64URL(cred.id),
+		Type:  "public-key",
+		Response: webauthn.AuthenticatorAssertion{
+			ClientDataJSON:    webauthn.EncodeBase64URL(clientDataJSON),
+			AuthenticatorData: webauthn.EncodeBase64URL(authData),
+			Signature:         webauthn.EncodeBase64URL(signature),
+			UserHandle:        webauthn.EncodeBase64URL(cred.userHandle),
+		},
+	}, nil
+}
+
+// SetSignCount sets the sign counter of every credential, for example to simulate a cloned authenticator.
+func (a *Authenticator) SetSignCount(count uint32) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, cred := range a.credentials {
+		cred.signCount = count
+	}
+}
+
+// find returns the credential to answer a login with.
+func (a *Authenticator) find(options webauthn.RequestOptions) *credential {
+	if len(options.AllowCredentials) > 0 {
+		for _, allowed := range options.AllowCredentials {
+			if cred, ok := a.credentials[allowed.ID]; ok && cred.rpID == options.RPID {
+				return cred
+			}
+		}
+		return nil
+	}
+	// Pick deterministically among discoverable credentials
+	ids := make([]string, 0, len(a.credentials))
+	for id, cred := range a.credentials {
+		if cred.rpID == options.RPID {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	sort.Strings(ids)
+	return a.credentials[ids[0]]
+}
+
+// clientData returns the client data JSON a browser at the authenticator's origin would produce.
+func (a *Authenticator) clientData(ceremony, challenge string) ([]byte, error) {
+	return json.Marshal(webauthn.ClientData{Type: ceremony, Challenge: challenge, Origin: a.Origin})
+}
+
+// authenticatorData builds the authenticator data for a credential with the extra flags and data.
+func (a *Authenticator) authenticatorData(cred *credential, flags byte, extra []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(cred.rpID))
+	flags |= 0x01 // User present
+	if a.UserVerified {
+		flags |= 0x04
+	}
+	data := append([]byte(nil), rpIDHash[:]...)
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, cred.signCount)
+	return append(data, extra...)
+}
+
+// encodeCOSEKey encodes a P-256 public key as an ES256 COSE_Key.
+func encodeCOSEKey(key *ecdsa.PublicKey) []byte {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+	return encodeMap([]pair{
+		{1, 2},  // kty: EC2
+		{3, -7}, // alg: ES256
+		{-1, 1}, // crv: P-256
+		{-2, x}, // x
+		{-3, y}, // y
+	})
+}
@@ -0,0 +1,57 @@
+package webauthntest
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// pair is one entry of a CBOR map; maps are encoded in the order of their entries.
+type pair struct {
+	key   interface{}
+	value interface{}
+}
+
+// encodeMap encodes the entries as a CBOR map.
+func encodeMap(entries []pair) []byte {
+	return encode(entries)
+}
+
+// encode encodes the subset of CBOR the authenticator produces: integers, byte and text strings, and maps.
+func encode(value interface{}) []byte {
+	switch v := value.(type) {
+	case int:
+		if v >= 0 {
+			return header(0, uint64(v))
+		}
+		return header(1, uint64(-1-v))
+	case []byte:
+		return append(header(2, uint64(len(v))), v...)
+	case string:
+		return append(header(3, uint64(len(v))), v...)
+	case []pair:
+		out := header(5, uint64(len(v)))
+		for _, entry := range v {
+			out = append(out, encode(entry.key)...)
+			out = append(out, encode(entry.value)...)
+		}
+		return out
+	default:
+		panic(fmt.Sprintf("webauthntest: cannot encode %T", value))
+	}
+}
+
+// header encodes the header of an item with the major type and argument, in the shortest form.
+func header(major byte, argument uint64) []byte {
+	switch {
+	case argument < 24:
+		return []byte{major<<5 | byte(argument)}
+	case argument <= 0xff:
+		return []byte{major<<5 | 24, byte(argument)}
+	case argument <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(argument))
+	case argument <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(argument))
+	default:
+		return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, argument)
+	}
+}